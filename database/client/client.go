@@ -0,0 +1,229 @@
+package client
+
+import (
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Client is a client of the database service
+type Client struct {
+	c *wtclient.Client
+}
+
+// ListJobs gets a page of the jobs matching the filter
+func (cl *Client) ListJobs(ctx context.Context, filter wttypes.JobFilter) (wttypes.JobList, error) {
+	var resp struct {
+		Jobs       []wttypes.Job `json:"job"`
+		Total      int           `json:"total"`
+		NextCursor string        `json:"next_cursor"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/jobs?"+filter.Query().Encode(), nil, &resp)
+	if err != nil {
+		return wttypes.JobList{}, err
+	}
+
+	if resp.Jobs == nil {
+		resp.Jobs = []wttypes.Job{}
+	}
+
+	return wttypes.JobList{Jobs: resp.Jobs, Total: resp.Total, NextCursor: resp.NextCursor}, nil
+}
+
+// InsertJob adds a job and its transcodings, returning their IDs
+func (cl *Client) InsertJob(ctx context.Context, job wttypes.Job) (wttypes.JobIDs, error) {
+	var resp struct {
+		JobIDs wttypes.JobIDs `json:"job_ids"`
+	}
+
+	err := cl.c.Call(ctx, "POST", "/jobs", job, &resp)
+
+	return resp.JobIDs, err
+}
+
+// GetJob gets a job with its transcodings
+func (cl *Client) GetJob(ctx context.Context, id string) (wttypes.Job, error) {
+	var resp struct {
+		Job wttypes.Job `json:"job"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/jobs/"+id, nil, &resp)
+
+	return resp.Job, err
+}
+
+// UpdateJob updates a job
+func (cl *Client) UpdateJob(ctx context.Context, job wttypes.Job) error {
+	return cl.c.Call(ctx, "PUT", "/jobs/"+job.ID, job, nil)
+}
+
+// GetTranscoding gets a transcoding of a job
+func (cl *Client) GetTranscoding(ctx context.Context, id string) (wttypes.TranscodingTask, error) {
+	var resp struct {
+		Transcoding wttypes.TranscodingTask `json:"transcoding"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/transcodings/"+id, nil, &resp)
+
+	return resp.Transcoding, err
+}
+
+// UpdateTranscoding updates a transcoding of a job
+func (cl *Client) UpdateTranscoding(ctx context.Context, t wttypes.TranscodingTask) error {
+	return cl.c.Call(ctx, "PUT", "/transcodings/"+t.ID, t, nil)
+}
+
+// UpdateTranscodingProgress updates the progress of a running transcoding
+func (cl *Client) UpdateTranscodingProgress(ctx context.Context, id string, progress wttypes.TranscodingProgress) error {
+	return cl.c.Call(ctx, "PUT", "/transcodings/"+id+"/progress", progress, nil)
+}
+
+// UpdateWorkerStatus updates the status of a worker
+func (cl *Client) UpdateWorkerStatus(ctx context.Context, ws wttypes.WorkerStatus) error {
+	return cl.c.Call(ctx, "PUT", "/workers/status", ws, nil)
+}
+
+// ListProfiles gets all the transcoding profiles
+func (cl *Client) ListProfiles(ctx context.Context) ([]wttypes.Profile, error) {
+	var resp struct {
+		Profiles []wttypes.Profile `json:"profiles"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/profiles", nil, &resp)
+
+	return resp.Profiles, err
+}
+
+// GetProfile gets a transcoding profile by name
+func (cl *Client) GetProfile(ctx context.Context, name string) (wttypes.Profile, error) {
+	var resp struct {
+		Profile wttypes.Profile `json:"profile"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/profiles/"+name, nil, &resp)
+
+	return resp.Profile, err
+}
+
+// InsertProfile adds a transcoding profile
+func (cl *Client) InsertProfile(ctx context.Context, p wttypes.Profile) error {
+	return cl.c.Call(ctx, "POST", "/profiles", p, nil)
+}
+
+// UpdateProfile updates a transcoding profile
+func (cl *Client) UpdateProfile(ctx context.Context, p wttypes.Profile) error {
+	return cl.c.Call(ctx, "PUT", "/profiles/"+p.Name, p, nil)
+}
+
+// DeleteProfile deletes a transcoding profile
+func (cl *Client) DeleteProfile(ctx context.Context, name string) error {
+	return cl.c.Call(ctx, "DELETE", "/profiles/"+name, nil, nil)
+}
+
+// ListWebhookDeliveries gets the webhook deliveries of a job
+func (cl *Client) ListWebhookDeliveries(ctx context.Context, jobID string) ([]wttypes.WebhookDelivery, error) {
+	var resp struct {
+		Deliveries []wttypes.WebhookDelivery `json:"deliveries"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/jobs/"+jobID+"/webhooks", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Deliveries == nil {
+		resp.Deliveries = []wttypes.WebhookDelivery{}
+	}
+
+	return resp.Deliveries, nil
+}
+
+// InsertUpload adds a resumable upload, returning its ID
+func (cl *Client) InsertUpload(ctx context.Context, u wttypes.Upload) (string, error) {
+	var resp struct {
+		ID string `json:"upload_id"`
+	}
+
+	err := cl.c.Call(ctx, "POST", "/uploads", u, &resp)
+
+	return resp.ID, err
+}
+
+// GetUpload gets a resumable upload
+func (cl *Client) GetUpload(ctx context.Context, id string) (wttypes.Upload, error) {
+	var resp struct {
+		Upload wttypes.Upload `json:"upload"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/uploads/"+id, nil, &resp)
+
+	return resp.Upload, err
+}
+
+// AddUploadSegment adds an uploaded chunk to an upload, returning the upload with it
+func (cl *Client) AddUploadSegment(ctx context.Context, id string, seg wttypes.UploadSegment) (wttypes.Upload, error) {
+	var resp struct {
+		Upload wttypes.Upload `json:"upload"`
+	}
+
+	err := cl.c.Call(ctx, "POST", "/uploads/"+id+"/segments", seg, &resp)
+
+	return resp.Upload, err
+}
+
+// UpdateUploadStatus updates the status of an upload, when it is in from
+func (cl *Client) UpdateUploadStatus(ctx context.Context, id string, from string, status string, jobID string) error {
+	body := struct {
+		From   string `json:"from"`
+		Status string `json:"status"`
+		JobID  string `json:"job_id"`
+	}{
+		From:   from,
+		Status: status,
+		JobID:  jobID,
+	}
+
+	return cl.c.Call(ctx, "PUT", "/uploads/"+id+"/status", body, nil)
+}
+
+// UpdateUploadObject records the large object of an upload being finished
+func (cl *Client) UpdateUploadObject(ctx context.Context, id string, objectName string) error {
+	body := struct {
+		ObjectName string `json:"object_name"`
+	}{
+		ObjectName: objectName,
+	}
+
+	return cl.c.Call(ctx, "PUT", "/uploads/"+id+"/object", body, nil)
+}
+
+// ListStaleUploads gets the uploads still uploading or finishing not updated since before
+func (cl *Client) ListStaleUploads(ctx context.Context, before time.Time) ([]wttypes.Upload, error) {
+	var resp struct {
+		Uploads []wttypes.Upload `json:"uploads"`
+	}
+
+	query := url.Values{}
+	query.Set("updated_before", before.UTC().Format(time.RFC3339))
+
+	err := cl.c.Call(ctx, "GET", "/uploads?"+query.Encode(), nil, &resp)
+
+	return resp.Uploads, err
+}
+
+// DeleteUpload deletes a resumable upload
+func (cl *Client) DeleteUpload(ctx context.Context, id string) error {
+	return cl.c.Call(ctx, "DELETE", "/uploads/"+id, nil, nil)
+}
+
+// New creates a client of the database service at addr (https://server:port)
+func New(addr string, timeout time.Duration) *Client {
+	return &Client{
+		c: wtclient.New(addr, timeout),
+	}
+}
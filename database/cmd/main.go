@@ -1,11 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"golang.org/x/net/context"
@@ -19,8 +21,13 @@ func main() {
 	var err error
 
 	var (
-		httpAddr = ":" + wtcommon.DATABASE_PORT
+		httpAddr        = ":" + wtcommon.DATABASE_PORT
+		webhookInterval = flag.Duration("webhook-interval", 5*time.Second, "How often pending webhook deliveries are sent")
+		webhookTimeout  = flag.Duration("webhook-timeout", 10*time.Second, "Time a webhook client has to answer a delivery")
+		webhookAttempts = flag.Int("webhook-attempts", 8, "Times a webhook delivery is tried before giving up")
+		webhookBackoff  = flag.Duration("webhook-backoff", 30*time.Second, "Delay before trying again a webhook delivery, doubled on every attempt")
 	)
+	flag.Parse()
 
 	var logger log.Logger
 	{
@@ -37,7 +44,7 @@ func main() {
 
 	var ds database.Service
 	{
-		ds, err = database.NewService()
+		ds, err = database.NewService(*webhookTimeout, *webhookAttempts, *webhookBackoff)
 		if err != nil {
 			logger.Log("error", "Cannot create service: "+err.Error())
 			os.Exit(1)
@@ -56,6 +63,17 @@ func main() {
 		logger.Log("transport", "http", "address", httpAddr, "msg", "listening")
 		errs <- http.ListenAndServeTLS(httpAddr, "certs/server.pem", "certs/server.key", nil)
 	}()
+	go func() {
+		// Deliver webhooks of ended jobs and transcodings, retrying the failed ones
+		for {
+			time.Sleep(*webhookInterval)
+
+			_, err := ds.DeliverWebhooks()
+			if err != nil {
+				logger.Log("error", "Cannot deliver webhooks: "+err.Error())
+			}
+		}
+	}()
 	go func() {
 		c := make(chan os.Signal)
 		signal.Notify(c, syscall.SIGINT)
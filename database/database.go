@@ -1,8 +1,10 @@
 package database
 
 import (
-	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -17,6 +19,9 @@ const (
 	MongoTranscodingsCollection  = "transcodings"
 	MongoWorkersEventsCollection = "metrics_workers_events"
 	MongoWorkersCollection       = "workers"
+	MongoProfilesCollection      = "profiles"
+	MongoWebhooksCollection      = "webhooks"
+	MongoUploadsCollection       = "uploads"
 )
 
 type JobDB struct {
@@ -28,17 +33,79 @@ type JobDB struct {
 	Started    time.Time     `bson:"started"`
 	Ended      time.Time     `bson:"ended"`
 	Status     string        `bson:"status"`
+	Priority   int           `bson:"priority"`
+	Tenant     string        `bson:"tenant"`
+
+	Media *wttypes.MediaInfo  `bson:"media,omitempty"`
+	ABR   *wttypes.ABROptions `bson:"abr,omitempty"`
+
+	Chunking *wttypes.ChunkOptions `bson:"chunking,omitempty"`
+	Webhook  *wttypes.Webhook      `bson:"webhook,omitempty"`
 }
 
 type TranscodingProfileDB struct {
-	ID         bson.ObjectId `bson:"_id"`
-	JobID      string        `bson:"job_id"`
-	Profile    string        `bson:"profile"`
-	ObjectName string        `bson:"object_name"`
-	Added      time.Time     `bson:"added"`
-	Started    time.Time     `bson:"started"`
-	Ended      time.Time     `bson:"ended"`
-	Status     string        `bson:"status"`
+	ID         bson.ObjectId                `bson:"_id"`
+	JobID      string                       `bson:"job_id"`
+	Profile    string                       `bson:"profile"`
+	ObjectName string                       `bson:"object_name"`
+	Added      time.Time                    `bson:"added"`
+	Started    time.Time                    `bson:"started"`
+	Ended      time.Time                    `bson:"ended"`
+	Status     string                       `bson:"status"`
+	Progress   *wttypes.TranscodingProgress `bson:"progress,omitempty"`
+
+	Renditions []string            `bson:"renditions,omitempty"`
+	ABR        *wttypes.ABROptions `bson:"abr,omitempty"`
+
+	Kind       string                    `bson:"kind,omitempty"`
+	Thumbnails *wttypes.ThumbnailOptions `bson:"thumbnails,omitempty"`
+}
+
+// job returns the job without its transcodings, nor the secret of its webhook
+func (j JobDB) job() wttypes.Job {
+	return wttypes.Job{
+		ID:         j.ID.Hex(),
+		URLMedia:   j.URLMedia,
+		VideoName:  j.VideoName,
+		ObjectName: j.ObjectName,
+		Status:     j.Status,
+		Priority:   j.Priority,
+		Tenant:     j.Tenant,
+		Added:      timestamp(j.Added),
+		Started:    timestamp(j.Started),
+		Ended:      timestamp(j.Ended),
+		Media:      j.Media,
+		ABR:        j.ABR,
+		Chunking:   j.Chunking,
+		Webhook:    withoutSecret(j.Webhook),
+	}
+}
+
+func (t TranscodingProfileDB) transcoding() wttypes.TranscodingTask {
+	return wttypes.TranscodingTask{
+		ID:         t.ID.Hex(),
+		JobID:      t.JobID,
+		Profile:    t.Profile,
+		ObjectName: t.ObjectName,
+		Status:     t.Status,
+		Added:      timestamp(t.Added),
+		Started:    timestamp(t.Started),
+		Ended:      timestamp(t.Ended),
+		Progress:   t.Progress,
+		Renditions: t.Renditions,
+		ABR:        t.ABR,
+		Kind:       t.Kind,
+		Thumbnails: t.Thumbnails,
+	}
+}
+
+// timestamp returns nil for times never set
+func timestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
 }
 
 type WorkerEventDB struct {
@@ -49,9 +116,29 @@ type WorkerEventDB struct {
 }
 
 type WorkerDB struct {
-	Addr        string    `bson:"addr"`
-	LastUpdated time.Time `bson:"last_updated"`
-	Status      string    `bson:"status"`
+	Addr         string                      `bson:"addr"`
+	LastUpdated  time.Time                   `bson:"last_updated"`
+	Status       string                      `bson:"status"`
+	Capabilities *wttypes.WorkerCapabilities `bson:"capabilities,omitempty"`
+}
+
+type ProfileDB struct {
+	ID         bson.ObjectId         `bson:"_id"`
+	Name       string                `bson:"name"`
+	FFMPEG     wttypes.ProfileFFMPEG `bson:"ffmpeg"`
+	Resolution string                `bson:"resolution"`
+	MinCPUs    int                   `bson:"min_cpus"`
+	Added      time.Time             `bson:"added"`
+	Updated    time.Time             `bson:"updated"`
+}
+
+func (p ProfileDB) profile() wttypes.Profile {
+	return wttypes.Profile{
+		Name:       p.Name,
+		FFMPEG:     p.FFMPEG,
+		Resolution: p.Resolution,
+		MinCPUs:    p.MinCPUs,
+	}
 }
 
 type DataStore struct {
@@ -107,6 +194,30 @@ func CreateMongoSession() (*mgo.Session, error) {
 		return nil, err
 	}
 
+	idxAdded := mgo.Index{
+		Key:        []string{"added", "_id"},
+		Unique:     false,
+		DropDups:   false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxAdded)
+	if err != nil {
+		return nil, err
+	}
+
+	idxStatusAdded := mgo.Index{
+		Key:        []string{"status", "added", "_id"},
+		Unique:     false,
+		DropDups:   false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxStatusAdded)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get "transcodings" collection
 	c = session.DB(MongoDB).C(MongoTranscodingsCollection)
 
@@ -215,57 +326,334 @@ func CreateMongoSession() (*mgo.Session, error) {
 		return nil, err
 	}
 
+	// Get "webhooks" collection
+	c = session.DB(MongoDB).C(MongoWebhooksCollection)
+
+	// Indexes
+	idxWebhookJob := mgo.Index{
+		Key:        []string{"job_id"},
+		Unique:     false,
+		DropDups:   false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxWebhookJob)
+	if err != nil {
+		return nil, err
+	}
+
+	idxWebhookDue := mgo.Index{
+		Key:        []string{"status", "next_attempt"},
+		Unique:     false,
+		DropDups:   false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxWebhookDue)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get "uploads" collection
+	c = session.DB(MongoDB).C(MongoUploadsCollection)
+
+	// Indexes
+	idxUploadStale := mgo.Index{
+		Key:        []string{"status", "updated"},
+		Unique:     false,
+		DropDups:   false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxUploadStale)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get "profiles" collection
+	c = session.DB(MongoDB).C(MongoProfilesCollection)
+
+	// Indexes
+	idxProfileName := mgo.Index{
+		Key:        []string{"name"},
+		Unique:     true,
+		DropDups:   true,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxProfileName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateProfiles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// First time, start with the built-in profiles
+	total, err := c.Count()
+	if err != nil {
+		return nil, err
+	}
+
+	if total == 0 {
+		for _, v := range wttypes.NewProfile() {
+			err = c.Insert(ProfileDB{
+				ID:         bson.NewObjectId(),
+				Name:       v.Name,
+				FFMPEG:     v.FFMPEG,
+				Resolution: v.Resolution,
+				MinCPUs:    v.MinCPUs,
+				Added:      time.Now(),
+				Updated:    time.Now(),
+			})
+			if err != nil && !mgo.IsDup(err) {
+				return nil, err
+			}
+		}
+	}
+
 	return session, nil
 }
 
-func (ds *DataStore) ListJobs() ([]wttypes.Job, error) {
+// migrateProfiles converts the profiles stored with raw ffmpeg args into the
+// structured settings. Profiles that can't be converted are left as they are,
+// and we don't start until they are fixed or deleted.
+func migrateProfiles(c *mgo.Collection) error {
+	var old []struct {
+		ID     bson.ObjectId `bson:"_id"`
+		Name   string        `bson:"name"`
+		FFMPEG struct {
+			Name string `bson:"name"`
+			Args string `bson:"args"`
+		} `bson:"ffmpeg"`
+	}
+	err := c.Find(bson.M{"ffmpeg.args": bson.M{"$exists": true}}).All(&old)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, v := range old {
+		f, err := wttypes.ParseFFMPEGArgs(v.FFMPEG.Name, v.FFMPEG.Args)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%q)", v.Name, v.FFMPEG.Args))
+			continue
+		}
+
+		err = c.UpdateId(v.ID, bson.M{
+			"$set":   bson.M{"ffmpeg": f, "updated": time.Now()},
+			"$unset": bson.M{"encoders": ""},
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("[database] converted profile with old ffmpeg args:", v.Name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("profiles with old ffmpeg args that can't be converted, fix or delete them in the profiles collection: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func (ds *DataStore) ListJobs(f wttypes.JobFilter) (wttypes.JobList, error) {
 	// Get "jobs" collection
 	c := ds.session.DB(MongoDB).C(MongoJobsCollection)
 
-	// Get "transcodings" collection
-	ct := ds.session.DB(MongoDB).C(MongoTranscodingsCollection)
+	// Jobs matching the filter
+	conds := jobConditions(f)
+
+	total, err := c.Find(andConditions(conds)).Count()
+	if err != nil {
+		return wttypes.JobList{}, err
+	}
+
+	// Only the ones after the cursor, if any
+	if f.Cursor != "" {
+		cond, err := cursorCondition(f)
+		if err != nil {
+			return wttypes.JobList{}, err
+		}
+		conds = append(conds, cond)
+	}
+
+	// Sort by the field asked for, then by ID so ties keep an order for the cursor
+	field, id := f.SortField(), "_id"
+	if f.SortDescending() {
+		field, id = "-"+field, "-"+id
+	}
 
-	// Get all Jons, sort by added
+	// Get one more than the limit to know if there is a next page
 	results := []JobDB{}
-	err := c.Find(nil).Sort("added").All(&results)
+	err = c.Find(andConditions(conds)).Sort(field, id).Limit(f.Limit + 1).All(&results)
+	if err != nil {
+		return wttypes.JobList{}, err
+	}
+
+	list := wttypes.JobList{
+		Jobs:  []wttypes.Job{},
+		Total: total,
+	}
+
+	if len(results) > f.Limit {
+		results = results[:f.Limit]
+
+		last := results[len(results)-1]
+		list.NextCursor = wttypes.JobCursor{
+			Sort:  f.Sort,
+			Value: last.sortValue(f.SortField()),
+			ID:    last.ID.Hex(),
+		}.Encode()
+	}
+
+	if len(results) == 0 {
+		return list, nil
+	}
+
+	// Get the transcodings of all the jobs of the page at once
+	transcodings, err := ds.jobsTranscodings(results)
 	if err != nil {
-		return []wttypes.Job{}, err
+		return wttypes.JobList{}, err
 	}
 
-	jobs := []wttypes.Job{}
 	for _, v := range results {
-		job := wttypes.Job{
-			ID:         v.ID.Hex(),
-			URLMedia:   v.URLMedia,
-			VideoName:  v.VideoName,
-			ObjectName: v.ObjectName,
-			Status:     v.Status,
-		}
+		job := v.job()
 
-		// Query for this job transcodings
-		var resultsT []TranscodingProfileDB
-		err = ct.Find(bson.M{"job_id": v.ID}).All(&resultsT)
-		if err != nil {
-			return []wttypes.Job{}, err
+		job.Transcodings = transcodings[v.ID.Hex()]
+		if job.Transcodings == nil {
+			job.Transcodings = []wttypes.TranscodingTask{}
 		}
 
-		//Transcodings
-		transcodings := []wttypes.TranscodingTask{}
-		for _, vt := range resultsT {
-			t := wttypes.TranscodingTask{
-				ID:         vt.ID.Hex(),
-				Profile:    vt.Profile,
-				ObjectName: vt.ObjectName,
-				Status:     vt.Status,
-			}
-			transcodings = append(transcodings, t)
+		list.Jobs = append(list.Jobs, job)
+	}
+
+	return list, nil
+}
+
+// jobsTranscodings gets the transcodings of the jobs grouped by job ID, in the order they were added
+func (ds *DataStore) jobsTranscodings(jobs []JobDB) (map[string][]wttypes.TranscodingTask, error) {
+	// Get "transcodings" collection
+	c := ds.session.DB(MongoDB).C(MongoTranscodingsCollection)
+
+	ids := []string{}
+	for _, v := range jobs {
+		ids = append(ids, v.ID.Hex())
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"job_id": bson.M{"$in": ids}}},
+		{"$sort": bson.M{"_id": 1}},
+		{"$group": bson.M{"_id": "$job_id", "transcodings": bson.M{"$push": "$$ROOT"}}},
+	}
+
+	var results []struct {
+		JobID        string                 `bson:"_id"`
+		Transcodings []TranscodingProfileDB `bson:"transcodings"`
+	}
+	err := c.Pipe(pipeline).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	transcodings := map[string][]wttypes.TranscodingTask{}
+	for _, v := range results {
+		for _, vt := range v.Transcodings {
+			transcodings[v.JobID] = append(transcodings[v.JobID], vt.transcoding())
 		}
-		job.Transcodings = transcodings
+	}
+
+	return transcodings, nil
+}
+
+// jobConditions returns the query conditions of the filter
+func jobConditions(f wttypes.JobFilter) []bson.M {
+	conds := []bson.M{}
+
+	if f.Status != "" {
+		conds = append(conds, bson.M{"status": f.Status})
+	}
+
+	if f.VideoName != "" {
+		conds = append(conds, bson.M{"video_name": bson.RegEx{Pattern: regexp.QuoteMeta(f.VideoName), Options: "i"}})
+	}
+
+	if !f.From.IsZero() {
+		conds = append(conds, bson.M{"added": bson.M{"$gte": f.From}})
+	}
 
-		jobs = append(jobs, job)
+	if !f.To.IsZero() {
+		conds = append(conds, bson.M{"added": bson.M{"$lt": f.To}})
 	}
 
-	return jobs, nil
+	return conds
+}
+
+// cursorCondition returns the query condition for the jobs after the cursor, in the sort order
+func cursorCondition(f wttypes.JobFilter) (bson.M, error) {
+	cursor, err := wttypes.ParseJobCursor(f.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor.Sort != f.Sort || !bson.IsObjectIdHex(cursor.ID) {
+		return nil, wttypes.ErrInvalidCursor
+	}
+
+	field := f.SortField()
+
+	var value interface{}
+	switch field {
+	case "added", "started", "ended":
+		value, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case "priority":
+		value, err = strconv.Atoi(cursor.Value)
+	default:
+		value = cursor.Value
+	}
+	if err != nil {
+		return nil, wttypes.ErrInvalidCursor
+	}
+
+	op := "$gt"
+	if f.SortDescending() {
+		op = "$lt"
+	}
+
+	return bson.M{"$or": []bson.M{
+		{field: bson.M{op: value}},
+		{field: value, "_id": bson.M{op: bson.ObjectIdHex(cursor.ID)}},
+	}}, nil
+}
+
+// andConditions joins the conditions into a query
+func andConditions(conds []bson.M) interface{} {
+	if len(conds) == 0 {
+		return nil
+	}
+
+	return bson.M{"$and": conds}
+}
+
+// sortValue returns the value of the field the job is sorted by, as saved in a cursor
+func (j JobDB) sortValue(field string) string {
+	switch field {
+	case "started":
+		return j.Started.UTC().Format(time.RFC3339Nano)
+	case "ended":
+		return j.Ended.UTC().Format(time.RFC3339Nano)
+	case "video_name":
+		return j.VideoName
+	case "status":
+		return j.Status
+	case "priority":
+		return strconv.Itoa(j.Priority)
+	default:
+		return j.Added.UTC().Format(time.RFC3339Nano)
+	}
 }
 
 func (ds *DataStore) InsertJob(job wttypes.Job) (wttypes.JobIDs, error) {
@@ -279,6 +667,12 @@ func (ds *DataStore) InsertJob(job wttypes.Job) (wttypes.JobIDs, error) {
 		ObjectName: job.ObjectName,
 		Added:      time.Now(),
 		Status:     job.Status,
+		Priority:   job.Priority,
+		Tenant:     job.Tenant,
+		Media:      job.Media,
+		ABR:        job.ABR,
+		Chunking:   job.Chunking,
+		Webhook:    job.Webhook,
 	}
 
 	// Get "jobs" collection
@@ -315,11 +709,19 @@ func (ds *DataStore) InsertJob(job wttypes.Job) (wttypes.JobIDs, error) {
 			ObjectName: v.ObjectName,
 			Added:      time.Now(),
 			Status:     tstatus,
+			Renditions: v.Renditions,
+			ABR:        v.ABR,
+			Kind:       v.Kind,
+			Thumbnails: v.Thumbnails,
 		}
 
 		tt := wttypes.TranscodingTask{
-			ID:      tid.Hex(),
-			Profile: v.Profile,
+			ID:         tid.Hex(),
+			Profile:    v.Profile,
+			Renditions: v.Renditions,
+			ABR:        v.ABR,
+			Kind:       v.Kind,
+			Thumbnails: v.Thumbnails,
 		}
 
 		// Insert Transcoding
@@ -331,6 +733,14 @@ func (ds *DataStore) InsertJob(job wttypes.Job) (wttypes.JobIDs, error) {
 		ids.Transcodings = append(ids.Transcodings, tt)
 	}
 
+	// Job that couldn't even start
+	if j.Status == wttypes.JOB_ERROR {
+		err = ds.queueWebhook(jid.Hex(), wttypes.EVENT_JOB_ERROR, nil)
+		if err != nil {
+			fmt.Println("[err] queueWebhook:", err)
+		}
+	}
+
 	fmt.Println("[database] ids on insert:", ids)
 	return ids, nil
 }
@@ -338,7 +748,7 @@ func (ds *DataStore) InsertJob(job wttypes.Job) (wttypes.JobIDs, error) {
 func (ds *DataStore) GetJob(id string) (wttypes.Job, error) {
 	// Check is a valid ID
 	if !bson.IsObjectIdHex(id) {
-		return wttypes.Job{}, errors.New("Invalid ID")
+		return wttypes.Job{}, wttypes.ErrInvalidID
 	}
 
 	// Get "jobs" collection
@@ -353,13 +763,7 @@ func (ds *DataStore) GetJob(id string) (wttypes.Job, error) {
 		return wttypes.Job{}, err
 	}
 
-	job := wttypes.Job{
-		ID:         result.ID.Hex(),
-		URLMedia:   result.URLMedia,
-		VideoName:  result.VideoName,
-		ObjectName: result.ObjectName,
-		Status:     result.Status,
-	}
+	job := result.job()
 
 	// Get "transcodings" collection
 	c = ds.session.DB(MongoDB).C(MongoTranscodingsCollection)
@@ -374,13 +778,7 @@ func (ds *DataStore) GetJob(id string) (wttypes.Job, error) {
 	//Transcodings
 	transcodings := []wttypes.TranscodingTask{}
 	for _, v := range results {
-		t := wttypes.TranscodingTask{
-			ID:         v.ID.Hex(),
-			Profile:    v.Profile,
-			ObjectName: v.ObjectName,
-			Status:     v.Status,
-		}
-		transcodings = append(transcodings, t)
+		transcodings = append(transcodings, v.transcoding())
 	}
 	job.Transcodings = transcodings
 
@@ -390,7 +788,7 @@ func (ds *DataStore) GetJob(id string) (wttypes.Job, error) {
 func (ds *DataStore) GetTranscoding(id string) (wttypes.TranscodingTask, error) {
 	// Check is a valid ID
 	if !bson.IsObjectIdHex(id) {
-		return wttypes.TranscodingTask{}, errors.New("Invalid ID")
+		return wttypes.TranscodingTask{}, wttypes.ErrInvalidID
 	}
 
 	// Get "transcodings" collection
@@ -405,20 +803,13 @@ func (ds *DataStore) GetTranscoding(id string) (wttypes.TranscodingTask, error)
 		return wttypes.TranscodingTask{}, err
 	}
 
-	t := wttypes.TranscodingTask{
-		ID:         result.ID.Hex(),
-		Profile:    result.Profile,
-		ObjectName: result.ObjectName,
-		Status:     result.Status,
-	}
-
-	return t, nil
+	return result.transcoding(), nil
 }
 
 func (ds *DataStore) UpdateTranscoding(t wttypes.TranscodingTask) error {
 	// Check is a valid ID
 	if !bson.IsObjectIdHex(t.ID) {
-		return errors.New("Invalid ID")
+		return wttypes.ErrInvalidID
 	}
 
 	// Get "transcodings" collection
@@ -427,18 +818,46 @@ func (ds *DataStore) UpdateTranscoding(t wttypes.TranscodingTask) error {
 	// Query for transcoding
 	tid := bson.ObjectIdHex(t.ID)
 
-	oldt := TranscodingProfileDB{}
-	err := c.FindId(tid).One(&oldt)
-	if err != nil {
-		return err
+	// Set only what changed, and only if the status is still the one we read,
+	// so a late update (e.g. a cancel racing its end) doesn't undo another one
+	var oldt TranscodingProfileDB
+	for {
+		err := c.FindId(tid).One(&oldt)
+		if err != nil {
+			return err
+		}
+
+		// Ended already, nothing else changes it
+		if transcodingEnded(oldt.Status) {
+			fmt.Println("[database] transcoding already ended:", t.ID, oldt.Status, t.Status)
+			return nil
+		}
+
+		now := time.Now()
+		set := bson.M{"status": t.Status}
+		if t.ObjectName != "" {
+			set["object_name"] = t.ObjectName
+		}
+		if t.Status == wttypes.TRANSCODING_RUNNING && oldt.Status == wttypes.TRANSCODING_QUEUED {
+			set["started"] = now
+		}
+		if (t.Status == wttypes.TRANSCODING_FINISHED || t.Status == wttypes.TRANSCODING_SKIPPED) && oldt.Status == wttypes.TRANSCODING_RUNNING {
+			set["ended"] = now
+		}
+
+		err = c.Update(bson.M{"_id": tid, "status": oldt.Status}, bson.M{"$set": set})
+		if err == nil {
+			break
+		}
+		if err != mgo.ErrNotFound {
+			return err
+		}
+
+		// Changed meanwhile, look at it again
 	}
 
-	// Update Started if needed
-	started := oldt.Started
+	// Change job status to RUNNING if needed
 	if t.Status == wttypes.TRANSCODING_RUNNING && oldt.Status == wttypes.TRANSCODING_QUEUED {
-		started = time.Now()
-
-		// Change job status to RUNNING if needed
 		job, err := ds.GetJob(oldt.JobID)
 		if err == nil && job.Status == wttypes.JOB_QUEUED {
 			job.Status = wttypes.JOB_RUNNING
@@ -446,34 +865,19 @@ func (ds *DataStore) UpdateTranscoding(t wttypes.TranscodingTask) error {
 		}
 	}
 
-	// Update Ended if needed
-	ended := oldt.Ended
-	if t.Status == wttypes.TRANSCODING_FINISHED && oldt.Status == wttypes.TRANSCODING_RUNNING {
-		ended = time.Now()
-	}
-
-	// Update document
-	newt := TranscodingProfileDB{
-		ID: tid,
-
-		Profile:    t.Profile,
-		ObjectName: t.ObjectName,
-		Status:     t.Status,
-		Started:    started,
-		Ended:      ended,
-
-		JobID: oldt.JobID,
-		Added: oldt.Added,
-	}
-
-	// Update in DB
-	_, err = c.UpsertId(tid, newt)
-	if err != nil {
-		return err
+	// Chunks are only a step of the transcoding of their profile
+	if t.Status == wttypes.TRANSCODING_FINISHED && oldt.Kind != wttypes.TASK_CHUNK {
+		tt, err := ds.GetTranscoding(t.ID)
+		if err == nil {
+			err = ds.queueWebhook(oldt.JobID, wttypes.EVENT_TRANSCODING_FINISHED, &tt)
+		}
+		if err != nil {
+			fmt.Println("[err] queueWebhook:", err)
+		}
 	}
 
-	// If we finished, let's see if we can mark Job as FINISHED
-	if t.Status == wttypes.TRANSCODING_FINISHED {
+	// If we ended (or there was nothing to do), let's see if we can mark Job as FINISHED (or ERROR)
+	if t.Status == wttypes.TRANSCODING_FINISHED || t.Status == wttypes.TRANSCODING_SKIPPED || t.Status == wttypes.TRANSCODING_ERROR {
 		// Look for pending transcodings of same job
 		jid := bson.ObjectIdHex(oldt.JobID)
 
@@ -481,34 +885,47 @@ func (ds *DataStore) UpdateTranscoding(t wttypes.TranscodingTask) error {
 			Status string `bson:"status"`
 		}
 		condStatus := bson.M{"$in": []string{wttypes.TRANSCODING_QUEUED, wttypes.TRANSCODING_RUNNING}}
-		err = c.Find(bson.M{"job_id": jid.Hex(), "status": condStatus}).Select(bson.M{"status": 1}).All(&results)
+		err := c.Find(bson.M{"job_id": jid.Hex(), "status": condStatus}).Select(bson.M{"status": 1}).All(&results)
 		if err != nil {
 			return err
 		}
 
 		// Update job in case no more pending transcodings...
 		if len(results) == 0 {
+			// ...failing it if any of them did
+			failed, err := c.Find(bson.M{"job_id": jid.Hex(), "status": wttypes.TRANSCODING_ERROR}).Count()
+			if err != nil {
+				return err
+			}
+
+			status, event := wttypes.JOB_FINISHED, wttypes.EVENT_JOB_FINISHED
+			if failed > 0 {
+				status, event = wttypes.JOB_ERROR, wttypes.EVENT_JOB_ERROR
+			}
+
 			fmt.Println("no more transcodings for this job!! let's see if we need to update it on DB:", jid.Hex())
 			// Get "jobs" collection
 			c = ds.session.DB(MongoDB).C(MongoJobsCollection)
 
-			job := JobDB{}
-			err := c.FindId(jid).One(&job)
+			// Only update if job was queued or running, so when several transcodings
+			// end at once only one of them ends the job (and queues its webhook)
+			condJobStatus := bson.M{"$in": []string{wttypes.JOB_QUEUED, wttypes.JOB_RUNNING}}
+			err = c.Update(bson.M{"_id": jid, "status": condJobStatus}, bson.M{"$set": bson.M{
+				"status": status,
+				"ended":  time.Now(),
+			}})
+			if err == mgo.ErrNotFound {
+				return nil
+			}
 			if err != nil {
 				return err
 			}
 
-			// Only update if job was queued or running
-			if job.Status == wttypes.JOB_QUEUED || job.Status == wttypes.JOB_RUNNING {
-				fmt.Println("marking job as finished:", jid.Hex())
-				job.Status = wttypes.JOB_FINISHED
-				job.Ended = time.Now()
-
-				// Update in DB
-				_, err = c.UpsertId(jid, job)
-				if err != nil {
-					return err
-				}
+			fmt.Println("marked job as "+status+":", jid.Hex())
+
+			err = ds.queueWebhook(jid.Hex(), event, nil)
+			if err != nil {
+				fmt.Println("[err] queueWebhook:", err)
 			}
 		}
 	}
@@ -516,10 +933,42 @@ func (ds *DataStore) UpdateTranscoding(t wttypes.TranscodingTask) error {
 	return nil
 }
 
+// UpdateTranscodingProgress sets the progress of a transcoding, only while it's
+// running: a late report can't bring back a transcoding already ended.
+// transcodingEnded tells if a transcoding with the status won't change anymore
+func transcodingEnded(status string) bool {
+	switch status {
+	case wttypes.TRANSCODING_FINISHED, wttypes.TRANSCODING_SKIPPED, wttypes.TRANSCODING_ERROR, wttypes.TRANSCODING_CANCELLED:
+		return true
+	}
+
+	return false
+}
+
+func (ds *DataStore) UpdateTranscodingProgress(id string, progress wttypes.TranscodingProgress) error {
+	// Check is a valid ID
+	if !bson.IsObjectIdHex(id) {
+		return wttypes.ErrInvalidID
+	}
+
+	// Get "transcodings" collection
+	c := ds.session.DB(MongoDB).C(MongoTranscodingsCollection)
+
+	tid := bson.ObjectIdHex(id)
+
+	err := c.Update(bson.M{"_id": tid, "status": wttypes.TRANSCODING_RUNNING}, bson.M{"$set": bson.M{"progress": progress}})
+	if err == mgo.ErrNotFound {
+		// Either it's not there or not running anymore, then it's dropped
+		_, err = ds.GetTranscoding(id)
+	}
+
+	return err
+}
+
 func (ds *DataStore) UpdateJob(job wttypes.Job) error {
 	// Check is a valid ID
 	if !bson.IsObjectIdHex(job.ID) {
-		return errors.New("Invalid ID")
+		return wttypes.ErrInvalidID
 	}
 
 	// Get "jobs" collection
@@ -528,42 +977,54 @@ func (ds *DataStore) UpdateJob(job wttypes.Job) error {
 	// Query for job
 	jid := bson.ObjectIdHex(job.ID)
 
-	oldj := JobDB{}
-	err := c.FindId(jid).One(&oldj)
-	if err != nil {
-		return err
+	// Only a job that didn't end can change, so e.g. cancelling it while its
+	// last transcoding ends doesn't turn a finished job into a cancelled one
+	now := time.Now()
+	set := bson.M{"status": job.Status}
+	condStatus := []string{wttypes.JOB_QUEUED, wttypes.JOB_RUNNING}
+	switch job.Status {
+	case wttypes.JOB_RUNNING:
+		set["started"] = now
+		condStatus = []string{wttypes.JOB_QUEUED}
+	case wttypes.JOB_FINISHED, wttypes.JOB_ERROR, wttypes.JOB_CANCELLED:
+		set["ended"] = now
 	}
 
-	// Update Started if needed
-	started := oldj.Started
-	if job.Status == wttypes.JOB_RUNNING && oldj.Status == wttypes.JOB_QUEUED {
-		started = time.Now()
+	err := c.Update(bson.M{"_id": jid, "status": bson.M{"$in": condStatus}}, bson.M{"$set": set})
+	if err == mgo.ErrNotFound {
+		// Either it's not there or it changed already
+		n, errC := c.FindId(jid).Count()
+		if errC != nil {
+			return errC
+		}
+		if n == 0 {
+			return mgo.ErrNotFound
+		}
+		if job.Status == wttypes.JOB_CANCELLED {
+			return wttypes.ErrCantCancel
+		}
+		return nil
 	}
-
-	// Update Ended if needed
-	ended := oldj.Ended
-	if job.Status == wttypes.JOB_FINISHED && oldj.Status == wttypes.JOB_RUNNING {
-		ended = time.Now()
+	if err != nil {
+		return err
 	}
 
-	// Update document
-	newj := JobDB{
-		ID: jid,
-
-		Started: started,
-		Ended:   ended,
-		Status:  job.Status,
-
-		URLMedia:   oldj.URLMedia,
-		VideoName:  oldj.VideoName,
-		ObjectName: oldj.ObjectName,
-		Added:      oldj.Added,
+	// Let the client know the job ended
+	event := ""
+	switch job.Status {
+	case wttypes.JOB_FINISHED:
+		event = wttypes.EVENT_JOB_FINISHED
+	case wttypes.JOB_ERROR:
+		event = wttypes.EVENT_JOB_ERROR
+	case wttypes.JOB_CANCELLED:
+		event = wttypes.EVENT_JOB_CANCELLED
 	}
 
-	// Update in DB
-	_, err = c.UpsertId(jid, newj)
-	if err != nil {
-		return err
+	if event != "" {
+		err = ds.queueWebhook(jid.Hex(), event, nil)
+		if err != nil {
+			fmt.Println("[err] queueWebhook:", err)
+		}
 	}
 
 	return nil
@@ -589,25 +1050,113 @@ func (ds *DataStore) AddWorkerEvent(addr string, event string) error {
 	return err
 }
 
-func (ds *DataStore) UpdateWorkerStatus(addr string, status string) error {
-	fmt.Println("UpdateWorkerStatus:", addr, status)
+func (ds *DataStore) UpdateWorkerStatus(ws wttypes.WorkerStatus) error {
+	fmt.Println("UpdateWorkerStatus:", ws.Addr, ws.Status)
 
 	// Get "workers" collection
 	c := ds.session.DB(MongoDB).C(MongoWorkersCollection)
 
-	w := WorkerDB{
-		Addr:        addr,
-		LastUpdated: time.Now(),
-		Status:      status,
+	set := bson.M{
+		"addr":         ws.Addr,
+		"last_updated": time.Now(),
+		"status":       ws.Status,
+	}
+
+	// Capabilities are only sent on register, keep them on status updates
+	if ws.Capabilities != nil {
+		set["capabilities"] = ws.Capabilities
 	}
 
 	// Update/Insert in DB
-	_, err := c.Upsert(bson.M{"addr": addr}, w)
+	_, err := c.Upsert(bson.M{"addr": ws.Addr}, bson.M{"$set": set})
 	if err != nil {
 		return err
 	}
 
-	err = ds.AddWorkerEvent(addr, status)
+	err = ds.AddWorkerEvent(ws.Addr, ws.Status)
+
+	return err
+}
+
+func (ds *DataStore) ListProfiles() ([]wttypes.Profile, error) {
+	// Get "profiles" collection
+	c := ds.session.DB(MongoDB).C(MongoProfilesCollection)
+
+	results := []ProfileDB{}
+	err := c.Find(nil).Sort("name").All(&results)
+	if err != nil {
+		return []wttypes.Profile{}, err
+	}
+
+	profiles := []wttypes.Profile{}
+	for _, v := range results {
+		profiles = append(profiles, v.profile())
+	}
+
+	return profiles, nil
+}
+
+func (ds *DataStore) GetProfile(name string) (wttypes.Profile, error) {
+	// Get "profiles" collection
+	c := ds.session.DB(MongoDB).C(MongoProfilesCollection)
+
+	result := ProfileDB{}
+	err := c.Find(bson.M{"name": name}).One(&result)
+	if err == mgo.ErrNotFound {
+		return wttypes.Profile{}, wttypes.ErrProfileNotFound
+	}
+	if err != nil {
+		return wttypes.Profile{}, err
+	}
+
+	return result.profile(), nil
+}
+
+func (ds *DataStore) InsertProfile(p wttypes.Profile) error {
+	// Get "profiles" collection
+	c := ds.session.DB(MongoDB).C(MongoProfilesCollection)
+
+	err := c.Insert(ProfileDB{
+		ID:         bson.NewObjectId(),
+		Name:       p.Name,
+		FFMPEG:     p.FFMPEG,
+		Resolution: p.Resolution,
+		MinCPUs:    p.MinCPUs,
+		Added:      time.Now(),
+		Updated:    time.Now(),
+	})
+	if mgo.IsDup(err) {
+		return wttypes.ErrProfileExists
+	}
+
+	return err
+}
+
+func (ds *DataStore) UpdateProfile(p wttypes.Profile) error {
+	// Get "profiles" collection
+	c := ds.session.DB(MongoDB).C(MongoProfilesCollection)
+
+	err := c.Update(bson.M{"name": p.Name}, bson.M{"$set": bson.M{
+		"ffmpeg":     p.FFMPEG,
+		"resolution": p.Resolution,
+		"min_cpus":   p.MinCPUs,
+		"updated":    time.Now(),
+	}})
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrProfileNotFound
+	}
+
+	return err
+}
+
+func (ds *DataStore) DeleteProfile(name string) error {
+	// Get "profiles" collection
+	c := ds.session.DB(MongoDB).C(MongoProfilesCollection)
+
+	err := c.Remove(bson.M{"name": name})
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrProfileNotFound
+	}
 
 	return err
 }
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"time"
+
 	"github.com/go-kit/kit/endpoint"
 	"golang.org/x/net/context"
 
@@ -10,20 +12,23 @@ import (
 // ListJobs
 
 type listJobsRequest struct {
+	Filter wttypes.JobFilter
 }
 
 type listJobsResponse struct {
-	Jobs []wttypes.Job `json:"job,omitempty"`
-	Err  error         `json:"error,omitempty"`
+	Jobs       []wttypes.Job `json:"job,omitempty"`
+	Total      int           `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Err        error         `json:"error,omitempty"`
 }
 
 func (r listJobsResponse) error() error { return r.Err }
 
 func makeListJobsEndpoint(ds Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		_ = request.(listJobsRequest)
-		jobs, err := ds.ListJobs()
-		return listJobsResponse{Jobs: jobs, Err: err}, nil
+		req := request.(listJobsRequest)
+		list, err := ds.ListJobs(req.Filter)
+		return listJobsResponse{Jobs: list.Jobs, Total: list.Total, NextCursor: list.NextCursor, Err: err}, nil
 	}
 }
 
@@ -111,6 +116,28 @@ func makeUpdateTranscodingEndpoint(ds Service) endpoint.Endpoint {
 	}
 }
 
+// UpdateTranscodingProgress
+
+type updateTranscodingProgressRequest struct {
+	ID       string
+	Progress wttypes.TranscodingProgress
+}
+
+type updateTranscodingProgressResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateTranscodingProgressResponse) error() error { return r.Err }
+
+func makeUpdateTranscodingProgressEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateTranscodingProgressRequest)
+		err := ds.UpdateTranscodingProgress(req.ID, req.Progress)
+
+		return updateTranscodingProgressResponse{Err: err}, nil
+	}
+}
+
 // GetTranscoding
 
 type getTranscodingRequest struct {
@@ -148,8 +175,278 @@ func (r updateWorkerStatusResponse) error() error { return r.Err }
 func makeUpdateWorkerStatusEndpoint(ds Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(updateWorkerStatusRequest)
-		err := ds.UpdateWorkerStatus(req.WS.Addr, req.WS.Status)
+		err := ds.UpdateWorkerStatus(req.WS)
 
 		return updateWorkerStatusResponse{Err: err}, nil
 	}
 }
+
+// ListProfiles
+
+type listProfilesRequest struct {
+}
+
+type listProfilesResponse struct {
+	Profiles []wttypes.Profile `json:"profiles,omitempty"`
+	Err      error             `json:"error,omitempty"`
+}
+
+func (r listProfilesResponse) error() error { return r.Err }
+
+func makeListProfilesEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_ = request.(listProfilesRequest)
+		profiles, err := ds.ListProfiles()
+		return listProfilesResponse{Profiles: profiles, Err: err}, nil
+	}
+}
+
+// GetProfile
+
+type getProfileRequest struct {
+	Name string
+}
+
+type getProfileResponse struct {
+	Profile *wttypes.Profile `json:"profile,omitempty"`
+	Err     error            `json:"error,omitempty"`
+}
+
+func (r getProfileResponse) error() error { return r.Err }
+
+func makeGetProfileEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getProfileRequest)
+		p, err := ds.GetProfile(req.Name)
+		return getProfileResponse{Profile: &p, Err: err}, nil
+	}
+}
+
+// InsertProfile
+
+type insertProfileRequest struct {
+	Profile wttypes.Profile
+}
+
+type insertProfileResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r insertProfileResponse) error() error { return r.Err }
+
+func makeInsertProfileEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(insertProfileRequest)
+		err := ds.InsertProfile(req.Profile)
+		return insertProfileResponse{Err: err}, nil
+	}
+}
+
+// UpdateProfile
+
+type updateProfileRequest struct {
+	Profile wttypes.Profile
+}
+
+type updateProfileResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateProfileResponse) error() error { return r.Err }
+
+func makeUpdateProfileEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateProfileRequest)
+		err := ds.UpdateProfile(req.Profile)
+		return updateProfileResponse{Err: err}, nil
+	}
+}
+
+// DeleteProfile
+
+type deleteProfileRequest struct {
+	Name string
+}
+
+type deleteProfileResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteProfileResponse) error() error { return r.Err }
+
+func makeDeleteProfileEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteProfileRequest)
+		err := ds.DeleteProfile(req.Name)
+		return deleteProfileResponse{Err: err}, nil
+	}
+}
+
+// ListWebhookDeliveries
+
+type listWebhookDeliveriesRequest struct {
+	JobID string
+}
+
+type listWebhookDeliveriesResponse struct {
+	Deliveries []wttypes.WebhookDelivery `json:"deliveries,omitempty"`
+	Err        error                     `json:"error,omitempty"`
+}
+
+func (r listWebhookDeliveriesResponse) error() error { return r.Err }
+
+func makeListWebhookDeliveriesEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listWebhookDeliveriesRequest)
+		deliveries, err := ds.ListWebhookDeliveries(req.JobID)
+		return listWebhookDeliveriesResponse{Deliveries: deliveries, Err: err}, nil
+	}
+}
+
+// InsertUpload
+
+type insertUploadRequest struct {
+	Upload wttypes.Upload
+}
+
+type insertUploadResponse struct {
+	ID  string `json:"upload_id,omitempty"`
+	Err error  `json:"error,omitempty"`
+}
+
+func (r insertUploadResponse) error() error { return r.Err }
+
+func makeInsertUploadEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(insertUploadRequest)
+		id, err := ds.InsertUpload(req.Upload)
+		return insertUploadResponse{ID: id, Err: err}, nil
+	}
+}
+
+// GetUpload
+
+type getUploadRequest struct {
+	ID string
+}
+
+type uploadResponse struct {
+	Upload *wttypes.Upload `json:"upload,omitempty"`
+	Err    error           `json:"error,omitempty"`
+}
+
+func (r uploadResponse) error() error { return r.Err }
+
+func makeGetUploadEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getUploadRequest)
+		u, err := ds.GetUpload(req.ID)
+		if err != nil {
+			return uploadResponse{Err: err}, nil
+		}
+		return uploadResponse{Upload: &u}, nil
+	}
+}
+
+// AddUploadSegment
+
+type addUploadSegmentRequest struct {
+	ID      string
+	Segment wttypes.UploadSegment
+}
+
+func makeAddUploadSegmentEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(addUploadSegmentRequest)
+		u, err := ds.AddUploadSegment(req.ID, req.Segment)
+		if err != nil {
+			return uploadResponse{Err: err}, nil
+		}
+		return uploadResponse{Upload: &u}, nil
+	}
+}
+
+// UpdateUploadStatus
+
+type updateUploadStatusRequest struct {
+	ID     string
+	From   string `json:"from"`
+	Status string `json:"status"`
+	JobID  string `json:"job_id"`
+}
+
+type updateUploadStatusResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateUploadStatusResponse) error() error { return r.Err }
+
+func makeUpdateUploadStatusEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateUploadStatusRequest)
+		err := ds.UpdateUploadStatus(req.ID, req.From, req.Status, req.JobID)
+		return updateUploadStatusResponse{Err: err}, nil
+	}
+}
+
+// UpdateUploadObject
+
+type updateUploadObjectRequest struct {
+	ID         string
+	ObjectName string `json:"object_name"`
+}
+
+type updateUploadObjectResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateUploadObjectResponse) error() error { return r.Err }
+
+func makeUpdateUploadObjectEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateUploadObjectRequest)
+		err := ds.UpdateUploadObject(req.ID, req.ObjectName)
+		return updateUploadObjectResponse{Err: err}, nil
+	}
+}
+
+// ListStaleUploads
+
+type listStaleUploadsRequest struct {
+	Before time.Time
+}
+
+type listStaleUploadsResponse struct {
+	Uploads []wttypes.Upload `json:"uploads,omitempty"`
+	Err     error            `json:"error,omitempty"`
+}
+
+func (r listStaleUploadsResponse) error() error { return r.Err }
+
+func makeListStaleUploadsEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listStaleUploadsRequest)
+		uploads, err := ds.ListStaleUploads(req.Before)
+		return listStaleUploadsResponse{Uploads: uploads, Err: err}, nil
+	}
+}
+
+// DeleteUpload
+
+type deleteUploadRequest struct {
+	ID string
+}
+
+type deleteUploadResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteUploadResponse) error() error { return r.Err }
+
+func makeDeleteUploadEndpoint(ds Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteUploadRequest)
+		err := ds.DeleteUpload(req.ID)
+		return deleteUploadResponse{Err: err}, nil
+	}
+}
@@ -3,6 +3,8 @@ package database
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/go-resty/resty"
 	"gopkg.in/mgo.v2"
@@ -22,20 +24,72 @@ type Service interface {
 	GetJob(id string) (wttypes.Job, error)
 
 	// List all jobs in DB
-	ListJobs() ([]wttypes.Job, error)
+	ListJobs(filter wttypes.JobFilter) (wttypes.JobList, error)
 
 	// Update a transcoding in DB
 	UpdateTranscoding(t wttypes.TranscodingTask) error
 
+	// Update the progress of a transcoding in DB, while it's running
+	UpdateTranscodingProgress(id string, progress wttypes.TranscodingProgress) error
+
 	// Get a transcoding from DB
 	GetTranscoding(id string) (wttypes.TranscodingTask, error)
 
 	// Update Worker status into DB (and add to events for metrics)
-	UpdateWorkerStatus(addr string, status string) error
+	UpdateWorkerStatus(ws wttypes.WorkerStatus) error
+
+	// List all transcoding profiles in DB
+	ListProfiles() ([]wttypes.Profile, error)
+
+	// Get a transcoding profile from DB
+	GetProfile(name string) (wttypes.Profile, error)
+
+	// Insert a new transcoding profile into DB
+	InsertProfile(p wttypes.Profile) error
+
+	// Update a transcoding profile in DB
+	UpdateProfile(p wttypes.Profile) error
+
+	// Delete a transcoding profile from DB
+	DeleteProfile(name string) error
+
+	// List the webhook deliveries of a job
+	ListWebhookDeliveries(jobID string) ([]wttypes.WebhookDelivery, error)
+
+	// Insert a new resumable upload into DB
+	InsertUpload(u wttypes.Upload) (string, error)
+
+	// Get a resumable upload from DB
+	GetUpload(id string) (wttypes.Upload, error)
+
+	// Add an uploaded chunk to an upload, at its offset
+	AddUploadSegment(id string, seg wttypes.UploadSegment) (wttypes.Upload, error)
+
+	// Update the status of an upload, when it is in from
+	UpdateUploadStatus(id string, from string, status string, jobID string) error
+
+	// Record the large object of an upload being finished
+	UpdateUploadObject(id string, objectName string) error
+
+	// List the uploads still uploading or finishing not updated since before
+	ListStaleUploads(before time.Time) ([]wttypes.Upload, error)
+
+	// Delete an upload from DB
+	DeleteUpload(id string) error
+
+	// No Endpoints (REST API) api for below functions
+
+	// Deliver the pending webhooks whose attempt is due, returns how many were attempted
+	DeliverWebhooks() (int, error)
 }
 
 type service struct {
 	session *mgo.Session
+
+	webhooks        *resty.Client
+	webhookTimeout  time.Duration
+	webhookAttempts int
+	webhookBackoff  time.Duration
 }
 
 func (s *service) InsertJob(job wttypes.Job) (wttypes.JobIDs, error) {
@@ -66,13 +120,13 @@ func (s *service) GetJob(id string) (wttypes.Job, error) {
 	return job, err
 }
 
-func (s *service) ListJobs() ([]wttypes.Job, error) {
+func (s *service) ListJobs(filter wttypes.JobFilter) (wttypes.JobList, error) {
 	datastore := NewDataStore(s.session)
 	defer datastore.Close()
 
-	jobs, err := datastore.ListJobs()
+	list, err := datastore.ListJobs(filter)
 
-	return jobs, err
+	return list, err
 }
 
 func (s *service) UpdateTranscoding(t wttypes.TranscodingTask) error {
@@ -84,6 +138,15 @@ func (s *service) UpdateTranscoding(t wttypes.TranscodingTask) error {
 	return err
 }
 
+func (s *service) UpdateTranscodingProgress(id string, progress wttypes.TranscodingProgress) error {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err := datastore.UpdateTranscodingProgress(id, progress)
+
+	return err
+}
+
 //Get a transcoding from DB
 func (s *service) GetTranscoding(id string) (wttypes.TranscodingTask, error) {
 	datastore := NewDataStore(s.session)
@@ -94,17 +157,195 @@ func (s *service) GetTranscoding(id string) (wttypes.TranscodingTask, error) {
 	return t, err
 }
 
-func (s *service) UpdateWorkerStatus(addr string, status string) error {
+func (s *service) UpdateWorkerStatus(ws wttypes.WorkerStatus) error {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err := datastore.UpdateWorkerStatus(ws)
+
+	return err
+}
+
+func (s *service) ListProfiles() ([]wttypes.Profile, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	profiles, err := datastore.ListProfiles()
+
+	return profiles, err
+}
+
+func (s *service) GetProfile(name string) (wttypes.Profile, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	p, err := datastore.GetProfile(name)
+
+	return p, err
+}
+
+func (s *service) InsertProfile(p wttypes.Profile) error {
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err = datastore.InsertProfile(p)
+
+	return err
+}
+
+func (s *service) UpdateProfile(p wttypes.Profile) error {
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err = datastore.UpdateProfile(p)
+
+	return err
+}
+
+func (s *service) DeleteProfile(name string) error {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err := datastore.DeleteProfile(name)
+
+	return err
+}
+
+func (s *service) ListWebhookDeliveries(jobID string) ([]wttypes.WebhookDelivery, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	deliveries, err := datastore.ListWebhookDeliveries(jobID)
+
+	return deliveries, err
+}
+
+func (s *service) InsertUpload(u wttypes.Upload) (string, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	id, err := datastore.InsertUpload(u)
+
+	return id, err
+}
+
+func (s *service) GetUpload(id string) (wttypes.Upload, error) {
 	datastore := NewDataStore(s.session)
 	defer datastore.Close()
 
-	err := datastore.UpdateWorkerStatus(addr, status)
+	u, err := datastore.GetUpload(id)
+
+	return u, err
+}
+
+func (s *service) AddUploadSegment(id string, seg wttypes.UploadSegment) (wttypes.Upload, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	u, err := datastore.AddUploadSegment(id, seg)
+
+	return u, err
+}
+
+func (s *service) UpdateUploadStatus(id string, from string, status string, jobID string) error {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err := datastore.UpdateUploadStatus(id, from, status, jobID)
 
 	return err
 }
 
+func (s *service) UpdateUploadObject(id string, objectName string) error {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err := datastore.UpdateUploadObject(id, objectName)
+
+	return err
+}
+
+func (s *service) ListStaleUploads(before time.Time) ([]wttypes.Upload, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	uploads, err := datastore.ListStaleUploads(before)
+
+	return uploads, err
+}
+
+func (s *service) DeleteUpload(id string) error {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	err := datastore.DeleteUpload(id)
+
+	return err
+}
+
+// No Endpoints (REST API) api for below functions
+
+func (s *service) DeliverWebhooks() (int, error) {
+	datastore := NewDataStore(s.session)
+	defer datastore.Close()
+
+	total := 0
+	for {
+		// Claimed for longer than an attempt can last
+		d, err := datastore.ClaimDueWebhook(2 * s.webhookTimeout)
+		if err == mgo.ErrNotFound {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+		total++
+
+		code, errD := s.deliverWebhook(d)
+
+		status, err := datastore.UpdateWebhookAttempt(d, code, errD, s.webhookAttempts, s.webhookBackoff)
+		if err != nil {
+			return total, err
+		}
+
+		fmt.Println("[database] webhook:", d.ID.Hex(), d.JobID, d.Event, d.URL, status, code, errD)
+	}
+}
+
+// deliverWebhook sends a signed delivery, any 2xx response means the client got it
+func (s *service) deliverWebhook(d WebhookDeliveryDB) (int, error) {
+	resp, err := s.webhooks.R().
+		SetHeader("Content-Type", "application/json; charset=utf-8").
+		SetHeader(wttypes.WEBHOOK_HEADER_EVENT, d.Event).
+		SetHeader(wttypes.WEBHOOK_HEADER_DELIVERY, d.ID.Hex()).
+		SetHeader(wttypes.WEBHOOK_HEADER_SIGNATURE, wttypes.SignWebhook(d.Secret, d.Payload)).
+		SetBody(d.Payload).
+		Post(d.URL)
+
+	// Error in communication
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode() < 200 || resp.StatusCode() > 299 {
+		return resp.StatusCode(), errors.New("Unexpected response: " + resp.Status())
+	}
+
+	return resp.StatusCode(), nil
+}
+
 // NewService creates a database service with necessary dependencies.
-func NewService() (Service, error) {
+func NewService(webhookTimeout time.Duration, webhookAttempts int, webhookBackoff time.Duration) (Service, error) {
 	resty.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
 
 	s, err := CreateMongoSession()
@@ -112,7 +353,16 @@ func NewService() (Service, error) {
 		return &service{}, errors.New("[MongoDB] " + err.Error())
 	}
 
+	// Webhooks go outside, with their own timeout and verifying certificates
+	webhooks := resty.New().
+		SetTimeout(webhookTimeout)
+
 	return &service{
 		session: s,
+
+		webhooks:        webhooks,
+		webhookTimeout:  webhookTimeout,
+		webhookAttempts: webhookAttempts,
+		webhookBackoff:  webhookBackoff,
 	}, nil
 }
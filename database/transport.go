@@ -4,13 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
+	"gopkg.in/mgo.v2"
 
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
@@ -22,6 +25,7 @@ func MakeHandler(ctx context.Context, ds Service, logger kitlog.Logger) http.Han
 	}
 
 	// test: curl -k https://localhost:8080/jobs
+	// test: curl -k "https://localhost:8080/jobs?status=finished&from=2017-01-01T00:00:00Z&to=2017-02-01T00:00:00Z&sort=-added&limit=20&cursor=eyJzIjoiLWFkZGVkIiwidiI6IjIwMTctMDEtMjBUMTA6MDA6MDBaIiwiaWQiOiI1ODgxZmYwMGQ4ZmUzNTI4YjY3MzllNDIifQ"
 	listJobsHandler := kithttp.NewServer(
 		ctx,
 		makeListJobsEndpoint(ds),
@@ -66,6 +70,15 @@ func MakeHandler(ctx context.Context, ds Service, logger kitlog.Logger) http.Han
 		opts...,
 	)
 
+	// test: curl -k -H "Content-Type: application/json" -d '{"percent":42.5,"fps":48,"eta":35}' -X PUT https://localhost:8080/transcodings/578fb746be4ead07d6289554/progress
+	updateTranscodingProgressHandler := kithttp.NewServer(
+		ctx,
+		makeUpdateTranscodingProgressEndpoint(ds),
+		decodeUpdateTranscodingProgressRequest,
+		encodeResponse,
+		opts...,
+	)
+
 	// test: curl -k https://localhost:8080/transcodings/578fb746be4ead07d6289554
 	getTranscodingHandler := kithttp.NewServer(
 		ctx,
@@ -84,24 +97,162 @@ func MakeHandler(ctx context.Context, ds Service, logger kitlog.Logger) http.Han
 		opts...,
 	)
 
+	// test: curl -k https://localhost:8080/profiles
+	listProfilesHandler := kithttp.NewServer(
+		ctx,
+		makeListProfilesEndpoint(ds),
+		decodeListProfilesRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k https://localhost:8080/profiles/iPhone5s
+	getProfileHandler := kithttp.NewServer(
+		ctx,
+		makeGetProfileEndpoint(ds),
+		decodeGetProfileRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"name":"android720p", "ffmpeg":{"name":"main-31", "video_codec":"libx264", "crf":23, "preset":"fast", "profile":"main", "level":"3.1", "audio_codec":"aac", "audio_bitrate":"128k", "scaling":"fit", "container":"mp4", "faststart":true}, "resolution":"1280x720"}' -X POST https://localhost:8080/profiles
+	insertProfileHandler := kithttp.NewServer(
+		ctx,
+		makeInsertProfileEndpoint(ds),
+		decodeInsertProfileRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"name":"android720p", "ffmpeg":{"name":"main-40", "video_codec":"libx264", "crf":23, "preset":"fast", "profile":"main", "level":"4.0", "audio_codec":"aac", "audio_bitrate":"128k", "scaling":"pad", "container":"mp4", "faststart":true}, "resolution":"1280x720"}' -X PUT https://localhost:8080/profiles/android720p
+	updateProfileHandler := kithttp.NewServer(
+		ctx,
+		makeUpdateProfileEndpoint(ds),
+		decodeUpdateProfileRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -X DELETE https://localhost:8080/profiles/android720p
+	deleteProfileHandler := kithttp.NewServer(
+		ctx,
+		makeDeleteProfileEndpoint(ds),
+		decodeDeleteProfileRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k https://localhost:8080/jobs/1/webhooks
+	listWebhookDeliveriesHandler := kithttp.NewServer(
+		ctx,
+		makeListWebhookDeliveriesEndpoint(ds),
+		decodeListWebhookDeliveriesRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"filename":"conejo.mp4", "length":7340032000, "job":{"video_name":"conejo", "transcodings":[{"profile":"iPhone5s"}]}}' -X POST https://localhost:8080/uploads
+	insertUploadHandler := kithttp.NewServer(
+		ctx,
+		makeInsertUploadEndpoint(ds),
+		decodeInsertUploadRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k https://localhost:8080/uploads/578fb746be4ead07d6289554
+	getUploadHandler := kithttp.NewServer(
+		ctx,
+		makeGetUploadEndpoint(ds),
+		decodeGetUploadRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"object_name":"578fb746be4ead07d6289554/00000000000000000000-1484000000000000000", "offset":0, "size":104857600, "etag":"d41d8cd98f00b204e9800998ecf8427e"}' -X POST https://localhost:8080/uploads/578fb746be4ead07d6289554/segments
+	addUploadSegmentHandler := kithttp.NewServer(
+		ctx,
+		makeAddUploadSegmentEndpoint(ds),
+		decodeAddUploadSegmentRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"from":"uploading", "status":"finishing"}' -X PUT https://localhost:8080/uploads/578fb746be4ead07d6289554/status
+	updateUploadStatusHandler := kithttp.NewServer(
+		ctx,
+		makeUpdateUploadStatusEndpoint(ds),
+		decodeUpdateUploadStatusRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"object_name":"conejo-1484000000000000000.mp4"}' -X PUT https://localhost:8080/uploads/578fb746be4ead07d6289554/object
+	updateUploadObjectHandler := kithttp.NewServer(
+		ctx,
+		makeUpdateUploadObjectEndpoint(ds),
+		decodeUpdateUploadObjectRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k "https://localhost:8080/uploads?updated_before=2017-01-01T00:00:00Z"
+	listStaleUploadsHandler := kithttp.NewServer(
+		ctx,
+		makeListStaleUploadsEndpoint(ds),
+		decodeListStaleUploadsRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -X DELETE https://localhost:8080/uploads/578fb746be4ead07d6289554
+	deleteUploadHandler := kithttp.NewServer(
+		ctx,
+		makeDeleteUploadEndpoint(ds),
+		decodeDeleteUploadRequest,
+		encodeResponse,
+		opts...,
+	)
+
 	r := mux.NewRouter()
 
 	r.Handle("/jobs", insertJobHandler).Methods("POST")
 	r.Handle("/jobs/{id}", getJobHandler).Methods("GET")
 	r.Handle("/jobs/{id}", updateJobHandler).Methods("PUT")
 	r.Handle("/jobs", listJobsHandler).Methods("GET")
+	r.Handle("/jobs/{id}/webhooks", listWebhookDeliveriesHandler).Methods("GET")
 
 	r.Handle("/transcodings/{id}", getTranscodingHandler).Methods("GET")
 	r.Handle("/transcodings/{id}", updateTranscodingHandler).Methods("PUT")
+	r.Handle("/transcodings/{id}/progress", updateTranscodingProgressHandler).Methods("PUT")
 
 	r.Handle("/workers/status", updateWorkerStatusHandler).Methods("PUT")
 
+	r.Handle("/profiles", listProfilesHandler).Methods("GET")
+	r.Handle("/profiles", insertProfileHandler).Methods("POST")
+	r.Handle("/profiles/{name}", getProfileHandler).Methods("GET")
+	r.Handle("/profiles/{name}", updateProfileHandler).Methods("PUT")
+	r.Handle("/profiles/{name}", deleteProfileHandler).Methods("DELETE")
+
+	r.Handle("/uploads", insertUploadHandler).Methods("POST")
+	r.Handle("/uploads", listStaleUploadsHandler).Methods("GET")
+	r.Handle("/uploads/{id}", getUploadHandler).Methods("GET")
+	r.Handle("/uploads/{id}", deleteUploadHandler).Methods("DELETE")
+	r.Handle("/uploads/{id}/segments", addUploadSegmentHandler).Methods("POST")
+	r.Handle("/uploads/{id}/status", updateUploadStatusHandler).Methods("PUT")
+	r.Handle("/uploads/{id}/object", updateUploadObjectHandler).Methods("PUT")
+
 	return r
 
 }
 
 func decodeListJobsRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return listJobsRequest{}, nil
+	filter, err := wttypes.ParseJobFilter(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return listJobsRequest{Filter: filter}, nil
 }
 
 func decodeInsertJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -179,6 +330,22 @@ func decodeUpdateTranscodingRequest(_ context.Context, r *http.Request) (interfa
 	return updateTranscodingRequest{Transcoding: t}, nil
 }
 
+func decodeUpdateTranscodingProgressRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var p wttypes.TranscodingProgress
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+
+	return updateTranscodingProgressRequest{ID: id, Progress: p}, nil
+}
+
 func decodeUpdateWorkerStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var ws wttypes.WorkerStatus
 
@@ -197,6 +364,159 @@ func decodeUpdateWorkerStatusRequest(_ context.Context, r *http.Request) (interf
 	}, nil
 }
 
+func decodeListProfilesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listProfilesRequest{}, nil
+}
+
+func decodeGetProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return getProfileRequest{Name: name}, nil
+}
+
+func decodeInsertProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var p wttypes.Profile
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+
+	return insertProfileRequest{Profile: p}, nil
+}
+
+func decodeUpdateProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var p wttypes.Profile
+
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+
+	// Name is optional in the body, but must match if given
+	if p.Name == "" {
+		p.Name = name
+	}
+	if p.Name != name {
+		return nil, wttypes.ErrMismatchID
+	}
+
+	return updateProfileRequest{Profile: p}, nil
+}
+
+func decodeDeleteProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return deleteProfileRequest{Name: name}, nil
+}
+
+func decodeListWebhookDeliveriesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return listWebhookDeliveriesRequest{JobID: id}, nil
+}
+
+func decodeInsertUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var u wttypes.Upload
+
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		return nil, err
+	}
+
+	return insertUploadRequest{Upload: u}, nil
+}
+
+func decodeGetUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return getUploadRequest{ID: id}, nil
+}
+
+func decodeAddUploadSegmentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var seg wttypes.UploadSegment
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&seg); err != nil {
+		return nil, err
+	}
+
+	return addUploadSegmentRequest{ID: id, Segment: seg}, nil
+}
+
+func decodeUpdateUploadStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req updateUploadStatusRequest
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	req.ID = id
+
+	return req, nil
+}
+
+func decodeUpdateUploadObjectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req updateUploadObjectRequest
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	req.ID = id
+
+	return req, nil
+}
+
+func decodeListStaleUploadsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	// RFC 3339, e.g. 2017-01-01T00:00:00Z
+	before, err := time.Parse(time.RFC3339, r.URL.Query().Get("updated_before"))
+	if err != nil {
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	return listStaleUploadsRequest{Before: before}, nil
+}
+
+func decodeDeleteUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return deleteUploadRequest{ID: id}, nil
+}
+
 type errorer interface {
 	error() error
 }
@@ -212,18 +532,10 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 
 // encode errors from business-logic
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	switch err {
-	case wttypes.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case wttypes.ErrInvalidArgument:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+	// Not found in MongoDB
+	if err == mgo.ErrNotFound {
+		err = wttypes.ErrNotFound
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	wtcommon.EncodeError(err, w)
 }
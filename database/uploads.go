@@ -0,0 +1,250 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+type UploadDB struct {
+	ID       bson.ObjectId           `bson:"_id"`
+	Filename string                  `bson:"filename"`
+	Length   int64                   `bson:"length"`
+	Offset   int64                   `bson:"offset"`
+	Job      wttypes.Job             `bson:"job"`
+	Status   string                  `bson:"status"`
+	JobID    string                  `bson:"job_id"`
+	Segments []wttypes.UploadSegment `bson:"segments"`
+	Added    time.Time               `bson:"added"`
+	Updated  time.Time               `bson:"updated"`
+
+	ObjectName string `bson:"object_name,omitempty"`
+}
+
+func (u UploadDB) upload() wttypes.Upload {
+	return wttypes.Upload{
+		ID:       u.ID.Hex(),
+		Filename: u.Filename,
+		Length:   u.Length,
+		Offset:   u.Offset,
+		Job:      u.Job,
+		Status:   u.Status,
+		JobID:    u.JobID,
+		Segments: u.Segments,
+		Added:    timestamp(u.Added),
+		Updated:  timestamp(u.Updated),
+
+		ObjectName: u.ObjectName,
+	}
+}
+
+func (ds *DataStore) InsertUpload(u wttypes.Upload) (string, error) {
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	id := bson.NewObjectId()
+	err := c.Insert(UploadDB{
+		ID:       id,
+		Filename: u.Filename,
+		Length:   u.Length,
+		Job:      u.Job,
+		Status:   wttypes.UPLOAD_UPLOADING,
+		Segments: []wttypes.UploadSegment{},
+		Added:    time.Now(),
+		Updated:  time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id.Hex(), nil
+}
+
+func (ds *DataStore) GetUpload(id string) (wttypes.Upload, error) {
+	// Check is a valid ID
+	if !bson.IsObjectIdHex(id) {
+		return wttypes.Upload{}, wttypes.ErrInvalidID
+	}
+
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	result := UploadDB{}
+	err := c.FindId(bson.ObjectIdHex(id)).One(&result)
+	if err == mgo.ErrNotFound {
+		return wttypes.Upload{}, wttypes.ErrUploadNotFound
+	}
+	if err != nil {
+		return wttypes.Upload{}, err
+	}
+
+	return result.upload(), nil
+}
+
+// AddUploadSegment appends a segment to an upload, only when the segment
+// starts at its offset, so concurrent chunks can't both be taken
+func (ds *DataStore) AddUploadSegment(id string, seg wttypes.UploadSegment) (wttypes.Upload, error) {
+	// Check is a valid ID
+	if !bson.IsObjectIdHex(id) {
+		return wttypes.Upload{}, wttypes.ErrInvalidID
+	}
+
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	change := mgo.Change{
+		Update: bson.M{
+			"$inc":  bson.M{"offset": seg.Size},
+			"$push": bson.M{"segments": seg},
+			"$set":  bson.M{"updated": time.Now()},
+		},
+		ReturnNew: true,
+	}
+
+	result := UploadDB{}
+	_, err := c.Find(bson.M{
+		"_id":    bson.ObjectIdHex(id),
+		"status": wttypes.UPLOAD_UPLOADING,
+		"offset": seg.Offset,
+	}).Apply(change, &result)
+	if err == mgo.ErrNotFound {
+		// Tell why it wasn't taken
+		u, err := ds.GetUpload(id)
+		if err != nil {
+			return wttypes.Upload{}, err
+		}
+		if u.Status != wttypes.UPLOAD_UPLOADING {
+			return wttypes.Upload{}, wttypes.ErrUploadNotUploading
+		}
+
+		return wttypes.Upload{}, wttypes.ErrUploadOffsetMismatch
+	}
+	if err != nil {
+		return wttypes.Upload{}, err
+	}
+
+	return result.upload(), nil
+}
+
+// UpdateUploadStatus moves an upload from one status to another, along with
+// the job created from it (if any). It fails when the upload is not in from.
+// The large object recorded while finishing is forgotten.
+func (ds *DataStore) UpdateUploadStatus(id string, from string, status string, jobID string) error {
+	// Check is a valid ID
+	if !bson.IsObjectIdHex(id) {
+		return wttypes.ErrInvalidID
+	}
+
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	err := c.Update(bson.M{"_id": bson.ObjectIdHex(id), "status": from}, bson.M{
+		"$set": bson.M{
+			"status":  status,
+			"job_id":  jobID,
+			"updated": time.Now(),
+		},
+		"$unset": bson.M{"object_name": ""},
+	})
+	if err == mgo.ErrNotFound {
+		// Either it's not there or not in from
+		if _, err := ds.GetUpload(id); err != nil {
+			return err
+		}
+
+		return wttypes.ErrUploadNotUploading
+	}
+
+	return err
+}
+
+// UpdateUploadObject records the large object of an upload being finished, so
+// it can be removed along with the upload if finishing never ends
+func (ds *DataStore) UpdateUploadObject(id string, objectName string) error {
+	// Check is a valid ID
+	if !bson.IsObjectIdHex(id) {
+		return wttypes.ErrInvalidID
+	}
+
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	err := c.Update(bson.M{"_id": bson.ObjectIdHex(id), "status": wttypes.UPLOAD_FINISHING}, bson.M{"$set": bson.M{
+		"object_name": objectName,
+		"updated":     time.Now(),
+	}})
+	if err == mgo.ErrNotFound {
+		// Either it's not there or not being finished
+		if _, err := ds.GetUpload(id); err != nil {
+			return err
+		}
+
+		return wttypes.ErrUploadNotUploading
+	}
+
+	return err
+}
+
+// ListStaleUploads lists the uploads still uploading or finishing that weren't
+// updated since before. Uploads whose job was added but never marked as
+// finished are marked now, and left out.
+func (ds *DataStore) ListStaleUploads(before time.Time) ([]wttypes.Upload, error) {
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	var results []UploadDB
+	err := c.Find(bson.M{
+		"status":  bson.M{"$in": []string{wttypes.UPLOAD_UPLOADING, wttypes.UPLOAD_FINISHING}},
+		"updated": bson.M{"$lt": before},
+	}).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get "jobs" collection
+	cj := ds.session.DB(MongoDB).C(MongoJobsCollection)
+
+	uploads := []wttypes.Upload{}
+	for _, v := range results {
+		if v.Status == wttypes.UPLOAD_FINISHING && v.ObjectName != "" {
+			job := JobDB{}
+			err = cj.Find(bson.M{"object_name": v.ObjectName}).Select(bson.M{"_id": 1}).One(&job)
+			if err == nil {
+				// Its job is there, only the upload wasn't marked
+				err = ds.UpdateUploadStatus(v.ID.Hex(), wttypes.UPLOAD_FINISHING, wttypes.UPLOAD_FINISHED, job.ID.Hex())
+				if err != nil {
+					fmt.Println("[err] update upload status:", v.ID.Hex(), err)
+				}
+				continue
+			}
+			if err != mgo.ErrNotFound {
+				return nil, err
+			}
+		}
+
+		uploads = append(uploads, v.upload())
+	}
+
+	return uploads, nil
+}
+
+func (ds *DataStore) DeleteUpload(id string) error {
+	// Check is a valid ID
+	if !bson.IsObjectIdHex(id) {
+		return wttypes.ErrInvalidID
+	}
+
+	// Get "uploads" collection
+	c := ds.session.DB(MongoDB).C(MongoUploadsCollection)
+
+	err := c.RemoveId(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrUploadNotFound
+	}
+
+	return err
+}
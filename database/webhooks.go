@@ -0,0 +1,198 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Longest wait between two attempts of a delivery
+const MaxWebhookBackoff = time.Hour
+
+type WebhookDeliveryDB struct {
+	ID           bson.ObjectId `bson:"_id"`
+	JobID        string        `bson:"job_id"`
+	Event        string        `bson:"event"`
+	URL          string        `bson:"url"`
+	Secret       string        `bson:"secret"`
+	Payload      []byte        `bson:"payload"`
+	Status       string        `bson:"status"`
+	Attempts     int           `bson:"attempts"`
+	NextAttempt  time.Time     `bson:"next_attempt"`
+	ResponseCode int           `bson:"response_code"`
+	LastError    string        `bson:"last_error"`
+	Added        time.Time     `bson:"added"`
+	Delivered    time.Time     `bson:"delivered"`
+}
+
+func (d WebhookDeliveryDB) delivery() wttypes.WebhookDelivery {
+	return wttypes.WebhookDelivery{
+		ID:           d.ID.Hex(),
+		JobID:        d.JobID,
+		Event:        d.Event,
+		URL:          d.URL,
+		Status:       d.Status,
+		Attempts:     d.Attempts,
+		ResponseCode: d.ResponseCode,
+		LastError:    d.LastError,
+		Added:        d.Added,
+		Delivered:    d.Delivered,
+	}
+}
+
+// withoutSecret returns a copy of the webhook fit to be shown
+func withoutSecret(w *wttypes.Webhook) *wttypes.Webhook {
+	if w == nil {
+		return nil
+	}
+
+	v := *w
+	v.Secret = ""
+
+	return &v
+}
+
+// queueWebhook stores a delivery of the event, when the job has a webhook that wants it.
+// The body is built now, so every attempt sends the same one.
+func (ds *DataStore) queueWebhook(jobID string, event string, t *wttypes.TranscodingTask) error {
+	// Get "jobs" collection
+	c := ds.session.DB(MongoDB).C(MongoJobsCollection)
+
+	j := JobDB{}
+	err := c.FindId(bson.ObjectIdHex(jobID)).One(&j)
+	if err != nil {
+		return err
+	}
+
+	if j.Webhook == nil || !j.Webhook.Wants(event) {
+		return nil
+	}
+
+	job, err := ds.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	id := bson.NewObjectId()
+	now := time.Now()
+	payload, err := json.Marshal(wttypes.WebhookEvent{
+		ID:          id.Hex(),
+		Event:       event,
+		Timestamp:   now,
+		Job:         job,
+		Transcoding: t,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Get "webhooks" collection
+	c = ds.session.DB(MongoDB).C(MongoWebhooksCollection)
+
+	return c.Insert(&WebhookDeliveryDB{
+		ID:          id,
+		JobID:       jobID,
+		Event:       event,
+		URL:         j.Webhook.URL,
+		Secret:      j.Webhook.Secret,
+		Payload:     payload,
+		Status:      wttypes.DELIVERY_PENDING,
+		NextAttempt: now,
+		Added:       now,
+	})
+}
+
+// ClaimDueWebhook takes the next pending delivery whose attempt is due.
+// Its next attempt moves timeout ahead meanwhile, so it's not sent twice at once.
+func (ds *DataStore) ClaimDueWebhook(timeout time.Duration) (WebhookDeliveryDB, error) {
+	// Get "webhooks" collection
+	c := ds.session.DB(MongoDB).C(MongoWebhooksCollection)
+
+	now := time.Now()
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"next_attempt": now.Add(timeout)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		ReturnNew: true,
+	}
+
+	result := WebhookDeliveryDB{}
+	_, err := c.Find(bson.M{
+		"status":       wttypes.DELIVERY_PENDING,
+		"next_attempt": bson.M{"$lte": now},
+	}).Sort("next_attempt").Apply(change, &result)
+
+	return result, err
+}
+
+// UpdateWebhookAttempt records how an attempt of a delivery went: delivered, pending
+// after a backoff, or failed when it already used maxAttempts. Returns the new status.
+func (ds *DataStore) UpdateWebhookAttempt(d WebhookDeliveryDB, code int, errDelivery error, maxAttempts int, base time.Duration) (string, error) {
+	// Get "webhooks" collection
+	c := ds.session.DB(MongoDB).C(MongoWebhooksCollection)
+
+	now := time.Now()
+	set := bson.M{
+		"status":        wttypes.DELIVERY_DELIVERED,
+		"response_code": code,
+		"last_error":    "",
+		"delivered":     now,
+	}
+	if errDelivery != nil {
+		set = bson.M{
+			"status":        wttypes.DELIVERY_PENDING,
+			"response_code": code,
+			"last_error":    errDelivery.Error(),
+			"next_attempt":  now.Add(webhookBackoff(base, d.Attempts)),
+		}
+		if d.Attempts >= maxAttempts {
+			set["status"] = wttypes.DELIVERY_FAILED
+		}
+	}
+
+	err := c.UpdateId(d.ID, bson.M{"$set": set})
+	if err != nil {
+		return "", err
+	}
+
+	return set["status"].(string), nil
+}
+
+// ListWebhookDeliveries returns the deliveries of a job, oldest first
+func (ds *DataStore) ListWebhookDeliveries(jobID string) ([]wttypes.WebhookDelivery, error) {
+	// Get "webhooks" collection
+	c := ds.session.DB(MongoDB).C(MongoWebhooksCollection)
+
+	var results []WebhookDeliveryDB
+	err := c.Find(bson.M{"job_id": jobID}).Sort("added").All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []wttypes.WebhookDelivery{}
+	for _, v := range results {
+		deliveries = append(deliveries, v.delivery())
+	}
+
+	return deliveries, nil
+}
+
+// webhookBackoff returns the delay before the next attempt of a delivery:
+// base, 2*base, 4*base... up to MaxWebhookBackoff
+func webhookBackoff(base time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < MaxWebhookBackoff; i++ {
+		d *= 2
+	}
+
+	if d > MaxWebhookBackoff {
+		d = MaxWebhookBackoff
+	}
+
+	return d
+}
@@ -41,4 +41,4 @@ go get github.com/rackspace/gophercloud
 mkdir -p $APP_DIR
 git clone $REPOSITORY_URL $APP_DIR
 cd $APP_DIR
-go run transcoding/manager/cmd/main.go
\ No newline at end of file
+go run transcoding/manager/cmd/main.go -database=https://$DATABASE_ENDPOINT:8080
\ No newline at end of file
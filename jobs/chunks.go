@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// splitMedia cuts the media at keyframes, without transcoding, into chunks of
+// about duration seconds inside dir. Returns the chunks in order.
+func splitMedia(filename string, dir string, duration int) ([]string, error) {
+	// Matroska holds any codec the source could have
+	out, err := exec.Command("ffmpeg",
+		"-v", "error",
+		"-i", filename,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.Itoa(duration),
+		"-reset_timestamps", "1",
+		path.Join(dir, "chunk_%05d.mkv")).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// Sorted by name, which is the order of the chunks
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := []string{}
+	for _, v := range files {
+		chunks = append(chunks, path.Join(dir, v.Name()))
+	}
+
+	return chunks, nil
+}
+
+// uploadChunks splits the media into chunks of about duration seconds, and uploads
+// them next to the source. Without fn the source is downloaded from objectname.
+// Returns their object names in order.
+func (s *service) uploadChunks(fn string, objectname string, duration int) ([]string, error) {
+	// Splitting needs the whole media here
+	if fn == "" {
+		tmpfile, err := ioutil.TempFile(os.TempDir(), "media")
+		if err != nil {
+			return nil, err
+		}
+		tmpfile.Close()
+		defer os.Remove(tmpfile.Name())
+
+		err = wtcommon.DownloadFromObjectStorage(s.serviceObjectStorage, objectname, tmpfile.Name(), wtcommon.SOURCE_MEDIA_CONTAINER)
+		if err != nil {
+			return nil, err
+		}
+		fn = tmpfile.Name()
+	}
+
+	dir, err := ioutil.TempDir("", "chunks")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := splitMedia(fn, dir, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := chunksPrefix(objectname)
+
+	chunks := []string{}
+	for i, v := range files {
+		name, err := wtcommon.Upload2ObjectStorage(s.serviceObjectStorage, v, fmt.Sprintf("%s%05d.mkv", prefix, i), wtcommon.SOURCE_MEDIA_CONTAINER)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, name)
+	}
+
+	fmt.Println("[jobs] source split in chunks:", objectname, len(chunks))
+
+	return chunks, nil
+}
+
+// chunkTranscodings replaces every transcoding by one per chunk, plus the stitch joining them.
+// Other kinds of task keep using the whole source.
+func chunkTranscodings(transcodings []wttypes.TranscodingTask, chunks []string) []wttypes.TranscodingTask {
+	result := []wttypes.TranscodingTask{}
+	for _, v := range transcodings {
+		if v.Kind != "" {
+			result = append(result, v)
+			continue
+		}
+
+		for _, chunk := range chunks {
+			result = append(result, wttypes.TranscodingTask{Kind: wttypes.TASK_CHUNK, Profile: v.Profile, ObjectName: chunk})
+		}
+		result = append(result, wttypes.TranscodingTask{Kind: wttypes.TASK_STITCH, Profile: v.Profile})
+	}
+
+	return result
+}
+
+// chunksPrefix returns how the chunks of the source objectname start, and so
+// what was transcoded from them (named after the chunk)
+func chunksPrefix(objectname string) string {
+	return strings.TrimSuffix(objectname, path.Ext(objectname)) + "-chunk"
+}
+
+// chunksDone tells if nothing else is going to use the chunks of the job: every chunk
+// ended, and every stitch ended or won't run because one of its chunks failed
+func chunksDone(transcodings []wttypes.TranscodingTask) bool {
+	failed := make(map[string]bool)
+	for _, v := range transcodings {
+		if v.Kind != wttypes.TASK_CHUNK {
+			continue
+		}
+
+		switch v.Status {
+		case wttypes.TRANSCODING_FINISHED, wttypes.TRANSCODING_SKIPPED:
+		case wttypes.TRANSCODING_ERROR, wttypes.TRANSCODING_CANCELLED:
+			failed[v.Profile] = true
+		default:
+			return false
+		}
+	}
+
+	for _, v := range transcodings {
+		if v.Kind == wttypes.TASK_STITCH && !failed[v.Profile] && !transcodingEnded(v.Status) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// transcodingEnded tells if a transcoding with the status won't change anymore
+func transcodingEnded(status string) bool {
+	switch status {
+	case wttypes.TRANSCODING_FINISHED, wttypes.TRANSCODING_SKIPPED, wttypes.TRANSCODING_ERROR, wttypes.TRANSCODING_CANCELLED:
+		return true
+	}
+
+	return false
+}
+
+// deleteChunks removes the chunks of the job's source, and what was transcoded from them
+func (s *service) deleteChunks(job wttypes.Job) {
+	prefix := chunksPrefix(job.ObjectName)
+
+	for _, container := range []string{wtcommon.SOURCE_MEDIA_CONTAINER, wtcommon.TRANSCODED_MEDIA_CONTAINER} {
+		n, err := wtcommon.DeletePrefixFromObjectStorage(s.serviceObjectStorage, prefix, container)
+		if err != nil {
+			fmt.Println("[err] delete chunks:", job.ID, container, err)
+			continue
+		}
+
+		fmt.Println("[jobs] deleted chunks:", job.ID, container, n)
+	}
+}
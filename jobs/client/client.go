@@ -0,0 +1,99 @@
+package client
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Client is a client of the jobs service
+type Client struct {
+	c *wtclient.Client
+}
+
+// AddNewJob adds a new job for transcoding, returning its ID
+func (cl *Client) AddNewJob(ctx context.Context, job wttypes.Job) (string, error) {
+	var resp struct {
+		ID string `json:"job_id"`
+	}
+
+	err := cl.c.Call(ctx, "POST", "/jobs", job, &resp)
+
+	return resp.ID, err
+}
+
+// GetJob gets a job with the status, progress and output of its transcodings
+func (cl *Client) GetJob(ctx context.Context, jobID string) (wttypes.Job, error) {
+	var job wttypes.Job
+
+	err := cl.c.Call(ctx, "GET", "/jobs/"+jobID, nil, &job)
+
+	return job, err
+}
+
+// ListJobs gets a page of the jobs matching the filter
+func (cl *Client) ListJobs(ctx context.Context, filter wttypes.JobFilter) (wttypes.JobList, error) {
+	var list wttypes.JobList
+
+	err := cl.c.Call(ctx, "GET", "/jobs?"+filter.Query().Encode(), nil, &list)
+	if err != nil {
+		return wttypes.JobList{}, err
+	}
+
+	if list.Jobs == nil {
+		list.Jobs = []wttypes.Job{}
+	}
+
+	return list, nil
+}
+
+// CancelJob cancels a job and all its transcodings
+func (cl *Client) CancelJob(ctx context.Context, jobID string) error {
+	return cl.c.Call(ctx, "DELETE", "/jobs/"+jobID, nil, nil)
+}
+
+// UpdateTranscodingStatus updates the status of a transcoding, and where its output is once finished
+func (cl *Client) UpdateTranscodingStatus(ctx context.Context, id string, status string, objectname string) error {
+	body := struct {
+		Status     string `json:"status"`
+		ObjectName string `json:"object_name,omitempty"`
+	}{
+		Status:     status,
+		ObjectName: objectname,
+	}
+
+	return cl.c.Call(ctx, "PUT", "/transcodings/"+id+"/status", body, nil)
+}
+
+// UpdateTranscodingProgress updates how far a running transcoding is
+func (cl *Client) UpdateTranscodingProgress(ctx context.Context, id string, progress wttypes.TranscodingProgress) error {
+	return cl.c.Call(ctx, "PUT", "/transcodings/"+id+"/progress", progress, nil)
+}
+
+// ListWebhookDeliveries gets the webhook deliveries of a job
+func (cl *Client) ListWebhookDeliveries(ctx context.Context, jobID string) ([]wttypes.WebhookDelivery, error) {
+	var resp struct {
+		Deliveries []wttypes.WebhookDelivery `json:"deliveries"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/jobs/"+jobID+"/webhooks", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Deliveries == nil {
+		resp.Deliveries = []wttypes.WebhookDelivery{}
+	}
+
+	return resp.Deliveries, nil
+}
+
+// New creates a client of the jobs service at addr (https://server:port)
+func New(addr string, timeout time.Duration) *Client {
+	return &Client{
+		c: wtclient.New(addr, timeout),
+	}
+}
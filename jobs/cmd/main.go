@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"golang.org/x/net/context"
@@ -23,6 +24,7 @@ func main() {
 		httpAddr = ":" + wtcommon.JOBS_PORT
 		database = flag.String("database", "", "Database service address (http://server:port)")
 		manager  = flag.String("manager", "", "Manager service address (http://server:port)")
+		expiry   = flag.Duration("upload-expiry", 24*time.Hour, "Time an unfinished upload is kept without new chunks before removing it")
 	)
 	flag.Parse()
 
@@ -80,6 +82,17 @@ func main() {
 		logger.Log("transport", "http", "address", httpAddr, "msg", "listening")
 		errs <- http.ListenAndServeTLS(httpAddr, "certs/server.pem", "certs/server.key", nil)
 	}()
+	go func() {
+		// Remove abandoned uploads, along with what was uploaded of them
+		for {
+			time.Sleep(*expiry / 4)
+
+			_, err := js.ExpireUploads(*expiry)
+			if err != nil {
+				logger.Log("error", "Cannot expire uploads: "+err.Error())
+			}
+		}
+	}()
 	go func() {
 		c := make(chan os.Signal)
 		signal.Notify(c, syscall.SIGINT)
@@ -1,6 +1,8 @@
 package jobs
 
 import (
+	"io"
+
 	"golang.org/x/net/context"
 
 	"github.com/go-kit/kit/endpoint"
@@ -29,24 +31,65 @@ func makeAddNewJobEndpoint(js Service) endpoint.Endpoint {
 	}
 }
 
-// GetJobStatus
+// AddNewJobUpload
+
+type addNewJobUploadRequest struct {
+	Job   wttypes.Job
+	Media io.Reader
+}
+
+func makeAddNewJobUploadEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(addNewJobUploadRequest)
+		id, err := js.AddNewJobUpload(req.Job, req.Media)
+		return addNewJobResponse{ID: id, Err: err}, nil
+	}
+}
+
+// GetJob
 
-type getJobStatusRequest struct {
+type getJobRequest struct {
 	ID string
 }
 
-type getJobStatusResponse struct {
-	Status string `json:"status,omitempty"`
-	Err    error  `json:"error,omitempty"`
+type getJobResponse struct {
+	*wttypes.Job
+	Err error `json:"error,omitempty"`
 }
 
-func (r getJobStatusResponse) error() error { return r.Err }
+func (r getJobResponse) error() error { return r.Err }
 
-func makeGetJobStatusEndpoint(js Service) endpoint.Endpoint {
+func makeGetJobEndpoint(js Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(getJobStatusRequest)
-		status, err := js.GetJobStatus(req.ID)
-		return getJobStatusResponse{Status: status, Err: err}, nil
+		req := request.(getJobRequest)
+		job, err := js.GetJob(req.ID)
+		if err != nil {
+			return getJobResponse{Err: err}, nil
+		}
+		return getJobResponse{Job: &job}, nil
+	}
+}
+
+// ListJobs
+
+type listJobsRequest struct {
+	Filter wttypes.JobFilter
+}
+
+type listJobsResponse struct {
+	Jobs       []wttypes.Job `json:"jobs"`
+	Total      int           `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Err        error         `json:"error,omitempty"`
+}
+
+func (r listJobsResponse) error() error { return r.Err }
+
+func makeListJobsEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listJobsRequest)
+		list, err := js.ListJobs(req.Filter)
+		return listJobsResponse{Jobs: list.Jobs, Total: list.Total, NextCursor: list.NextCursor, Err: err}, nil
 	}
 }
 
@@ -91,3 +134,146 @@ func makeUpdateTranscodingStatusEndpoint(js Service) endpoint.Endpoint {
 		return updateTranscodingStatusResponse{Err: err}, nil
 	}
 }
+
+// UpdateTranscodingProgress
+
+type updateTranscodingProgressRequest struct {
+	ID       string
+	Progress wttypes.TranscodingProgress
+}
+
+type updateTranscodingProgressResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateTranscodingProgressResponse) error() error { return r.Err }
+
+func makeUpdateTranscodingProgressEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateTranscodingProgressRequest)
+		err := js.UpdateTranscodingProgress(req.ID, req.Progress)
+		return updateTranscodingProgressResponse{Err: err}, nil
+	}
+}
+
+// ListWebhookDeliveries
+
+type listWebhookDeliveriesRequest struct {
+	ID string
+}
+
+type listWebhookDeliveriesResponse struct {
+	Deliveries []wttypes.WebhookDelivery `json:"deliveries,omitempty"`
+	Err        error                     `json:"error,omitempty"`
+}
+
+func (r listWebhookDeliveriesResponse) error() error { return r.Err }
+
+func makeListWebhookDeliveriesEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listWebhookDeliveriesRequest)
+		deliveries, err := js.ListWebhookDeliveries(req.ID)
+		return listWebhookDeliveriesResponse{Deliveries: deliveries, Err: err}, nil
+	}
+}
+
+// CreateUpload
+
+type createUploadRequest struct {
+	Upload wttypes.Upload
+}
+
+type uploadResponse struct {
+	Upload *wttypes.Upload `json:"upload,omitempty"`
+	Err    error           `json:"error,omitempty"`
+
+	// Answered with where the upload is
+	created bool
+}
+
+func (r uploadResponse) error() error { return r.Err }
+
+func makeCreateUploadEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createUploadRequest)
+		u, err := js.CreateUpload(req.Upload)
+		if err != nil {
+			return uploadResponse{Err: err}, nil
+		}
+		return uploadResponse{Upload: &u, created: true}, nil
+	}
+}
+
+// GetUpload
+
+type getUploadRequest struct {
+	ID string
+}
+
+func makeGetUploadEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getUploadRequest)
+		u, err := js.GetUpload(req.ID)
+		if err != nil {
+			return uploadResponse{Err: err}, nil
+		}
+		return uploadResponse{Upload: &u}, nil
+	}
+}
+
+// WriteUpload
+
+type writeUploadRequest struct {
+	ID     string
+	Offset int64
+	Chunk  io.Reader
+}
+
+type writeUploadResponse struct {
+	Offset int64 `json:"offset"`
+	Err    error `json:"error,omitempty"`
+}
+
+func (r writeUploadResponse) error() error { return r.Err }
+
+func makeWriteUploadEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(writeUploadRequest)
+		offset, err := js.WriteUpload(req.ID, req.Offset, req.Chunk)
+		return writeUploadResponse{Offset: offset, Err: err}, nil
+	}
+}
+
+// FinishUpload
+
+type finishUploadRequest struct {
+	ID string
+}
+
+func makeFinishUploadEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(finishUploadRequest)
+		id, err := js.FinishUpload(req.ID)
+		return addNewJobResponse{ID: id, Err: err}, nil
+	}
+}
+
+// CancelUpload
+
+type cancelUploadRequest struct {
+	ID string
+}
+
+type cancelUploadResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r cancelUploadResponse) error() error { return r.Err }
+
+func makeCancelUploadEndpoint(js Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cancelUploadRequest)
+		err := js.CancelUpload(req.ID)
+		return cancelUploadResponse{Err: err}, nil
+	}
+}
@@ -1,14 +1,17 @@
 package jobs
 
 import (
-	"crypto/tls"
-	"errors"
 	"fmt"
-	"strings"
+	"io"
+	"os"
+	"time"
 
-	"github.com/go-resty/resty"
 	"github.com/rackspace/gophercloud"
+	"golang.org/x/net/context"
 
+	dbclient "github.com/obazavil/openstack-workload-transcoding/database/client"
+	managerclient "github.com/obazavil/openstack-workload-transcoding/transcoding/manager/client"
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
 	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
@@ -18,139 +21,362 @@ type Service interface {
 	// Add a new job for transcoding
 	AddNewJob(job wttypes.Job) (string, error)
 
-	// Get status for a particular job
-	GetJobStatus(jobID string) (string, error)
+	// Add a new job for transcoding, with its media uploaded along with it
+	AddNewJobUpload(job wttypes.Job, media io.Reader) (string, error)
+
+	// Get a job with the status, progress and output of its transcodings
+	GetJob(jobID string) (wttypes.Job, error)
+
+	// List a page of the jobs matching the filter, with how many of them in total
+	ListJobs(filter wttypes.JobFilter) (wttypes.JobList, error)
 
 	// Cancel a job and all its transcoding
 	CancelJob(jobID string) error
 
 	// Update the status of a transcoding
 	UpdateTranscodingStatus(id string, status string, objectname string) error
+
+	// Update how far a running transcoding is
+	UpdateTranscodingProgress(id string, progress wttypes.TranscodingProgress) error
+
+	// List the webhook deliveries of a job
+	ListWebhookDeliveries(jobID string) ([]wttypes.WebhookDelivery, error)
+
+	// Create a resumable upload of the media of a job
+	CreateUpload(u wttypes.Upload) (wttypes.Upload, error)
+
+	// Get a resumable upload, with how much of it was uploaded
+	GetUpload(id string) (wttypes.Upload, error)
+
+	// Upload a chunk of the media at offset, returns the new offset
+	WriteUpload(id string, offset int64, chunk io.Reader) (int64, error)
+
+	// Finish a complete upload, adding its job
+	FinishUpload(id string) (string, error)
+
+	// Cancel an upload, removing what was uploaded
+	CancelUpload(id string) error
+
+	// No Endpoints (REST API) api for below functions
+
+	// Remove the uploads not finished that weren't updated for ttl, returns how many were removed
+	ExpireUploads(ttl time.Duration) (int, error)
 }
 
 type service struct {
 	provider             *gophercloud.ProviderClient
 	serviceObjectStorage *gophercloud.ServiceClient
 
-	database string
-	manager  string
+	profiles *wtcommon.ProfileCache
+
+	database *dbclient.Client
+	manager  *managerclient.Client
 }
 
-func (s *service) AddNewJob(job wttypes.Job) (string, error) {
+// prepareJob validates a submitted job, setting its defaults and the transcodings to perform
+func (s *service) prepareJob(job wttypes.Job, upload bool) (wttypes.Job, error) {
 	// Verify we have transcodings to perform
 	if len(job.Transcodings) == 0 {
-		return "", wttypes.ErrNoTranscodings
+		return job, wttypes.ErrNoTranscodings
 	}
 
-	//First let's upload to Object Storage
-	objectname, errOS := wtcommon.Upload2ObjectStorage(s.serviceObjectStorage, job.URLMedia, job.VideoName, wtcommon.SOURCE_MEDIA_CONTAINER)
-	if errOS == nil {
-		job.ObjectName = objectname
-		job.Status = wttypes.JOB_QUEUED
+	// Profiles must be in the catalogue
+	profiles, err := s.profiles.List()
+	if err != nil {
+		return job, err
+	}
+
+	if upload {
+		err = job.ValidateUpload(profiles)
 	} else {
-		job.Status = wttypes.JOB_ERROR
+		err = job.Validate(profiles)
+	}
+	if err != nil {
+		return job, err
 	}
 
-	// Ask DB to add job into DB (even with error, for logging purposes)
-	resp, err := resty.R().
-		SetBody(job).
-		Post(s.database + "/jobs")
+	// Name is part of the object names
+	job.VideoName = wttypes.SanitizeVideoName(job.VideoName)
+
+	// No priority means default one
+	if job.Priority == 0 {
+		job.Priority = wttypes.PRIORITY_DEFAULT
+	}
+	if job.Priority < wttypes.PRIORITY_MIN || job.Priority > wttypes.PRIORITY_MAX {
+		return job, wttypes.ErrInvalidPriority
+	}
+
+	// Same for tenant
+	if job.Tenant == "" {
+		job.Tenant = wttypes.TENANT_DEFAULT
+	}
+
+	// Events are delivered signed, so the client can trust them
+	if job.Webhook != nil {
+		if err := job.Webhook.Validate(); err != nil {
+			return job, err
+		}
+	}
+
+	// Thumbnails are tasks on their own, apart from the transcodings
+	transcodings := []wttypes.TranscodingTask{}
+	thumbnails := []wttypes.TranscodingTask{}
+	for _, v := range job.Transcodings {
+		switch v.Kind {
+		case "", wttypes.TASK_TRANSCODING:
+			v.Kind = ""
+			transcodings = append(transcodings, v)
+		case wttypes.TASK_THUMBNAILS:
+			opts := wttypes.ThumbnailOptions{}
+			if v.Thumbnails != nil {
+				opts = *v.Thumbnails
+			}
+			opts = opts.WithDefaults()
+			if err := opts.Validate(); err != nil {
+				return job, err
+			}
+
+			thumbnails = append(thumbnails, wttypes.TranscodingTask{Kind: wttypes.TASK_THUMBNAILS, Thumbnails: &opts})
+		default:
+			return job, wttypes.ErrInvalidArgument
+		}
+	}
 
-	// Error in communication
+	// Chunks of a long source are transcoded apart, a ladder needs the whole source
+	if job.Chunking != nil {
+		chunking := job.Chunking.WithDefaults()
+		if err := chunking.Validate(); err != nil {
+			return job, err
+		}
+		if job.ABR != nil {
+			return job, wttypes.ErrInvalidChunking
+		}
+		job.Chunking = &chunking
+	}
+
+	// A ladder is a single transcoding with every profile as a rendition
+	if job.ABR != nil && len(transcodings) > 0 {
+		abr := job.ABR.WithDefaults()
+		if err := abr.Validate(); err != nil {
+			return job, err
+		}
+		job.ABR = &abr
+
+		renditions := []string{}
+		for _, v := range transcodings {
+			renditions = append(renditions, v.Profile)
+		}
+
+		transcodings = []wttypes.TranscodingTask{
+			{Renditions: renditions, ABR: job.ABR},
+		}
+	}
+	job.Transcodings = append(transcodings, thumbnails...)
+
+	return job, nil
+}
+
+func (s *service) AddNewJob(job wttypes.Job) (string, error) {
+	job, err := s.prepareJob(job, false)
+	if err != nil {
+		return "", err
+	}
+
+	// Get the media, we need to inspect it before accepting the job
+	fn, temporary, err := wtcommon.GetLocalMedia(job.URLMedia)
+	if err != nil {
+		return "", err
+	}
+	if temporary {
+		defer os.Remove(fn)
+	}
+
+	// Reject media the workers won't be able to transcode
+	media, err := wtcommon.ProbeMedia(fn)
 	if err != nil {
 		return "", err
 	}
 
-	str := resp.String()
+	return s.createJob(job, media, fn, "")
+}
+
+func (s *service) AddNewJobUpload(job wttypes.Job, media io.Reader) (string, error) {
+	job, err := s.prepareJob(job, true)
+	if err != nil {
+		return "", err
+	}
 
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		return "", wtcommon.JSON2Err(str)
+	// Straight into Object Storage, the upload is not kept here
+	objectname, err := wtcommon.Stream2ObjectStorage(s.serviceObjectStorage, media, job.VideoName, wtcommon.SOURCE_MEDIA_CONTAINER)
+	if err != nil {
+		return "", wttypes.ErrCantUploadObject.WithDetails(map[string]string{
+			"reason": err.Error(),
+		})
 	}
 
+	fmt.Println("[jobs] uploaded media:", objectname)
+
+	id, err := s.createUploadedJob(job, objectname)
+	if err != nil && id == "" {
+		// Rejected, nobody is going to use it
+		if errOS := wtcommon.DeleteFromObjectStorage(s.serviceObjectStorage, objectname, wtcommon.SOURCE_MEDIA_CONTAINER); errOS != nil {
+			fmt.Println("[err] delete rejected upload:", objectname, errOS)
+		}
+	}
+
+	return id, err
+}
+
+// createUploadedJob creates the job of media already in Object Storage
+func (s *service) createUploadedJob(job wttypes.Job, objectname string) (string, error) {
+	// Inspected where it is, without downloading it all
+	media, err := wtcommon.ProbeObject(s.serviceObjectStorage, objectname, wtcommon.SOURCE_MEDIA_CONTAINER)
+	if err != nil {
+		return "", err
+	}
+
+	return s.createJob(job, media, "", objectname)
+}
+
+// createJob adds the job of the inspected media with its tasks. The media is
+// either in fn, uploaded into Object Storage here, or already there as objectname.
+func (s *service) createJob(job wttypes.Job, media wttypes.MediaInfo, fn string, objectname string) (string, error) {
+	// Reject media the workers won't be able to transcode
+	err := wtcommon.ValidateMedia(media)
+	if err != nil {
+		return "", err
+	}
+	job.Media = &media
+
+	fmt.Println("[jobs] media:", media.Container, media.VideoCodec, media.Width, media.Height, media.Duration)
+
+	//First let's upload to Object Storage
+	var errOS error
+	if objectname == "" {
+		objectname, errOS = wtcommon.Upload2ObjectStorage(s.serviceObjectStorage, fn, job.VideoName, wtcommon.SOURCE_MEDIA_CONTAINER)
+	}
+	if errOS == nil {
+		job.ObjectName = objectname
+		job.Status = wttypes.JOB_QUEUED
+	} else {
+		job.Status = wttypes.JOB_ERROR
+	}
+
+	// Long sources are split, so several workers transcode them at once
+	if errOS == nil && job.Chunking != nil && job.Chunking.Splits(media.Duration) {
+		chunks, err := s.uploadChunks(fn, objectname, job.Chunking.Duration)
+		if err == nil {
+			job.Transcodings = chunkTranscodings(job.Transcodings, chunks)
+		} else {
+			// Still doable by a single worker
+			fmt.Println("[err] chunking, transcoding whole source:", err)
+		}
+	}
+
+	// Ask DB to add job into DB (even with error, for logging purposes)
+	ctx := context.Background()
+
 	// Get IDs (job and transcodings)
-	ids, err := wtcommon.JSON2JobIDs(str)
+	ids, err := s.database.InsertJob(ctx, job)
 	if err != nil {
 		return "", err
 	}
 
 	// If job is in ERROR status, let's notify error even if everything else was OK
 	if job.Status == wttypes.JOB_ERROR {
-		return ids.ID, errors.New(wttypes.ErrCantUploadObject.Error() + ": " + errOS.Error())
+		return ids.ID, wttypes.ErrCantUploadObject.WithDetails(map[string]string{
+			"job_id": ids.ID,
+			"reason": errOS.Error(),
+		})
 	}
 
 	fmt.Println("[jobs] added job:", ids.ID)
 
-	// Let's send all transcodings tasks to Transcoding Manager
-	for _, v := range ids.Transcodings {
+	// Let's send all transcodings tasks to Transcoding Manager.
+	// IDs come in the same order, so every stitch goes after its chunks
+	chunks := make(map[string][]string)
+	for i, v := range ids.Transcodings {
 		v.ObjectName = job.ObjectName
-
-		resp, err := resty.R().
-			SetBody(v).
-			Post(s.manager + "/tasks")
-
-		if err != nil {
-			//TODO: do something when status update fails
+		v.Priority = job.Priority
+		v.Tenant = job.Tenant
+
+		switch v.Kind {
+		case wttypes.TASK_CHUNK:
+			v.ObjectName = job.Transcodings[i].ObjectName
+			chunks[v.Profile] = append(chunks[v.Profile], v.ID)
+		case wttypes.TASK_STITCH:
+			v.DependsOn = chunks[v.Profile]
 		}
 
-		str := resp.String()
-		if strings.HasPrefix(str, `{"error"`) {
+		err := s.manager.AddTranscoding(ctx, v)
+		if err != nil {
 			//TODO: do something when status update fails
+			fmt.Println("[err] add task in manager:", v.ID, err)
 		}
 
-		fmt.Println("[jobs] added task in manager:", v.ID, " ", v.Profile, " ", v.ObjectName)
+		fmt.Println("[jobs] added task in manager:", v.ID, " ", v.Kind, v.Profile, v.Renditions, " ", v.ObjectName)
 	}
 
 	return ids.ID, nil
 }
 
-func (s *service) GetJobStatus(jobID string) (string, error) {
-	// Ask DB to get job from DB
-	resp, err := resty.R().
-		Get(s.database + "/jobs/" + jobID)
+func (s *service) ListWebhookDeliveries(jobID string) ([]wttypes.WebhookDelivery, error) {
+	// Ask DB for the deliveries of the job
+	return s.database.ListWebhookDeliveries(context.Background(), jobID)
+}
 
-	// Error in communication
+func (s *service) GetJob(jobID string) (wttypes.Job, error) {
+	// Ask DB to get job from DB
+	job, err := s.database.GetJob(context.Background(), jobID)
 	if err != nil {
-		return "", err
+		return wttypes.Job{}, err
 	}
 
-	str := resp.String()
+	s.setDownloadURLs(&job)
 
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		return "", wtcommon.JSON2Err(str)
+	return job, nil
+}
 
+func (s *service) ListJobs(filter wttypes.JobFilter) (wttypes.JobList, error) {
+	// Ask DB for the page of jobs matching the filter
+	list, err := s.database.ListJobs(context.Background(), filter)
+	if err != nil {
+		return wttypes.JobList{}, err
 	}
 
-	// Get job
-	job, err := wtcommon.JSON2Job(str)
-	if err != nil {
-		return "", err
+	for i := range list.Jobs {
+		s.setDownloadURLs(&list.Jobs[i])
 	}
 
-	return job.Status, err
+	return list, nil
 }
 
-func (s *service) CancelJob(jobID string) error {
-	// Ask DB to get job from DB
-	resp, err := resty.R().
-		Get(s.database + "/jobs/" + jobID)
-
-	// Error in communication
-	if err != nil {
-		return err
-	}
-
-	str := resp.String()
+// setDownloadURLs sets where the output of every finished transcoding of the job can be fetched from
+func (s *service) setDownloadURLs(job *wttypes.Job) {
+	for i, v := range job.Transcodings {
+		// Chunks are only a step of the transcoding of their profile
+		if v.Status != wttypes.TRANSCODING_FINISHED || v.ObjectName == "" || v.Kind == wttypes.TASK_CHUNK {
+			continue
+		}
 
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		return wtcommon.JSON2Err(str)
+		// Ladders and thumbnails are uploaded under a prefix, point to the file to start with
+		name := v.ObjectName
+		switch {
+		case v.ABR != nil:
+			name += "/" + v.ABR.Playlist()
+		case v.Kind == wttypes.TASK_THUMBNAILS:
+			name += "/" + wttypes.THUMBNAILS_VTT
+		}
 
+		job.Transcodings[i].DownloadURL = wtcommon.ObjectURL(s.serviceObjectStorage, name, wtcommon.TRANSCODED_MEDIA_CONTAINER)
 	}
+}
 
-	// Get job
-	job, err := wtcommon.JSON2Job(str)
+func (s *service) CancelJob(jobID string) error {
+	ctx := context.Background()
+
+	// Ask DB to get job from DB
+	job, err := s.database.GetJob(ctx, jobID)
 	if err != nil {
 		return err
 	}
@@ -171,18 +397,12 @@ func (s *service) CancelJob(jobID string) error {
 		}
 
 		// Ask manager to cancel transcodings
-		fmt.Println("asking manager to cancel URL:", s.manager+"/tasks/"+v.ID)
+		fmt.Println("asking manager to cancel:", v.ID)
 		if v.Status == wttypes.TRANSCODING_QUEUED || v.Status == wttypes.TRANSCODING_RUNNING {
-			resp, err := resty.R().
-				Delete(s.manager + "/tasks/" + v.ID)
-
+			err := s.manager.CancelTranscoding(ctx, v.ID)
 			if err != nil {
 				//TODO: do something when cancel in manager fails
-			}
-
-			str := resp.String()
-			if strings.HasPrefix(str, `{"error"`) {
-				//TODO: do something when cancel in manager fails
+				fmt.Println("[err] cancel in manager:", v.ID, err)
 			}
 		}
 	}
@@ -191,21 +411,14 @@ func (s *service) CancelJob(jobID string) error {
 	job.Status = wttypes.JOB_CANCELLED
 
 	// Update DB
-	resp, err = resty.R().
-		SetBody(job).
-		Put(s.database + "/jobs/" + jobID)
-
-	// Error in communication
+	err = s.database.UpdateJob(ctx, job)
 	if err != nil {
 		return err
 	}
 
-	str = resp.String()
-
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		return wtcommon.JSON2Err(str)
-
+	// Whatever is still running of it won't upload anything
+	if job.Chunking != nil {
+		s.deleteChunks(job)
 	}
 
 	fmt.Println("[jobs]", "cancelled without any problem:", jobID)
@@ -216,29 +429,12 @@ func (s *service) CancelJob(jobID string) error {
 func (s *service) UpdateTranscodingStatus(id string, status string, objectname string) error {
 	fmt.Println("[jobs] received update status request:", id, status, objectname)
 
-	// Ask DB to get transcoding from DB
-	resp, err := resty.R().
-		Get(s.database + "/transcodings/" + id)
-
-	// Error in communication
-	if err != nil {
-		fmt.Println("err comm:", err)
-		return err
-	}
-
-	str := resp.String()
-
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		fmt.Println("err prefix:", str)
-		return wtcommon.JSON2Err(str)
+	ctx := context.Background()
 
-	}
-
-	// Get transcoding
-	t, err := wtcommon.JSON2Transcoding(str)
+	// Ask DB to get transcoding from DB
+	t, err := s.database.GetTranscoding(ctx, id)
 	if err != nil {
-		fmt.Println("err json2:", err)
+		fmt.Println("err get transcoding:", err)
 		return err
 	}
 
@@ -252,32 +448,36 @@ func (s *service) UpdateTranscodingStatus(id string, status string, objectname s
 	fmt.Println("[jobs] updated transcoding to:", id, status, objectname)
 
 	// Update DB
-	resp, err = resty.R().
-		SetBody(t).
-		Put(s.database + "/transcodings/" + id)
-
-	// Error in communication
+	err = s.database.UpdateTranscoding(ctx, t)
 	if err != nil {
 		return err
 	}
 
-	str = resp.String()
+	fmt.Println("[jobs] updated transcoding status")
 
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		return wtcommon.JSON2Err(str)
+	// Chunks are no longer needed once their stitches ended (cancelling the job deletes them on its own)
+	if (t.Kind == wttypes.TASK_CHUNK || t.Kind == wttypes.TASK_STITCH) && transcodingEnded(status) && status != wttypes.TRANSCODING_CANCELLED {
+		job, err := s.database.GetJob(ctx, t.JobID)
+		if err != nil {
+			fmt.Println("[err] get job of chunks:", t.JobID, err)
+			return nil
+		}
 
+		if chunksDone(job.Transcodings) {
+			s.deleteChunks(job)
+		}
 	}
 
-	fmt.Println("[jobs] updated transcoding status")
-
 	return nil
 }
 
+func (s *service) UpdateTranscodingProgress(id string, progress wttypes.TranscodingProgress) error {
+	// Only the progress, the status could be changing meanwhile
+	return s.database.UpdateTranscodingProgress(context.Background(), id, progress)
+}
+
 // NewService creates a jobs service with necessary dependencies.
 func NewService(database, manager string) (Service, error) {
-	resty.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-
 	provider, err := wtcommon.GetProvider()
 	if err != nil {
 		return &service{}, err
@@ -292,7 +492,9 @@ func NewService(database, manager string) (Service, error) {
 		provider:             provider,
 		serviceObjectStorage: serviceObjectStorage,
 
-		database: database,
-		manager:  manager,
+		profiles: wtcommon.NewProfileCache(database, wtcommon.PROFILE_CACHE_TTL),
+
+		database: dbclient.New(database, wtclient.TIMEOUT),
+		manager:  managerclient.New(manager, wtclient.TIMEOUT),
 	}, nil
 }
@@ -3,7 +3,9 @@ package jobs
 import (
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
 
 	"golang.org/x/net/context"
 
@@ -12,6 +14,7 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
@@ -22,7 +25,12 @@ func MakeHandler(ctx context.Context, js Service, logger kitlog.Logger) http.Han
 		kithttp.ServerErrorEncoder(encodeError),
 	}
 
-	// test: curl -k -H "Content-Type: application/json" -d '{"url_media":"http://obazavil-nuc/big_buck_bunny_720p_1mb.mp4", "video_name":"conejo", "transcodings":[{"profile":"iPhone5s"},{"profile":"iPadMini4"}]}' -X POST https://localhost:8081/jobs
+	// test: curl -k -H "Content-Type: application/json" -d '{"url_media":"http://obazavil-nuc/big_buck_bunny_720p_1mb.mp4", "video_name":"conejo", "priority":8, "tenant":"teamA", "transcodings":[{"profile":"iPhone5s"},{"profile":"iPadMini4"}]}' -X POST https://localhost:8081/jobs
+	// test (ABR ladder): curl -k -H "Content-Type: application/json" -d '{"url_media":"http://obazavil-nuc/big_buck_bunny_720p_1mb.mp4", "video_name":"conejo", "abr":{"formats":["hls","dash"], "segment_duration":6}, "transcodings":[{"profile":"iPhone4s"},{"profile":"iPhonePlus6s"}]}' -X POST https://localhost:8081/jobs
+	// test (chunked): curl -k -H "Content-Type: application/json" -d '{"url_media":"http://obazavil-nuc/big_buck_bunny_1080p_full.mp4", "video_name":"conejo", "chunking":{"duration":120, "min_duration":600}, "transcodings":[{"profile":"iPhone5s"},{"profile":"iPadMini4"}]}' -X POST https://localhost:8081/jobs
+	// test (webhook): curl -k -H "Content-Type: application/json" -d '{"url_media":"http://obazavil-nuc/big_buck_bunny_720p_1mb.mp4", "video_name":"conejo", "webhook":{"url":"https://client.example.com/hooks/transcoding", "events":["job.finished","job.error"], "secret":"s3cr3t"}, "transcodings":[{"profile":"iPhone5s"}]}' -X POST https://localhost:8081/jobs
+	// test (thumbnails): curl -k -H "Content-Type: application/json" -d '{"url_media":"http://obazavil-nuc/big_buck_bunny_720p_1mb.mp4", "video_name":"conejo", "transcodings":[{"profile":"iPhone5s"},{"kind":"thumbnails", "thumbnails":{"interval":5, "width":160, "height":90, "columns":5}}]}' -X POST https://localhost:8081/jobs
+	// test (invalid, 400 with the errors of each field in details): curl -k -H "Content-Type: application/json" -d '{"url_media":"file:///etc/passwd", "transcodings":[{"profile":"iPhone5s"},{"profile":"iPhone5s"},{"profile":"nope"}]}' -X POST https://localhost:8081/jobs
 	addNewJobHandler := kithttp.NewServer(
 		ctx,
 		makeAddNewJobEndpoint(js),
@@ -31,11 +39,30 @@ func MakeHandler(ctx context.Context, js Service, logger kitlog.Logger) http.Han
 		opts...,
 	)
 
+	// test: curl -k -F 'job={"priority":8, "transcodings":[{"profile":"iPhone5s"},{"profile":"iPadMini4"}]};type=application/json' -F media=@big_buck_bunny_720p_1mb.mp4 -X POST https://localhost:8081/jobs/upload
+	addNewJobUploadHandler := kithttp.NewServer(
+		ctx,
+		makeAddNewJobUploadEndpoint(js),
+		decodeAddNewJobUploadRequest,
+		encodeResponse,
+		opts...,
+	)
+
 	// test: curl -k https://localhost:8081/jobs/1
-	getJobStatusHandler := kithttp.NewServer(
+	getJobHandler := kithttp.NewServer(
 		ctx,
-		makeGetJobStatusEndpoint(js),
-		decodeGetJobStatusRequest,
+		makeGetJobEndpoint(js),
+		decodeGetJobRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k "https://localhost:8081/jobs?status=finished&video_name=conejo&from=2017-01-01T00:00:00Z&to=2017-02-01T00:00:00Z&sort=-ended&limit=20"
+	// test: curl -k "https://localhost:8081/jobs?sort=-ended&limit=20&cursor=<next_cursor of the previous page>"
+	listJobsHandler := kithttp.NewServer(
+		ctx,
+		makeListJobsEndpoint(js),
+		decodeListJobsRequest,
 		encodeResponse,
 		opts...,
 	)
@@ -58,13 +85,87 @@ func MakeHandler(ctx context.Context, js Service, logger kitlog.Logger) http.Han
 		opts...,
 	)
 
+	// test: curl -k -H "Content-Type: application/json" -d '{"percent":42.5, "fps":30, "eta":120}' -X PUT https://localhost:8081/transcodings/1/progress
+	updateTranscodingProgressHandler := kithttp.NewServer(
+		ctx,
+		makeUpdateTranscodingProgressEndpoint(js),
+		decodeUpdateTranscodingProgressRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k https://localhost:8081/jobs/1/webhooks
+	listWebhookDeliveriesHandler := kithttp.NewServer(
+		ctx,
+		makeListWebhookDeliveriesEndpoint(js),
+		decodeListWebhookDeliveriesRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// Resumable uploads (tus 1.0.0 style): create, send chunks at the offset, ask for the offset to resume, finish
+	// test: curl -k -i -H "Content-Type: application/json" -d '{"filename":"big_buck_bunny_4k.mp4", "length":7340032000, "job":{"transcodings":[{"profile":"iPhone5s"}]}}' -X POST https://localhost:8081/uploads
+	createUploadHandler := kithttp.NewServer(
+		ctx,
+		makeCreateUploadEndpoint(js),
+		decodeCreateUploadRequest,
+		encodeUploadResponse,
+		opts...,
+	)
+
+	// test: curl -k -I https://localhost:8081/uploads/1
+	getUploadHandler := kithttp.NewServer(
+		ctx,
+		makeGetUploadEndpoint(js),
+		decodeGetUploadRequest,
+		encodeUploadResponse,
+		opts...,
+	)
+
+	// test: dd if=big_buck_bunny_4k.mp4 bs=100M skip=0 count=1 | curl -k -i -H "Content-Type: application/offset+octet-stream" -H "Upload-Offset: 0" --data-binary @- -X PATCH https://localhost:8081/uploads/1
+	writeUploadHandler := kithttp.NewServer(
+		ctx,
+		makeWriteUploadEndpoint(js),
+		decodeWriteUploadRequest,
+		encodeUploadResponse,
+		opts...,
+	)
+
+	// test: curl -k -X POST https://localhost:8081/uploads/1/finish
+	finishUploadHandler := kithttp.NewServer(
+		ctx,
+		makeFinishUploadEndpoint(js),
+		decodeFinishUploadRequest,
+		encodeUploadResponse,
+		opts...,
+	)
+
+	// test: curl -k -X DELETE https://localhost:8081/uploads/1
+	cancelUploadHandler := kithttp.NewServer(
+		ctx,
+		makeCancelUploadEndpoint(js),
+		decodeCancelUploadRequest,
+		encodeUploadResponse,
+		opts...,
+	)
+
 	r := mux.NewRouter()
 
 	r.Handle("/jobs", addNewJobHandler).Methods("POST")
-	r.Handle("/jobs/{id}", getJobStatusHandler).Methods("GET")
+	r.Handle("/jobs", listJobsHandler).Methods("GET")
+	r.Handle("/jobs/upload", addNewJobUploadHandler).Methods("POST")
+	r.Handle("/jobs/{id}", getJobHandler).Methods("GET")
 	r.Handle("/jobs/{id}", cancelJobHandler).Methods("DELETE")
+	r.Handle("/jobs/{id}/webhooks", listWebhookDeliveriesHandler).Methods("GET")
+
+	r.Handle("/uploads", createUploadHandler).Methods("POST")
+	r.Handle("/uploads/{id}", getUploadHandler).Methods("GET", "HEAD")
+	r.Handle("/uploads/{id}", writeUploadHandler).Methods("PATCH")
+	r.Handle("/uploads/{id}", cancelUploadHandler).Methods("DELETE")
+	r.Handle("/uploads/{id}/finish", finishUploadHandler).Methods("POST")
 
 	r.Handle("/transcodings/{id}/status", updateTranscodingStatusHandler).Methods("PUT")
+	r.Handle("/transcodings/{id}/progress", updateTranscodingProgressHandler).Methods("PUT")
 
 	return r
 
@@ -77,20 +178,57 @@ func decodeAddNewJobRequest(_ context.Context, r *http.Request) (interface{}, er
 		return nil, err
 	}
 
-	//TODO: Decode not always throws error, extra validate all needed fields "decoded:  {    [] }"
-	//TODO: validate ID is empty
-
+	// Fields are validated by the service, against the profile catalogue
 	return addNewJobRequest{Job: job}, nil
 }
 
-func decodeGetJobStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
+func decodeAddNewJobUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, wttypes.ErrInvalidUpload
+	}
+
+	// Job goes first, so the media can be streamed as it arrives
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != "job" {
+		return nil, wttypes.ErrInvalidUpload
+	}
+
+	var job wttypes.Job
+	if err := json.NewDecoder(part).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	part, err = mr.NextPart()
+	if err != nil || part.FormName() != "media" {
+		return nil, wttypes.ErrInvalidUpload
+	}
+
+	// Without a name, the one of the uploaded file
+	if job.VideoName == "" {
+		job.VideoName = part.FileName()
+	}
+
+	return addNewJobUploadRequest{Job: job, Media: part}, nil
+}
+
+func decodeGetJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	vars := mux.Vars(r)
 
 	id, ok := vars["id"]
 	if !ok {
 		return nil, wttypes.ErrBadRoute
 	}
-	return getJobStatusRequest{ID: string(id)}, nil
+	return getJobRequest{ID: string(id)}, nil
+}
+
+func decodeListJobsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	filter, err := wttypes.ParseJobFilter(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return listJobsRequest{Filter: filter}, nil
 }
 
 func decodeCancelJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -103,6 +241,16 @@ func decodeCancelJobRequest(_ context.Context, r *http.Request) (interface{}, er
 	return cancelJobRequest{ID: string(id)}, nil
 }
 
+func decodeListWebhookDeliveriesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return listWebhookDeliveriesRequest{ID: string(id)}, nil
+}
+
 func decodeUpdateTranscodingStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var body struct {
 		Status     string `json:"status"`
@@ -129,6 +277,92 @@ func decodeUpdateTranscodingStatusRequest(_ context.Context, r *http.Request) (i
 	return updateTranscodingStatusRequest{ID: id, Status: body.Status, ObjectName: body.ObjectName}, nil
 }
 
+func decodeUpdateTranscodingProgressRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var progress wttypes.TranscodingProgress
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&progress); err != nil {
+		return nil, err
+	}
+
+	return updateTranscodingProgressRequest{ID: id, Progress: progress}, nil
+}
+
+func decodeCreateUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var u wttypes.Upload
+
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		return nil, err
+	}
+
+	return createUploadRequest{Upload: u}, nil
+}
+
+func decodeGetUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return getUploadRequest{ID: id}, nil
+}
+
+func decodeWriteUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != wttypes.UPLOAD_CONTENT_TYPE {
+		return nil, wttypes.ErrInvalidUploadChunk.WithDetails(map[string]string{
+			"content_type": "must be " + wttypes.UPLOAD_CONTENT_TYPE,
+		})
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(wttypes.UPLOAD_HEADER_OFFSET), 10, 64)
+	if err != nil || offset < 0 {
+		return nil, wttypes.ErrInvalidUploadChunk.WithDetails(map[string]string{
+			"upload_offset": "required, in bytes",
+		})
+	}
+
+	// Rejected before uploading any of it when it's known to be too long
+	if r.ContentLength > wttypes.UPLOAD_MAX_CHUNK {
+		return nil, wttypes.ErrInvalidUploadChunk.WithDetails(map[string]string{
+			"content_length": "up to " + strconv.FormatInt(wttypes.UPLOAD_MAX_CHUNK, 10) + " bytes",
+		})
+	}
+
+	// The chunk is streamed into object storage as it arrives
+	return writeUploadRequest{ID: id, Offset: offset, Chunk: r.Body}, nil
+}
+
+func decodeFinishUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return finishUploadRequest{ID: id}, nil
+}
+
+func decodeCancelUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+	return cancelUploadRequest{ID: id}, nil
+}
+
 type errorer interface {
 	error() error
 }
@@ -142,20 +376,39 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 	return json.NewEncoder(w).Encode(response)
 }
 
-// encode errors from business-logic
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	switch err {
-	case wttypes.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case wttypes.ErrInvalidArgument:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+// encodeUploadResponse is encodeResponse with the headers of the resumable upload protocol
+func encodeUploadResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set(wttypes.UPLOAD_HEADER_RESUMABLE, wttypes.UPLOAD_TUS_VERSION)
+
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+
+	status := http.StatusOK
+	switch v := response.(type) {
+	case writeUploadResponse:
+		// Nothing else to tell than the new offset
+		w.Header().Set(wttypes.UPLOAD_HEADER_OFFSET, strconv.FormatInt(v.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case uploadResponse:
+		w.Header().Set(wttypes.UPLOAD_HEADER_OFFSET, strconv.FormatInt(v.Upload.Offset, 10))
+		w.Header().Set(wttypes.UPLOAD_HEADER_LENGTH, strconv.FormatInt(v.Upload.Length, 10))
+		w.Header().Set("Cache-Control", "no-store")
+
+		if v.created {
+			w.Header().Set("Location", "/uploads/"+v.Upload.ID)
+			status = http.StatusCreated
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(response)
+}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+// encode errors from business-logic
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	wtcommon.EncodeError(err, w)
 }
@@ -0,0 +1,279 @@
+package jobs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+func (s *service) CreateUpload(u wttypes.Upload) (wttypes.Upload, error) {
+	err := u.Validate()
+	if err != nil {
+		return wttypes.Upload{}, err
+	}
+
+	// Without a name, the one of the uploaded file
+	if u.Job.VideoName == "" {
+		u.Job.VideoName = u.Filename
+	}
+
+	// Rejected now, rather than after uploading the whole media
+	_, err = s.prepareJob(u.Job, true)
+	if err != nil {
+		return wttypes.Upload{}, err
+	}
+
+	id, err := s.database.InsertUpload(context.Background(), u)
+	if err != nil {
+		return wttypes.Upload{}, err
+	}
+
+	fmt.Println("[jobs] created upload:", id, u.Length)
+
+	return s.GetUpload(id)
+}
+
+func (s *service) GetUpload(id string) (wttypes.Upload, error) {
+	u, err := s.database.GetUpload(context.Background(), id)
+	if err != nil {
+		return wttypes.Upload{}, err
+	}
+
+	// The secret of the webhook is never returned back
+	if u.Job.Webhook != nil {
+		w := *u.Job.Webhook
+		w.Secret = ""
+		u.Job.Webhook = &w
+	}
+
+	return u, nil
+}
+
+func (s *service) WriteUpload(id string, offset int64, chunk io.Reader) (int64, error) {
+	ctx := context.Background()
+
+	u, err := s.database.GetUpload(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if u.Status != wttypes.UPLOAD_UPLOADING {
+		return 0, wttypes.ErrUploadNotUploading
+	}
+	if offset != u.Offset {
+		return 0, wttypes.ErrUploadOffsetMismatch.WithDetails(map[string]string{
+			"offset": strconv.FormatInt(u.Offset, 10),
+		})
+	}
+
+	// Up to the rest of the media, and what Swift takes in an object
+	max := u.Length - u.Offset
+	if max > wttypes.UPLOAD_MAX_CHUNK {
+		max = wttypes.UPLOAD_MAX_CHUNK
+	}
+
+	// Named after the offset so they sort in order, and unique so a chunk sent
+	// twice at once doesn't overwrite the one taken
+	name := fmt.Sprintf("%s/%020d-%d", id, offset, time.Now().UnixNano())
+
+	seg, err := wtcommon.Segment2ObjectStorage(s.serviceObjectStorage, io.LimitReader(chunk, max), name, wtcommon.SEGMENTS_CONTAINER)
+	if err != nil {
+		// Nothing is kept of a failed chunk, it's resumed from the same offset
+		return 0, wttypes.ErrCantUploadObject.WithDetails(map[string]string{
+			"upload_id": id,
+			"reason":    err.Error(),
+		})
+	}
+	seg.Offset = offset
+
+	// A byte left tells the chunk is too long
+	_, errMore := io.ReadFull(chunk, make([]byte, 1))
+
+	last := offset+seg.Size == u.Length
+	if seg.Size == 0 || errMore == nil || (seg.Size < wttypes.UPLOAD_MIN_CHUNK && !last) {
+		s.deleteSegments([]wttypes.UploadSegment{seg})
+		return 0, wttypes.ErrInvalidUploadChunk
+	}
+
+	u, err = s.database.AddUploadSegment(ctx, id, seg)
+	if err != nil {
+		// Another chunk was taken at this offset
+		s.deleteSegments([]wttypes.UploadSegment{seg})
+		return 0, err
+	}
+
+	fmt.Println("[jobs] upload chunk:", id, seg.Offset, seg.Size)
+
+	return u.Offset, nil
+}
+
+func (s *service) FinishUpload(id string) (string, error) {
+	ctx := context.Background()
+
+	u, err := s.database.GetUpload(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	// Finishing again gets the same job
+	if u.Status == wttypes.UPLOAD_FINISHED {
+		return u.JobID, nil
+	}
+
+	if !u.Complete() {
+		return "", wttypes.ErrUploadIncomplete.WithDetails(map[string]string{
+			"offset": strconv.FormatInt(u.Offset, 10),
+			"length": strconv.FormatInt(u.Length, 10),
+		})
+	}
+
+	// Only one can finish it
+	err = s.database.UpdateUploadStatus(ctx, id, wttypes.UPLOAD_UPLOADING, wttypes.UPLOAD_FINISHING, "")
+	if err != nil {
+		return "", err
+	}
+
+	// Profiles could be gone since it was created
+	job, err := s.prepareJob(u.Job, true)
+	if err != nil {
+		s.failUpload(u, "", err)
+		return "", err
+	}
+
+	objectname, err := wtcommon.LargeObject2ObjectStorage(s.serviceObjectStorage, u.Segments, job.VideoName, wtcommon.SOURCE_MEDIA_CONTAINER)
+	if err != nil {
+		err = wttypes.ErrCantUploadObject.WithDetails(map[string]string{
+			"upload_id": id,
+			"reason":    err.Error(),
+		})
+		s.failUpload(u, "", err)
+		return "", err
+	}
+
+	fmt.Println("[jobs] finished upload:", id, objectname, len(u.Segments))
+
+	// Kept so the large object is removed too if finishing never ends
+	errDB := s.database.UpdateUploadObject(ctx, id, objectname)
+	if errDB != nil {
+		fmt.Println("[err] update upload object:", id, errDB)
+	}
+
+	jobID, err := s.createUploadedJob(job, objectname)
+	if jobID == "" {
+		s.failUpload(u, objectname, err)
+		return "", err
+	}
+
+	errDB = s.database.UpdateUploadStatus(ctx, id, wttypes.UPLOAD_FINISHING, wttypes.UPLOAD_FINISHED, jobID)
+	if errDB != nil {
+		fmt.Println("[err] update upload status:", id, errDB)
+	}
+
+	return jobID, err
+}
+
+func (s *service) CancelUpload(id string) error {
+	ctx := context.Background()
+
+	u, err := s.database.GetUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Nobody can finish it meanwhile
+	err = s.database.UpdateUploadStatus(ctx, id, wttypes.UPLOAD_UPLOADING, wttypes.UPLOAD_FINISHING, "")
+	if err != nil {
+		return err
+	}
+
+	s.removeUpload(u, "")
+
+	fmt.Println("[jobs] cancelled upload:", id)
+
+	return nil
+}
+
+func (s *service) ExpireUploads(ttl time.Duration) (int, error) {
+	ctx := context.Background()
+
+	uploads, err := s.database.ListStaleUploads(ctx, time.Now().Add(-ttl))
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, u := range uploads {
+		// Nobody can write or finish it meanwhile, the ones finishing got stuck
+		if u.Status == wttypes.UPLOAD_UPLOADING {
+			err := s.database.UpdateUploadStatus(ctx, u.ID, wttypes.UPLOAD_UPLOADING, wttypes.UPLOAD_FINISHING, "")
+			if err != nil {
+				continue
+			}
+		}
+
+		s.removeUpload(u, u.ObjectName)
+		expired++
+
+		fmt.Println("[jobs] expired upload:", u.ID, u.Status, u.Offset, u.Length)
+	}
+
+	return expired, nil
+}
+
+// failUpload handles an upload that couldn't be finished: when the job was rejected
+// the upload is removed, otherwise it's left to be finished again
+func (s *service) failUpload(u wttypes.Upload, objectname string, err error) {
+	if wttypes.ToError(err).Status < http.StatusInternalServerError {
+		fmt.Println("[jobs] rejected upload:", u.ID, err)
+		s.removeUpload(u, objectname)
+		return
+	}
+
+	fmt.Println("[err] finish upload:", u.ID, err)
+
+	// Only the manifest, the segments are needed to finish it again
+	if objectname != "" {
+		errOS := wtcommon.DeleteFromObjectStorage(s.serviceObjectStorage, objectname, wtcommon.SOURCE_MEDIA_CONTAINER)
+		if errOS != nil {
+			fmt.Println("[err] delete upload manifest:", objectname, errOS)
+		}
+	}
+
+	errDB := s.database.UpdateUploadStatus(context.Background(), u.ID, wttypes.UPLOAD_FINISHING, wttypes.UPLOAD_UPLOADING, "")
+	if errDB != nil {
+		fmt.Println("[err] update upload status:", u.ID, errDB)
+	}
+}
+
+// removeUpload removes an upload with everything uploaded, through its large object when created
+func (s *service) removeUpload(u wttypes.Upload, objectname string) {
+	if objectname != "" {
+		err := wtcommon.DeleteLargeObject(s.serviceObjectStorage, objectname, wtcommon.SOURCE_MEDIA_CONTAINER)
+		if err != nil {
+			fmt.Println("[err] delete upload object:", objectname, err)
+		}
+	} else {
+		s.deleteSegments(u.Segments)
+	}
+
+	err := s.database.DeleteUpload(context.Background(), u.ID)
+	if err != nil {
+		fmt.Println("[err] delete upload:", u.ID, err)
+	}
+}
+
+func (s *service) deleteSegments(segments []wttypes.UploadSegment) {
+	for _, v := range segments {
+		err := wtcommon.DeleteFromObjectStorage(s.serviceObjectStorage, v.ObjectName, wtcommon.SEGMENTS_CONTAINER)
+		if err != nil {
+			fmt.Println("[err] delete upload segment:", v.ObjectName, err)
+		}
+	}
+}
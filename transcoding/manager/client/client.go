@@ -0,0 +1,167 @@
+package client
+
+import (
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Client is a client of the transcoding manager service
+type Client struct {
+	c *wtclient.Client
+}
+
+// AddTranscoding adds a new task
+func (cl *Client) AddTranscoding(ctx context.Context, task wttypes.TranscodingTask) error {
+	return cl.c.Call(ctx, "POST", "/tasks", task, nil)
+}
+
+// CancelTranscoding cancels a transcoding task
+func (cl *Client) CancelTranscoding(ctx context.Context, id string) error {
+	return cl.c.Call(ctx, "DELETE", "/tasks/"+id, nil, nil)
+}
+
+// GetTotalTasksQueued gets the total of queued tasks
+func (cl *Client) GetTotalTasksQueued(ctx context.Context) (int, error) {
+	var resp struct {
+		Total int `json:"total"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/tasks/queued", nil, &resp)
+
+	return resp.Total, err
+}
+
+// GetTotalTasksRunning gets the total of active tasks
+func (cl *Client) GetTotalTasksRunning(ctx context.Context) (int, error) {
+	var resp struct {
+		Total int `json:"total"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/tasks/running", nil, &resp)
+
+	return resp.Total, err
+}
+
+// GetNextTask gets the next task the worker is capable of, manager holds the
+// request up to wait for one to be queued
+func (cl *Client) GetNextTask(ctx context.Context, workerAddr string, caps *wttypes.WorkerCapabilities, wait time.Duration) (wttypes.TranscodingTask, error) {
+	var resp struct {
+		Task wttypes.TranscodingTask `json:"task"`
+	}
+
+	query := url.Values{}
+	if caps != nil {
+		query = caps.Values()
+	}
+	query.Set("worker", workerAddr)
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+
+	// Give manager the time it holds us, on top of the usual one
+	timeout := cl.c.Timeout
+	if timeout > 0 {
+		timeout += wait
+	}
+
+	err := cl.c.CallTimeout(ctx, timeout, "GET", "/tasks?"+query.Encode(), nil, &resp)
+
+	return resp.Task, err
+}
+
+// GetTenants gets the fair-share status of the tenants
+func (cl *Client) GetTenants(ctx context.Context) ([]wttypes.TenantStatus, error) {
+	var resp struct {
+		Tenants []wttypes.TenantStatus `json:"tenants"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/tenants", nil, &resp)
+
+	return resp.Tenants, err
+}
+
+// AckTask acknowledges a task handed out by GetNextTask, returns the lease of the
+// task (0 when manager doesn't tell it)
+func (cl *Client) AckTask(ctx context.Context, id string, workerAddr string) (time.Duration, error) {
+	body := struct {
+		Worker string `json:"worker"`
+	}{
+		Worker: workerAddr,
+	}
+
+	var resp struct {
+		Lease float64 `json:"lease"`
+	}
+
+	err := cl.c.Call(ctx, "PUT", "/tasks/"+id+"/ack", body, &resp)
+
+	return time.Duration(resp.Lease * float64(time.Second)), err
+}
+
+// RenewLease renews the lease of a running task
+func (cl *Client) RenewLease(ctx context.Context, id string, workerAddr string) error {
+	body := struct {
+		Worker string `json:"worker"`
+	}{
+		Worker: workerAddr,
+	}
+
+	return cl.c.Call(ctx, "PUT", "/tasks/"+id+"/lease", body, nil)
+}
+
+// UpdateTaskProgress updates how far a running task is
+func (cl *Client) UpdateTaskProgress(ctx context.Context, id string, workerAddr string, progress wttypes.TranscodingProgress) error {
+	body := struct {
+		Worker   string                      `json:"worker"`
+		Progress wttypes.TranscodingProgress `json:"progress"`
+	}{
+		Worker:   workerAddr,
+		Progress: progress,
+	}
+
+	return cl.c.Call(ctx, "PUT", "/tasks/"+id+"/progress", body, nil)
+}
+
+// RetryTask re-queues a task that failed with a transient error, returns the new status
+func (cl *Client) RetryTask(ctx context.Context, id string, workerAddr string, reason string) (string, error) {
+	body := struct {
+		Worker string `json:"worker"`
+		Error  string `json:"error"`
+	}{
+		Worker: workerAddr,
+		Error:  reason,
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+	}
+
+	err := cl.c.Call(ctx, "PUT", "/tasks/"+id+"/retry", body, &resp)
+
+	return resp.Status, err
+}
+
+// UpdateTaskStatus updates the status of a task running in the worker
+func (cl *Client) UpdateTaskStatus(ctx context.Context, id string, workerAddr string, status string) error {
+	body := struct {
+		Worker string `json:"worker"`
+		Status string `json:"status"`
+	}{
+		Worker: workerAddr,
+		Status: status,
+	}
+
+	return cl.c.Call(ctx, "PUT", "/tasks/"+id+"/status", body, nil)
+}
+
+// New creates a client of the transcoding manager service at addr (https://server:port)
+func New(addr string, timeout time.Duration) *Client {
+	return &Client{
+		c: wtclient.New(addr, timeout),
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"golang.org/x/net/context"
@@ -15,12 +16,21 @@ import (
 	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 )
 
-// test: go run transcoding/manager/cmd/main.go
+// test: go run transcoding/manager/cmd/main.go -database=https://localhost:8080
 func main() {
 	var err error
 
 	var (
-		httpAddr = ":" + wtcommon.MANAGER_PORT
+		httpAddr    = ":" + wtcommon.MANAGER_PORT
+		database    = flag.String("database", "", "Database service address (http://server:port), to report tasks failed by the manager and get profiles (required)")
+		ackDeadline = flag.Duration("ack", 30*time.Second, "Time a worker has to ACK a requested task before it's re-queued")
+		lease       = flag.Duration("lease", 60*time.Second, "Time a running task lease lasts without being renewed by the worker, workers renew it every third of it (min 3s)")
+		maxAttempts = flag.Int("attempts", 3, "Times a task can be started before marking it as error when it keeps failing")
+		backoff     = flag.Duration("backoff", 30*time.Second, "Delay before retrying a failed task, doubled on every attempt")
+		aging       = flag.Duration("aging", 5*time.Minute, "Time a task waits in queue before its priority is raised by one")
+		weights     = flag.String("tenant-weights", "", "Fair-share weight per tenant (teamA=2,teamB=1), unlisted tenants have weight 1")
+		caps        = flag.String("tenant-caps", "", "Max running tasks per tenant (teamA=4,teamB=2)")
+		defaultCap  = flag.Int("tenant-cap", 0, "Max running tasks for tenants not in -tenant-caps, 0 means unlimited")
 	)
 	flag.Parse()
 
@@ -37,9 +47,37 @@ func main() {
 		ctx = context.Background()
 	}
 
+	// Tasks the manager fails would never reach their jobs otherwise
+	if !wtcommon.IsValidURL(*database) {
+		logger.Log("error", "Invalid address for database service")
+		os.Exit(1)
+	}
+
+	if *lease < manager.MinLease {
+		logger.Log("error", "Lease must be at least "+manager.MinLease.String())
+		os.Exit(1)
+	}
+
+	var policy manager.TenantPolicy
+	{
+		policy.Weights, err = manager.ParseTenantValues(*weights)
+		if err != nil {
+			logger.Log("error", "Invalid tenant weights: "+err.Error())
+			os.Exit(1)
+		}
+
+		policy.Caps, err = manager.ParseTenantValues(*caps)
+		if err != nil {
+			logger.Log("error", "Invalid tenant caps: "+err.Error())
+			os.Exit(1)
+		}
+
+		policy.DefaultCap = *defaultCap
+	}
+
 	var tms manager.Service
 	{
-		tms, err = manager.NewService()
+		tms, err = manager.NewService(*database, *ackDeadline, *lease, *maxAttempts, *backoff, *aging, policy)
 		if err != nil {
 			logger.Log("error", "Cannot create service: "+err.Error())
 			os.Exit(1)
@@ -58,6 +96,40 @@ func main() {
 		logger.Log("transport", "http", "address", httpAddr, "msg", "listening")
 		errs <- http.ListenAndServeTLS(httpAddr, "certs/server.pem", "certs/server.key", nil)
 	}()
+	go func() {
+		// Re-queue tasks whose worker never acknowledged them, checking several
+		// times per deadline so they don't wait up to twice as long
+		for {
+			time.Sleep(*ackDeadline / 4)
+
+			_, err := tms.RequeueUnacknowledgedTasks()
+			if err != nil {
+				logger.Log("error", "Cannot re-queue unacknowledged tasks: "+err.Error())
+			}
+		}
+	}()
+	go func() {
+		// Re-queue tasks whose worker stopped renewing the lease
+		for {
+			time.Sleep(*lease / 2)
+
+			err := tms.ReapExpiredLeases()
+			if err != nil {
+				logger.Log("error", "Cannot reap expired leases: "+err.Error())
+			}
+		}
+	}()
+	go func() {
+		// Raise priority of old queued tasks so they are not starved
+		for {
+			time.Sleep(*aging)
+
+			_, err := tms.AgeQueuedTasks()
+			if err != nil {
+				logger.Log("error", "Cannot age queued tasks: "+err.Error())
+			}
+		}
+	}()
 	go func() {
 		c := make(chan os.Signal)
 		signal.Notify(c, syscall.SIGINT)
@@ -4,26 +4,49 @@ import (
 	"fmt"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"sort"
 	"time"
 
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
 const (
-	MongoDB              = "transcoding"
-	MongoTasksCollection = "tasks"
+	MongoDB                = "transcoding"
+	MongoTasksCollection   = "tasks"
+	MongoTenantsCollection = "tenants"
+
+	MaxBackoff = 30 * time.Minute
 )
 
 type TaskDB struct {
-	ID            bson.ObjectId `bson:"_id"`
-	TranscodingID string        `bson:"transcoding_id"`
-	ObjectName    string        `bson:"object_name"`
-	Profile       string        `bson:"profile"`
-	WorkerAddr    string        `bson:"worker_addr"`
-	Added         time.Time     `bson:"added"`
-	Started       time.Time     `bson:"started"`
-	Ended         time.Time     `bson:"ended"`
-	Status        string        `bson:"status"`
+	ID                bson.ObjectId `bson:"_id"`
+	TranscodingID     string        `bson:"transcoding_id"`
+	ObjectName        string        `bson:"object_name"`
+	Profile           string        `bson:"profile"`
+	Renditions        []string      `bson:"renditions,omitempty"`
+	Tenant            string        `bson:"tenant"`
+	Priority          int           `bson:"priority"`
+	EffectivePriority int           `bson:"effective_priority"`
+	Aged              time.Time     `bson:"aged"`
+	WorkerAddr        string        `bson:"worker_addr"`
+	Added             time.Time     `bson:"added"`
+	Requested         time.Time     `bson:"requested"`
+	Started           time.Time     `bson:"started"`
+	Ended             time.Time     `bson:"ended"`
+	LeaseExpires      time.Time     `bson:"lease_expires"`
+	Attempts          int           `bson:"attempts"`
+	NotBefore         time.Time     `bson:"not_before"`
+	LastError         string        `bson:"last_error"`
+	WorkerHistory     []string      `bson:"worker_history"`
+	DroppedBy         []string      `bson:"dropped_by"`
+	Status            string        `bson:"status"`
+
+	Progress *wttypes.TranscodingProgress `bson:"progress,omitempty"`
+	ABR      *wttypes.ABROptions          `bson:"abr,omitempty"`
+
+	Kind       string                    `bson:"kind,omitempty"`
+	Thumbnails *wttypes.ThumbnailOptions `bson:"thumbnails,omitempty"`
+	DependsOn  []string                  `bson:"depends_on,omitempty"`
 }
 
 type DataStore struct {
@@ -91,19 +114,132 @@ func CreateMongoSession() (*mgo.Session, error) {
 		return nil, err
 	}
 
+	idxQueue := mgo.Index{
+		Key:        []string{"status", "tenant", "-effective_priority", "added"},
+		Unique:     false,
+		DropDups:   false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = c.EnsureIndex(idxQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateTasks(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recountTenants(session.DB(MongoDB))
+	if err != nil {
+		return nil, err
+	}
+
 	return session, nil
 }
 
+// recountTenants sets the running counter of every tenant from its requested
+// and running tasks, in case a manager stopped between a claim and its counter
+func recountTenants(db *mgo.Database) error {
+	var results []struct {
+		Tenant string `bson:"_id"`
+		Total  int    `bson:"total"`
+	}
+	err := db.C(MongoTasksCollection).Pipe([]bson.M{
+		{"$match": bson.M{"status": bson.M{"$in": []string{wttypes.TRANSCODING_REQUESTED, wttypes.TRANSCODING_RUNNING}}}},
+		{"$group": bson.M{"_id": "$tenant", "total": bson.M{"$sum": 1}}},
+	}).All(&results)
+	if err != nil {
+		return err
+	}
+
+	c := db.C(MongoTenantsCollection)
+
+	_, err = c.UpdateAll(nil, bson.M{"$set": bson.M{"running": 0}})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range results {
+		_, err = c.UpsertId(v.Tenant, bson.M{"$set": bson.M{"running": v.Total}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateTasks fills the fields the queue is looked up by in tasks added before
+// they existed, otherwise those tasks are never handed out
+func migrateTasks(c *mgo.Collection) error {
+	_, err := c.UpdateAll(bson.M{"tenant": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"tenant": wttypes.TENANT_DEFAULT},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.UpdateAll(bson.M{"priority": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"priority": wttypes.PRIORITY_DEFAULT},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The rest come from other fields of each task, as if set when it was added
+	for _, field := range []string{"effective_priority", "aged", "not_before"} {
+		var t TaskDB
+		iter := c.Find(bson.M{field: bson.M{"$exists": false}}).Select(bson.M{"priority": 1, "added": 1}).Iter()
+		for iter.Next(&t) {
+			var value interface{} = t.Added
+			if field == "effective_priority" {
+				value = t.Priority
+			}
+
+			err = c.UpdateId(t.ID, bson.M{"$set": bson.M{field: value}})
+			if err != nil && err != mgo.ErrNotFound {
+				iter.Close()
+				return err
+			}
+		}
+
+		err = iter.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (ds *DataStore) AddTask(task wttypes.TranscodingTask) (string, error) {
 	id := bson.NewObjectId()
+	now := time.Now()
 
 	t := TaskDB{
-		ID:            id,
-		TranscodingID: task.ID,
-		ObjectName:    task.ObjectName,
-		Profile:       task.Profile,
-		Status:        wttypes.TRANSCODING_QUEUED,
-		Added:         time.Now(),
+		ID:                id,
+		TranscodingID:     task.ID,
+		ObjectName:        task.ObjectName,
+		Profile:           task.Profile,
+		Renditions:        task.Renditions,
+		ABR:               task.ABR,
+		Kind:              task.Kind,
+		Thumbnails:        task.Thumbnails,
+		DependsOn:         task.DependsOn,
+		Tenant:            task.Tenant,
+		Priority:          task.Priority,
+		EffectivePriority: task.Priority,
+		Status:            wttypes.TRANSCODING_QUEUED,
+		Added:             now,
+		Aged:              now,
+		NotBefore:         now,
+	}
+
+	// Not queued until the tasks it depends on end
+	if len(task.DependsOn) > 0 {
+		t.Status = wttypes.TRANSCODING_WAITING
 	}
 
 	// Get "tasks" collection
@@ -152,84 +288,634 @@ func (ds *DataStore) GetTotalTasksRunning() (int, error) {
 	return len(results), nil
 }
 
-func (ds *DataStore) GetNextQueuedTask(workerAddr string) (wttypes.TranscodingTask, error) {
+// GetNextQueuedTask claims the next task for a worker, skipping the excluded profiles.
+// Tenants take turns according to the policy, and within a tenant higher priority goes first.
+func (ds *DataStore) GetNextQueuedTask(workerAddr string, policy TenantPolicy, excluded []string) (wttypes.TranscodingTask, error) {
 	// Get "tasks" collection
 	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
 
-	// Get next one with "queued" status
-	result := TaskDB{}
-	err := c.Find(bson.M{"status": wttypes.TRANSCODING_QUEUED}).Sort("added").One(&result)
+	// Tasks that can be handed out right now, per tenant
+	now := time.Now()
+	eligible := bson.M{
+		"status":     wttypes.TRANSCODING_QUEUED,
+		"not_before": bson.M{"$lte": now},
+	}
+	if len(excluded) > 0 {
+		// Ladders need the worker to handle every rendition
+		eligible["profile"] = bson.M{"$nin": excluded}
+		eligible["renditions"] = bson.M{"$nin": excluded}
+	}
+	queued, err := ds.countByTenant(eligible)
 	if err != nil {
 		return wttypes.TranscodingTask{}, err
 	}
 
-	// TODO: change to TRANSCODING_REQUESTED and add an extra ACK step
+	active, err := ds.countByTenant(bson.M{"status": bson.M{"$in": []string{wttypes.TRANSCODING_REQUESTED, wttypes.TRANSCODING_RUNNING}}})
+	if err != nil {
+		return wttypes.TranscodingTask{}, err
+	}
+
+	// Claim next one with "queued" status in a single findAndModify,
+	// so the same task can't be handed to two workers polling at once.
+	// Task stays "requested" until the worker ACKs it
+	change := mgo.Change{
+		Update: bson.M{"$set": bson.M{
+			"status":      wttypes.TRANSCODING_REQUESTED,
+			"worker_addr": workerAddr,
+			"requested":   now,
+		}},
+		ReturnNew: true,
+	}
+
+	for _, tenant := range policy.orderTenants(queued, active) {
+		// Counts above are a snapshot, the cap is enforced by taking a slot first
+		ok, err := ds.acquireTenantSlot(tenant, policy.Cap(tenant))
+		if err != nil {
+			return wttypes.TranscodingTask{}, err
+		}
+		if !ok {
+			continue
+		}
+
+		eligible["tenant"] = tenant
+
+		result := TaskDB{}
+		_, err = c.Find(eligible).Sort("-effective_priority", "added").Apply(change, &result)
+		if err != nil {
+			ds.releaseTenantSlot(tenant)
+		}
+
+		// Someone else took the last one of this tenant, try next tenant
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return wttypes.TranscodingTask{}, err
+		}
+
+		return wttypes.TranscodingTask{
+			ID:         result.TranscodingID,
+			ObjectName: result.ObjectName,
+			Profile:    result.Profile,
+			Priority:   result.Priority,
+			Tenant:     result.Tenant,
+			Renditions: result.Renditions,
+			ABR:        result.ABR,
+			Kind:       result.Kind,
+			Thumbnails: result.Thumbnails,
+			DependsOn:  result.DependsOn,
+		}, nil
+	}
+
+	return wttypes.TranscodingTask{}, mgo.ErrNotFound
+}
+
+// acquireTenantSlot adds one to the tasks the tenant has requested or running,
+// only if that doesn't go over its cap (0 is unlimited). Returns false when it would.
+func (ds *DataStore) acquireTenantSlot(tenant string, limit int) (bool, error) {
+	// Get "tenants" collection
+	c := ds.session.DB(MongoDB).C(MongoTenantsCollection)
 
-	// Update to "running"
-	result.Status = wttypes.TRANSCODING_RUNNING
-	result.WorkerAddr = workerAddr
-	result.Started = time.Now()
+	if limit <= 0 {
+		_, err := c.UpsertId(tenant, bson.M{"$inc": bson.M{"running": 1}})
+		return err == nil, err
+	}
 
-	_, err = c.UpsertId(result.ID, result)
+	// Counter must exist for the conditional $inc to match it
+	_, err := c.UpsertId(tenant, bson.M{"$setOnInsert": bson.M{"running": 0}})
 	if err != nil {
-		return wttypes.TranscodingTask{}, err
+		return false, err
+	}
+
+	err = c.Update(bson.M{
+		"_id":     tenant,
+		"running": bson.M{"$lt": limit},
+	}, bson.M{"$inc": bson.M{"running": 1}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// releaseTenantSlot gives back the slot of a tenant's task that is no longer
+// requested or running
+func (ds *DataStore) releaseTenantSlot(tenant string) {
+	// Get "tenants" collection
+	c := ds.session.DB(MongoDB).C(MongoTenantsCollection)
+
+	err := c.Update(bson.M{
+		"_id":     tenant,
+		"running": bson.M{"$gt": 0},
+	}, bson.M{"$inc": bson.M{"running": -1}})
+	if err != nil && err != mgo.ErrNotFound {
+		fmt.Println("[err] release tenant slot:", tenant, err)
+	}
+}
+
+// countByTenant returns how many tasks matching the query each tenant has
+func (ds *DataStore) countByTenant(query bson.M) (map[string]int, error) {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	var results []struct {
+		Tenant string `bson:"_id"`
+		Total  int    `bson:"total"`
+	}
+	err := c.Pipe([]bson.M{
+		{"$match": query},
+		{"$group": bson.M{"_id": "$tenant", "total": bson.M{"$sum": 1}}},
+	}).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]int)
+	for _, v := range results {
+		totals[v.Tenant] = v.Total
+	}
+
+	return totals, nil
+}
+
+// GetTenants returns the fair-share status of the tenants in the policy or with tasks
+func (ds *DataStore) GetTenants(policy TenantPolicy) ([]wttypes.TenantStatus, error) {
+	queued, err := ds.countByTenant(bson.M{"status": wttypes.TRANSCODING_QUEUED})
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := ds.countByTenant(bson.M{"status": bson.M{"$in": []string{wttypes.TRANSCODING_REQUESTED, wttypes.TRANSCODING_RUNNING}}})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, m := range []map[string]int{queued, active, policy.Weights, policy.Caps} {
+		for tenant := range m {
+			names[tenant] = true
+		}
+	}
+
+	tenants := []wttypes.TenantStatus{}
+	for tenant := range names {
+		tenants = append(tenants, wttypes.TenantStatus{
+			Name:    tenant,
+			Weight:  policy.Weight(tenant),
+			Cap:     policy.Cap(tenant),
+			Queued:  queued[tenant],
+			Running: active[tenant],
+		})
+	}
+
+	sort.Sort(byName(tenants))
+
+	return tenants, nil
+}
+
+// AgeQueuedTasks raises by one the effective priority of the tasks queued
+// for longer than interval, so low priority ones aren't starved forever
+func (ds *DataStore) AgeQueuedTasks(interval time.Duration) (int, error) {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	now := time.Now()
+	info, err := c.UpdateAll(bson.M{
+		"status":             wttypes.TRANSCODING_QUEUED,
+		"effective_priority": bson.M{"$lt": wttypes.PRIORITY_MAX},
+		"aged":               bson.M{"$lte": now.Add(-interval)},
+	}, bson.M{
+		"$inc": bson.M{"effective_priority": 1},
+		"$set": bson.M{"aged": now},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Updated, nil
+}
+
+func (ds *DataStore) AckTask(id string, workerAddr string, lease time.Duration) error {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	// Only the worker that requested the task can ACK it, and only
+	// while it's still "requested" (not re-queued or cancelled)
+	now := time.Now()
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"status":        wttypes.TRANSCODING_RUNNING,
+				"started":       now,
+				"lease_expires": now.Add(lease),
+			},
+			"$inc":  bson.M{"attempts": 1},
+			"$push": bson.M{"worker_history": workerAddr},
+		},
+	}
+
+	result := TaskDB{}
+	_, err := c.Find(bson.M{
+		"transcoding_id": id,
+		"worker_addr":    workerAddr,
+		"status":         wttypes.TRANSCODING_REQUESTED,
+	}).Apply(change, &result)
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrTaskNotRequested
+	}
+
+	return err
+}
+
+func (ds *DataStore) RenewLease(id string, workerAddr string, lease time.Duration) error {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	// Only renew if the worker still owns the running task
+	err := c.Update(bson.M{
+		"transcoding_id": id,
+		"worker_addr":    workerAddr,
+		"status":         wttypes.TRANSCODING_RUNNING,
+	}, bson.M{"$set": bson.M{
+		"lease_expires": time.Now().Add(lease),
+	}})
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrLeaseLost
 	}
 
-	return wttypes.TranscodingTask{
-		ID:         result.TranscodingID,
-		ObjectName: result.ObjectName,
-		Profile:    result.Profile,
-	}, nil
+	return err
 }
 
-func (ds *DataStore) UpdateTaskStatus(id string, status string) error {
-	fmt.Println("[database] UpdateTaskStatus:", id, status)
+// RetryTask re-queues a running task after a backoff, or marks it as error when it
+// already used maxAttempts. Returns the new status of the task.
+func (ds *DataStore) RetryTask(id string, workerAddr string, reason string, maxAttempts int, base time.Duration) (string, error) {
 	// Get "tasks" collection
 	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
 
+	// Only the worker running the task can ask for a retry
 	t := TaskDB{}
-	err := c.Find(bson.M{"transcoding_id": id}).One(&t)
+	err := c.Find(bson.M{
+		"transcoding_id": id,
+		"worker_addr":    workerAddr,
+		"status":         wttypes.TRANSCODING_RUNNING,
+	}).One(&t)
+	if err == mgo.ErrNotFound {
+		return "", wttypes.ErrLeaseLost
+	}
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"status":      wttypes.TRANSCODING_QUEUED,
+		"worker_addr": "",
+		"not_before":  now.Add(backoff(base, t.Attempts)),
+		"last_error":  reason,
+	}
+	if t.Attempts >= maxAttempts {
+		set = bson.M{
+			"status":     wttypes.TRANSCODING_ERROR,
+			"ended":      now,
+			"last_error": reason,
+		}
+	}
+
+	err = c.Update(bson.M{
+		"_id":         t.ID,
+		"worker_addr": workerAddr,
+		"status":      wttypes.TRANSCODING_RUNNING,
+	}, bson.M{"$set": set})
+	if err == mgo.ErrNotFound {
+		return "", wttypes.ErrLeaseLost
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ds.releaseTenantSlot(t.Tenant)
+
+	return set["status"].(string), nil
+}
+
+func (ds *DataStore) UpdateTaskProgress(id string, workerAddr string, progress wttypes.TranscodingProgress) error {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	// Only the worker running the task reports its progress
+	err := c.Update(bson.M{
+		"transcoding_id": id,
+		"worker_addr":    workerAddr,
+		"status":         wttypes.TRANSCODING_RUNNING,
+	}, bson.M{"$set": bson.M{
+		"progress": progress,
+	}})
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrLeaseLost
+	}
+
+	return err
+}
+
+// ReapExpiredLeases re-queues the running tasks whose lease expired, or marks them
+// as error when they already used maxAttempts. Returns the IDs of the failed ones.
+func (ds *DataStore) ReapExpiredLeases(maxAttempts int, base time.Duration) ([]string, error) {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	now := time.Now()
+	expired := bson.M{
+		"status":        wttypes.TRANSCODING_RUNNING,
+		"lease_expires": bson.M{"$lt": now},
+	}
+
+	var results []TaskDB
+	err := c.Find(expired).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	for _, t := range results {
+		update := bson.M{
+			"$set": bson.M{
+				"status":      wttypes.TRANSCODING_QUEUED,
+				"worker_addr": "",
+				"not_before":  now.Add(backoff(base, t.Attempts)),
+			},
+			"$push": bson.M{"dropped_by": t.WorkerAddr},
+		}
+		if t.Attempts >= maxAttempts {
+			update["$set"] = bson.M{
+				"status": wttypes.TRANSCODING_ERROR,
+				"ended":  now,
+			}
+		}
+
+		// Lease could have been renewed meanwhile, so match it again
+		err = c.Update(bson.M{
+			"_id":           t.ID,
+			"status":        wttypes.TRANSCODING_RUNNING,
+			"lease_expires": bson.M{"$lt": now},
+		}, update)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return failed, err
+		}
+
+		ds.releaseTenantSlot(t.Tenant)
+
+		fmt.Println("[manager] lease expired:", t.TranscodingID, t.WorkerAddr, t.Attempts)
+		if t.Attempts >= maxAttempts {
+			failed = append(failed, t.TranscodingID)
+		}
+	}
+
+	return failed, nil
+}
+
+// GetWaitingTasks returns the IDs of the tasks waiting for the given one to end
+func (ds *DataStore) GetWaitingTasks(id string) ([]string, error) {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	var results []struct {
+		TranscodingID string `bson:"transcoding_id"`
+	}
+	err := c.Find(bson.M{
+		"status":     wttypes.TRANSCODING_WAITING,
+		"depends_on": id,
+	}).Select(bson.M{"transcoding_id": 1}).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, v := range results {
+		ids = append(ids, v.TranscodingID)
+	}
+
+	return ids, nil
+}
+
+// ResolveDependencies moves on a waiting task once the tasks it depends on ended:
+// queued when all finished, skipped when all skipped, and like the first failed one otherwise.
+// Returns the new status, or "" while it keeps waiting.
+func (ds *DataStore) ResolveDependencies(id string) (string, error) {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	t := TaskDB{}
+	err := c.Find(bson.M{
+		"transcoding_id": id,
+		"status":         wttypes.TRANSCODING_WAITING,
+	}).One(&t)
+	if err == mgo.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var deps []struct {
+		Status string `bson:"status"`
+	}
+	err = c.Find(bson.M{"transcoding_id": bson.M{"$in": t.DependsOn}}).Select(bson.M{"status": 1}).All(&deps)
+	if err != nil {
+		return "", err
 	}
 
-	// Update Status
-	t.Status = status
-	if t.Status == wttypes.TRANSCODING_FINISHED {
-		t.Ended = time.Now()
+	statuses := []string{}
+	for _, v := range deps {
+		statuses = append(statuses, v.Status)
 	}
 
-	// Update in DB
-	_, err = c.UpsertId(t.ID, t)
+	status := dependenciesStatus(statuses, len(t.DependsOn))
+	if status == "" {
+		return "", nil
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"status": status,
+		"ended":  now,
+	}
+	if status == wttypes.TRANSCODING_QUEUED {
+		set = bson.M{
+			"status":     status,
+			"aged":       now,
+			"not_before": now,
+		}
+	}
+
+	// Another task ending at the same time could have resolved it already
+	err = c.Update(bson.M{
+		"_id":    t.ID,
+		"status": wttypes.TRANSCODING_WAITING,
+	}, bson.M{"$set": set})
+	if err == mgo.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
+
+func (ds *DataStore) RequeueExpiredRequests(deadline time.Duration) (int, error) {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	// Tasks requested before the deadline and never ACKed go back to the queue
+	expired := bson.M{
+		"status":    wttypes.TRANSCODING_REQUESTED,
+		"requested": bson.M{"$lt": time.Now().Add(-deadline)},
+	}
+
+	var results []TaskDB
+	err := c.Find(expired).Select(bson.M{"tenant": 1}).All(&results)
+	if err != nil {
+		return 0, err
+	}
+
+	// One by one, so only the ones we requeue release their tenant slot
+	total := 0
+	for _, t := range results {
+		expired["_id"] = t.ID
+
+		err = c.Update(expired, bson.M{"$set": bson.M{
+			"status":      wttypes.TRANSCODING_QUEUED,
+			"worker_addr": "",
+		}})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+
+		ds.releaseTenantSlot(t.Tenant)
+		total++
+	}
+
+	return total, nil
+}
+
+// dependenciesStatus returns the status of a task once the total tasks it depends on ended
+// (statuses of those found): queued when all finished, skipped when all skipped, and like
+// the first failed one otherwise. Returns "" while it keeps waiting.
+func dependenciesStatus(statuses []string, total int) string {
+	status := ""
+	finished, skipped := 0, 0
+	for _, v := range statuses {
+		switch v {
+		case wttypes.TRANSCODING_FINISHED:
+			finished++
+		case wttypes.TRANSCODING_SKIPPED:
+			skipped++
+		case wttypes.TRANSCODING_ERROR, wttypes.TRANSCODING_CANCELLED:
+			if status == "" {
+				status = v
+			}
+		}
+	}
+
+	switch {
+	case status != "":
+	case finished == total:
+		status = wttypes.TRANSCODING_QUEUED
+	case skipped == total:
+		status = wttypes.TRANSCODING_SKIPPED
+	case finished+skipped == total:
+		// Some of them only, nothing consistent to do with those
+		status = wttypes.TRANSCODING_ERROR
+	}
+
+	return status
+}
+
+// backoff returns the delay before retrying a task that already had some attempts:
+// base, 2*base, 4*base... up to MaxBackoff
+func backoff(base time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+
+	return d
+}
+
+// UpdateTaskStatus sets the status a worker reports for its running task. A worker
+// that lost the task (lease expired, handed to another one) can't change it anymore.
+func (ds *DataStore) UpdateTaskStatus(id string, workerAddr string, status string) error {
+	// Get "tasks" collection
+	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
+
+	set := bson.M{"status": status}
+	if status == wttypes.TRANSCODING_FINISHED || status == wttypes.TRANSCODING_SKIPPED {
+		set["ended"] = time.Now()
+	}
+
+	t := TaskDB{}
+	_, err := c.Find(bson.M{
+		"transcoding_id": id,
+		"worker_addr":    workerAddr,
+		"status":         wttypes.TRANSCODING_RUNNING,
+	}).Select(bson.M{"tenant": 1}).Apply(mgo.Change{Update: bson.M{"$set": set}}, &t)
+	if err == mgo.ErrNotFound {
+		return wttypes.ErrLeaseLost
+	}
 	if err != nil {
 		return err
 	}
 
+	if status != wttypes.TRANSCODING_RUNNING {
+		ds.releaseTenantSlot(t.Tenant)
+	}
+
 	fmt.Println("[manager] UpdateTaskStatus updated:", id, status)
 	return nil
 }
 
+// CancelTask cancels a task not running yet (a requested one will fail its ACK
+// afterwards). Returns the worker address when it's running, so it's cancelled there.
 func (ds *DataStore) CancelTask(id string) (string, error) {
 	fmt.Println("[database] CancelTask:", id)
 	// Get "tasks" collection
 	c := ds.session.DB(MongoDB).C(MongoTasksCollection)
 
+	condStatus := bson.M{"$in": []string{wttypes.TRANSCODING_QUEUED, wttypes.TRANSCODING_REQUESTED, wttypes.TRANSCODING_WAITING}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{
+		"status": wttypes.TRANSCODING_CANCELLED,
+	}}}
+
+	// Previous status tells if it had a tenant slot
 	t := TaskDB{}
-	err := c.Find(bson.M{"transcoding_id": id}).One(&t)
-	if err != nil {
+	_, err := c.Find(bson.M{"transcoding_id": id, "status": condStatus}).Select(bson.M{"status": 1, "tenant": 1}).Apply(change, &t)
+	if err == nil && t.Status == wttypes.TRANSCODING_REQUESTED {
+		ds.releaseTenantSlot(t.Tenant)
+	}
+	if err != mgo.ErrNotFound {
 		return "", err
 	}
 
-	// Let's cancel on DB
-	if t.Status == wttypes.TRANSCODING_QUEUED {
-		t.Status = wttypes.TRANSCODING_CANCELLED
+	// Already running or ended
+	err = c.Find(bson.M{"transcoding_id": id}).Select(bson.M{"status": 1, "worker_addr": 1}).One(&t)
+	if err != nil {
+		return "", err
+	}
 
-		// Update in DB
-		_, err = c.UpsertId(t.ID, t)
-		if err != nil {
-			return "", err
-		}
-	} else if t.Status == wttypes.TRANSCODING_RUNNING {
+	if t.Status == wttypes.TRANSCODING_RUNNING {
 		return t.WorkerAddr, nil
 	}
 
@@ -1,6 +1,8 @@
 package manager
 
 import (
+	"time"
+
 	"github.com/go-kit/kit/endpoint"
 	"golang.org/x/net/context"
 
@@ -10,9 +12,7 @@ import (
 // AddTranscoding
 
 type addTranscodingRequest struct {
-	ID         string
-	ObjectName string
-	Profile    string
+	Task wttypes.TranscodingTask
 }
 
 type addTranscodingResponse struct {
@@ -24,7 +24,7 @@ func (r addTranscodingResponse) error() error { return r.Err }
 func makeAddTranscodingEndpoint(tms Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(addTranscodingRequest)
-		err := tms.AddTranscoding(req.ID, req.ObjectName, req.Profile)
+		err := tms.AddTranscoding(req.Task)
 		return addTranscodingResponse{Err: err}, nil
 	}
 }
@@ -70,7 +70,9 @@ func makeGetTotalTasksRunningEndpoint(tms Service) endpoint.Endpoint {
 // GetNextTask
 
 type getNextTaskRequest struct {
-	WorkerAddr string
+	WorkerAddr   string
+	Capabilities *wttypes.WorkerCapabilities
+	Wait         time.Duration
 }
 
 type getNextTaskResponse struct {
@@ -83,16 +85,125 @@ func (r getNextTaskResponse) error() error { return r.Err }
 func makeGetNextTaskEndpoint(tms Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(getNextTaskRequest)
-		task, err := tms.GetNextTask(req.WorkerAddr)
+		task, err := tms.GetNextTask(req.WorkerAddr, req.Capabilities, req.Wait)
 		return getNextTaskResponse{Task: task, Err: err}, nil
 	}
 }
 
+// GetTenants
+
+type getTenantsRequest struct {
+}
+
+type getTenantsResponse struct {
+	Tenants []wttypes.TenantStatus `json:"tenants"`
+	Err     error                  `json:"error,omitempty"`
+}
+
+func (r getTenantsResponse) error() error { return r.Err }
+
+func makeGetTenantsEndpoint(tms Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		tenants, err := tms.GetTenants()
+		return getTenantsResponse{Tenants: tenants, Err: err}, nil
+	}
+}
+
+// AckTask
+
+type ackTaskRequest struct {
+	ID         string
+	WorkerAddr string
+}
+
+type ackTaskResponse struct {
+	// Lease of the task in seconds, the worker has to renew it before
+	Lease float64 `json:"lease,omitempty"`
+	Err   error   `json:"error,omitempty"`
+}
+
+func (r ackTaskResponse) error() error { return r.Err }
+
+func makeAckTaskEndpoint(tms Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ackTaskRequest)
+		lease, err := tms.AckTask(req.ID, req.WorkerAddr)
+		return ackTaskResponse{Lease: lease.Seconds(), Err: err}, nil
+	}
+}
+
+// RenewLease
+
+type renewLeaseRequest struct {
+	ID         string
+	WorkerAddr string
+}
+
+type renewLeaseResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r renewLeaseResponse) error() error { return r.Err }
+
+func makeRenewLeaseEndpoint(tms Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(renewLeaseRequest)
+		err := tms.RenewLease(req.ID, req.WorkerAddr)
+		return renewLeaseResponse{Err: err}, nil
+	}
+}
+
+// UpdateTaskProgress
+
+type updateTaskProgressRequest struct {
+	ID         string
+	WorkerAddr string
+	Progress   wttypes.TranscodingProgress
+}
+
+type updateTaskProgressResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r updateTaskProgressResponse) error() error { return r.Err }
+
+func makeUpdateTaskProgressEndpoint(tms Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateTaskProgressRequest)
+		err := tms.UpdateTaskProgress(req.ID, req.WorkerAddr, req.Progress)
+		return updateTaskProgressResponse{Err: err}, nil
+	}
+}
+
+// RetryTask
+
+type retryTaskRequest struct {
+	ID         string
+	WorkerAddr string
+	Reason     string
+}
+
+type retryTaskResponse struct {
+	Status string `json:"status,omitempty"`
+	Err    error  `json:"error,omitempty"`
+}
+
+func (r retryTaskResponse) error() error { return r.Err }
+
+func makeRetryTaskEndpoint(tms Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(retryTaskRequest)
+		status, err := tms.RetryTask(req.ID, req.WorkerAddr, req.Reason)
+		return retryTaskResponse{Status: status, Err: err}, nil
+	}
+}
+
 // UpdateTaskStatus
 
 type updateTaskStatusRequest struct {
-	ID     string
-	Status string
+	ID         string
+	WorkerAddr string
+	Status     string
 }
 
 type updateTaskStatusResponse struct {
@@ -104,7 +215,7 @@ func (r updateTaskStatusResponse) error() error { return r.Err }
 func makeUpdateTaskStatusEndpoint(js Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(updateTaskStatusRequest)
-		err := js.UpdateTaskStatus(req.ID, req.Status)
+		err := js.UpdateTaskStatus(req.ID, req.WorkerAddr, req.Status)
 		return updateTaskStatusResponse{Err: err}, nil
 	}
 }
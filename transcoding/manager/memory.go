@@ -0,0 +1,460 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// MemoryStore keeps the tasks in memory, for a single manager without MongoDB.
+// Every method holds the lock, so claims are atomic like findAndModify.
+type MemoryStore struct {
+	mtx   sync.Mutex
+	tasks []*TaskDB
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (ms *MemoryStore) Close() {}
+
+// find returns the task of the transcoding, nil if there isn't one
+func (ms *MemoryStore) find(id string) *TaskDB {
+	for _, t := range ms.tasks {
+		if t.TranscodingID == id {
+			return t
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) AddTask(task wttypes.TranscodingTask) (string, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	now := time.Now()
+	t := &TaskDB{
+		ID:                bson.NewObjectId(),
+		TranscodingID:     task.ID,
+		ObjectName:        task.ObjectName,
+		Profile:           task.Profile,
+		Renditions:        task.Renditions,
+		ABR:               task.ABR,
+		Kind:              task.Kind,
+		Thumbnails:        task.Thumbnails,
+		DependsOn:         task.DependsOn,
+		Tenant:            task.Tenant,
+		Priority:          task.Priority,
+		EffectivePriority: task.Priority,
+		Status:            wttypes.TRANSCODING_QUEUED,
+		Added:             now,
+		Aged:              now,
+		NotBefore:         now,
+	}
+
+	// Not queued until the tasks it depends on end
+	if len(task.DependsOn) > 0 {
+		t.Status = wttypes.TRANSCODING_WAITING
+	}
+
+	ms.tasks = append(ms.tasks, t)
+
+	return task.ID, nil
+}
+
+func (ms *MemoryStore) count(status ...string) int {
+	total := 0
+	for _, t := range ms.tasks {
+		if strInSlice(t.Status, status) {
+			total++
+		}
+	}
+
+	return total
+}
+
+func (ms *MemoryStore) GetTotalTasksQueued() (int, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	return ms.count(wttypes.TRANSCODING_QUEUED), nil
+}
+
+func (ms *MemoryStore) GetTotalTasksRunning() (int, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	return ms.count(wttypes.TRANSCODING_RUNNING), nil
+}
+
+// eligible tells if the task can be handed out now to a worker without the excluded profiles
+func (t *TaskDB) eligible(now time.Time, excluded []string) bool {
+	if t.Status != wttypes.TRANSCODING_QUEUED || t.NotBefore.After(now) {
+		return false
+	}
+
+	// Ladders need the worker to handle every rendition
+	if strInSlice(t.Profile, excluded) {
+		return false
+	}
+	for _, v := range t.Renditions {
+		if strInSlice(v, excluded) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// countByTenant returns how many tasks each tenant has for which match is true
+func (ms *MemoryStore) countByTenant(match func(t *TaskDB) bool) map[string]int {
+	totals := make(map[string]int)
+	for _, t := range ms.tasks {
+		if match(t) {
+			totals[t.Tenant]++
+		}
+	}
+
+	return totals
+}
+
+func active(t *TaskDB) bool {
+	return t.Status == wttypes.TRANSCODING_REQUESTED || t.Status == wttypes.TRANSCODING_RUNNING
+}
+
+// GetNextQueuedTask works like DataStore's. Counts and claim happen under the
+// same lock, so tenant caps can't be overshot.
+func (ms *MemoryStore) GetNextQueuedTask(workerAddr string, policy TenantPolicy, excluded []string) (wttypes.TranscodingTask, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	now := time.Now()
+	queued := ms.countByTenant(func(t *TaskDB) bool { return t.eligible(now, excluded) })
+	running := ms.countByTenant(active)
+
+	for _, tenant := range policy.orderTenants(queued, running) {
+		if task, ok := ms.claim(workerAddr, tenant, now, excluded); ok {
+			return task, nil
+		}
+	}
+
+	return wttypes.TranscodingTask{}, mgo.ErrNotFound
+}
+
+// claim hands the next eligible task of the tenant to the worker, higher priority first.
+// Lock must be held.
+func (ms *MemoryStore) claim(workerAddr string, tenant string, now time.Time, excluded []string) (wttypes.TranscodingTask, bool) {
+	var next *TaskDB
+	for _, t := range ms.tasks {
+		if t.Tenant != tenant || !t.eligible(now, excluded) {
+			continue
+		}
+
+		if next == nil || t.EffectivePriority > next.EffectivePriority ||
+			(t.EffectivePriority == next.EffectivePriority && t.Added.Before(next.Added)) {
+			next = t
+		}
+	}
+
+	// No eligible task left for this tenant
+	if next == nil {
+		return wttypes.TranscodingTask{}, false
+	}
+
+	// Task stays "requested" until the worker ACKs it
+	next.Status = wttypes.TRANSCODING_REQUESTED
+	next.WorkerAddr = workerAddr
+	next.Requested = now
+
+	return wttypes.TranscodingTask{
+		ID:         next.TranscodingID,
+		ObjectName: next.ObjectName,
+		Profile:    next.Profile,
+		Priority:   next.Priority,
+		Tenant:     next.Tenant,
+		Renditions: next.Renditions,
+		ABR:        next.ABR,
+		Kind:       next.Kind,
+		Thumbnails: next.Thumbnails,
+		DependsOn:  next.DependsOn,
+	}, true
+}
+
+func (ms *MemoryStore) GetTenants(policy TenantPolicy) ([]wttypes.TenantStatus, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	queued := ms.countByTenant(func(t *TaskDB) bool { return t.Status == wttypes.TRANSCODING_QUEUED })
+	running := ms.countByTenant(active)
+
+	names := make(map[string]bool)
+	for _, m := range []map[string]int{queued, running, policy.Weights, policy.Caps} {
+		for tenant := range m {
+			names[tenant] = true
+		}
+	}
+
+	tenants := []wttypes.TenantStatus{}
+	for tenant := range names {
+		tenants = append(tenants, wttypes.TenantStatus{
+			Name:    tenant,
+			Weight:  policy.Weight(tenant),
+			Cap:     policy.Cap(tenant),
+			Queued:  queued[tenant],
+			Running: running[tenant],
+		})
+	}
+
+	sort.Sort(byName(tenants))
+
+	return tenants, nil
+}
+
+func (ms *MemoryStore) AgeQueuedTasks(interval time.Duration) (int, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	now := time.Now()
+	total := 0
+	for _, t := range ms.tasks {
+		if t.Status == wttypes.TRANSCODING_QUEUED && t.EffectivePriority < wttypes.PRIORITY_MAX && !t.Aged.After(now.Add(-interval)) {
+			t.EffectivePriority++
+			t.Aged = now
+			total++
+		}
+	}
+
+	return total, nil
+}
+
+func (ms *MemoryStore) AckTask(id string, workerAddr string, lease time.Duration) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	// Only the worker that requested the task can ACK it, and only
+	// while it's still "requested" (not re-queued or cancelled)
+	t := ms.find(id)
+	if t == nil || t.WorkerAddr != workerAddr || t.Status != wttypes.TRANSCODING_REQUESTED {
+		return wttypes.ErrTaskNotRequested
+	}
+
+	now := time.Now()
+	t.Status = wttypes.TRANSCODING_RUNNING
+	t.Started = now
+	t.LeaseExpires = now.Add(lease)
+	t.Attempts++
+	t.WorkerHistory = append(t.WorkerHistory, workerAddr)
+
+	return nil
+}
+
+// running returns the task when the worker still owns it running
+func (ms *MemoryStore) running(id string, workerAddr string) *TaskDB {
+	t := ms.find(id)
+	if t == nil || t.WorkerAddr != workerAddr || t.Status != wttypes.TRANSCODING_RUNNING {
+		return nil
+	}
+
+	return t
+}
+
+func (ms *MemoryStore) RenewLease(id string, workerAddr string, lease time.Duration) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	t := ms.running(id, workerAddr)
+	if t == nil {
+		return wttypes.ErrLeaseLost
+	}
+
+	t.LeaseExpires = time.Now().Add(lease)
+
+	return nil
+}
+
+func (ms *MemoryStore) RetryTask(id string, workerAddr string, reason string, maxAttempts int, base time.Duration) (string, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	t := ms.running(id, workerAddr)
+	if t == nil {
+		return "", wttypes.ErrLeaseLost
+	}
+
+	now := time.Now()
+	t.LastError = reason
+	if t.Attempts >= maxAttempts {
+		t.Status = wttypes.TRANSCODING_ERROR
+		t.Ended = now
+	} else {
+		t.Status = wttypes.TRANSCODING_QUEUED
+		t.WorkerAddr = ""
+		t.NotBefore = now.Add(backoff(base, t.Attempts))
+	}
+
+	return t.Status, nil
+}
+
+func (ms *MemoryStore) UpdateTaskProgress(id string, workerAddr string, progress wttypes.TranscodingProgress) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	t := ms.running(id, workerAddr)
+	if t == nil {
+		return wttypes.ErrLeaseLost
+	}
+
+	t.Progress = &progress
+
+	return nil
+}
+
+func (ms *MemoryStore) ReapExpiredLeases(maxAttempts int, base time.Duration) ([]string, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	now := time.Now()
+	failed := []string{}
+	for _, t := range ms.tasks {
+		if t.Status != wttypes.TRANSCODING_RUNNING || !t.LeaseExpires.Before(now) {
+			continue
+		}
+
+		fmt.Println("[manager] lease expired:", t.TranscodingID, t.WorkerAddr, t.Attempts)
+
+		if t.Attempts >= maxAttempts {
+			t.Status = wttypes.TRANSCODING_ERROR
+			t.Ended = now
+			failed = append(failed, t.TranscodingID)
+			continue
+		}
+
+		t.Status = wttypes.TRANSCODING_QUEUED
+		t.DroppedBy = append(t.DroppedBy, t.WorkerAddr)
+		t.WorkerAddr = ""
+		t.NotBefore = now.Add(backoff(base, t.Attempts))
+	}
+
+	return failed, nil
+}
+
+func (ms *MemoryStore) GetWaitingTasks(id string) ([]string, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ids := []string{}
+	for _, t := range ms.tasks {
+		if t.Status == wttypes.TRANSCODING_WAITING && strInSlice(id, t.DependsOn) {
+			ids = append(ids, t.TranscodingID)
+		}
+	}
+
+	return ids, nil
+}
+
+func (ms *MemoryStore) ResolveDependencies(id string) (string, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	t := ms.find(id)
+	if t == nil || t.Status != wttypes.TRANSCODING_WAITING {
+		return "", nil
+	}
+
+	statuses := []string{}
+	for _, v := range t.DependsOn {
+		if dep := ms.find(v); dep != nil {
+			statuses = append(statuses, dep.Status)
+		}
+	}
+
+	status := dependenciesStatus(statuses, len(t.DependsOn))
+	if status == "" {
+		return "", nil
+	}
+
+	now := time.Now()
+	t.Status = status
+	if status == wttypes.TRANSCODING_QUEUED {
+		t.Aged = now
+		t.NotBefore = now
+	} else {
+		t.Ended = now
+	}
+
+	return status, nil
+}
+
+func (ms *MemoryStore) RequeueExpiredRequests(deadline time.Duration) (int, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	// Tasks requested before the deadline and never ACKed go back to the queue
+	limit := time.Now().Add(-deadline)
+	total := 0
+	for _, t := range ms.tasks {
+		if t.Status == wttypes.TRANSCODING_REQUESTED && t.Requested.Before(limit) {
+			t.Status = wttypes.TRANSCODING_QUEUED
+			t.WorkerAddr = ""
+			total++
+		}
+	}
+
+	return total, nil
+}
+
+func (ms *MemoryStore) UpdateTaskStatus(id string, workerAddr string, status string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	t := ms.running(id, workerAddr)
+	if t == nil {
+		return wttypes.ErrLeaseLost
+	}
+
+	t.Status = status
+	if status == wttypes.TRANSCODING_FINISHED || status == wttypes.TRANSCODING_SKIPPED {
+		t.Ended = time.Now()
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) CancelTask(id string) (string, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	t := ms.find(id)
+	if t == nil {
+		return "", mgo.ErrNotFound
+	}
+
+	// A requested task will fail its ACK afterwards
+	switch t.Status {
+	case wttypes.TRANSCODING_QUEUED, wttypes.TRANSCODING_REQUESTED, wttypes.TRANSCODING_WAITING:
+		t.Status = wttypes.TRANSCODING_CANCELLED
+	case wttypes.TRANSCODING_RUNNING:
+		return t.WorkerAddr, nil
+	}
+
+	return "", nil
+}
+
+func strInSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
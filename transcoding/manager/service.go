@@ -1,21 +1,35 @@
 package manager
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
-	"strings"
+	"time"
 
-	"github.com/go-resty/resty"
+	"golang.org/x/net/context"
 	"gopkg.in/mgo.v2"
 
+	dbclient "github.com/obazavil/openstack-workload-transcoding/database/client"
+	workerclient "github.com/obazavil/openstack-workload-transcoding/transcoding/worker/client"
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
+const (
+	// Max time a worker can wait for a task in GetNextTask
+	MaxWait = 60 * time.Second
+
+	// How often waiting workers look again for tasks (backoffs expire without notice)
+	RecheckInterval = 5 * time.Second
+
+	// Shortest lease of running tasks, workers renew it every third of it
+	MinLease = 3 * time.Second
+)
+
 // Service is the interface that provides transcoding manager methods.
 type Service interface {
-	// Add a new transcoding task
-	AddTranscoding(id string, objectname string, profile string) error
+	// Add a new task (a single profile, the renditions of an ABR ladder, thumbnails, or a chunk and its stitch)
+	AddTranscoding(task wttypes.TranscodingTask) error
 
 	// Cancel a transcoding task
 	CancelTranscoding(id string) error
@@ -26,26 +40,75 @@ type Service interface {
 	// Get total of active tasks
 	GetTotalTasksRunning() (int, error)
 
-	// Get next Transcoding task
-	GetNextTask(workerAddr string) (wttypes.TranscodingTask, error)
+	// Get next Transcoding task the worker is capable of, waiting up to wait for one to be queued
+	GetNextTask(workerAddr string, caps *wttypes.WorkerCapabilities, wait time.Duration) (wttypes.TranscodingTask, error)
+
+	// Get fair-share status of the tenants
+	GetTenants() ([]wttypes.TenantStatus, error)
+
+	// Acknowledge a task handed out by GetNextTask, returns the lease the worker has to renew
+	AckTask(id string, workerAddr string) (time.Duration, error)
+
+	// Renew the lease of a running task
+	RenewLease(id string, workerAddr string) error
+
+	// Update how far a running task is
+	UpdateTaskProgress(id string, workerAddr string, progress wttypes.TranscodingProgress) error
+
+	// Re-queue a task that failed with a transient error, returns the new status
+	RetryTask(id string, workerAddr string, reason string) (string, error)
 
 	// Update the status of a task
-	UpdateTaskStatus(id string, status string) error
+	UpdateTaskStatus(id string, workerAddr string, status string) error
+
+	// No Endpoints (REST API) api for below functions
+
+	// Put back in queue the tasks not acknowledged before the deadline
+	RequeueUnacknowledgedTasks() (int, error)
+
+	// Re-queue (or fail) running tasks whose worker stopped renewing the lease
+	ReapExpiredLeases() error
+
+	// Raise the priority of the tasks waiting for too long in queue
+	AgeQueuedTasks() (int, error)
 }
 
 type service struct {
-	session *mgo.Session
+	store       func() Store
+	ackDeadline time.Duration
+	lease       time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	aging       time.Duration
+	policy      TenantPolicy
+	queued      *queueSignal
+	profiles    *wtcommon.ProfileCache
+
+	database *dbclient.Client
 }
 
-func (s *service) AddTranscoding(id string, objectname string, profile string) error {
-	// Add task
-	task := wttypes.TranscodingTask{
-		ID:         id,
-		ObjectName: objectname,
-		Profile:    profile,
+func (s *service) AddTranscoding(task wttypes.TranscodingTask) error {
+	// No priority means default one
+	if task.Priority == 0 {
+		task.Priority = wttypes.PRIORITY_DEFAULT
+	}
+
+	// Same for tenant
+	if task.Tenant == "" {
+		task.Tenant = wttypes.TENANT_DEFAULT
 	}
 
-	datastore := NewDataStore(s.session)
+	// A new profile is fetched now, so the workers that can't handle it are
+	// known when handing it out
+	if task.Profile != "" {
+		_, err := s.profiles.Get(task.Profile)
+		if err != nil {
+			fmt.Println("[err] get profile:", task.Profile, err)
+		}
+	}
+
+	// Add task
+	datastore := s.store()
 	defer datastore.Close()
 
 	id, err := datastore.AddTask(task)
@@ -53,13 +116,21 @@ func (s *service) AddTranscoding(id string, objectname string, profile string) e
 		return err
 	}
 
-	fmt.Println("[manager] added transcoding:", id, profile, objectname)
+	fmt.Println("[manager] added transcoding:", id, task.Kind, task.Profile, task.Renditions, task.ObjectName, task.Priority, task.Tenant)
+
+	// Tasks it depends on could have ended already
+	if len(task.DependsOn) > 0 {
+		s.resolveDependencies(datastore, id)
+		return nil
+	}
+
+	s.queued.broadcast()
 
 	return nil
 }
 
 func (s *service) GetTotalTasksQueued() (int, error) {
-	datastore := NewDataStore(s.session)
+	datastore := s.store()
 	defer datastore.Close()
 
 	total, err := datastore.GetTotalTasksQueued()
@@ -68,7 +139,7 @@ func (s *service) GetTotalTasksQueued() (int, error) {
 }
 
 func (s *service) GetTotalTasksRunning() (int, error) {
-	datastore := NewDataStore(s.session)
+	datastore := s.store()
 	defer datastore.Close()
 
 	total, err := datastore.GetTotalTasksRunning()
@@ -76,27 +147,125 @@ func (s *service) GetTotalTasksRunning() (int, error) {
 	return total, err
 }
 
-func (s *service) GetNextTask(workerAddr string) (wttypes.TranscodingTask, error) {
-	datastore := NewDataStore(s.session)
+func (s *service) GetNextTask(workerAddr string, caps *wttypes.WorkerCapabilities, wait time.Duration) (wttypes.TranscodingTask, error) {
+	// Profiles this worker can't handle (no capabilities means it can do all)
+	excluded := []string{}
+	if caps != nil {
+		profiles, err := s.profiles.List()
+		if err != nil {
+			return wttypes.TranscodingTask{}, err
+		}
+
+		for name, p := range profiles {
+			if !caps.Satisfies(p.Requirements()) {
+				excluded = append(excluded, name)
+			}
+		}
+	}
+
+	if wait > MaxWait {
+		wait = MaxWait
+	}
+	deadline := time.Now().Add(wait)
+
+	datastore := s.store()
+	defer datastore.Close()
+
+	for {
+		// Get the signal before looking, so we don't miss tasks queued meanwhile
+		queued := s.queued.wait()
+
+		task, err := datastore.GetNextQueuedTask(workerAddr, s.policy, excluded)
+		if err != mgo.ErrNotFound {
+			return task, err
+		}
+
+		// Nothing for us, wait for new tasks (or backoffs expiring) until deadline
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return wttypes.TranscodingTask{}, err
+		}
+		if remaining > RecheckInterval {
+			remaining = RecheckInterval
+		}
+
+		select {
+		case <-queued:
+		case <-time.After(remaining):
+		}
+	}
+}
+
+func (s *service) GetTenants() ([]wttypes.TenantStatus, error) {
+	datastore := s.store()
+	defer datastore.Close()
+
+	tenants, err := datastore.GetTenants(s.policy)
+
+	return tenants, err
+}
+
+func (s *service) AckTask(id string, workerAddr string) (time.Duration, error) {
+	datastore := s.store()
+	defer datastore.Close()
+
+	err := datastore.AckTask(id, workerAddr, s.lease)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Println("[manager] task acknowledged:", id, workerAddr)
+
+	return s.lease, nil
+}
+
+func (s *service) RenewLease(id string, workerAddr string) error {
+	datastore := s.store()
+	defer datastore.Close()
+
+	err := datastore.RenewLease(id, workerAddr, s.lease)
+
+	return err
+}
+
+func (s *service) UpdateTaskProgress(id string, workerAddr string, progress wttypes.TranscodingProgress) error {
+	datastore := s.store()
 	defer datastore.Close()
 
-	task, err := datastore.GetNextQueuedTask(workerAddr)
+	err := datastore.UpdateTaskProgress(id, workerAddr, progress)
+
+	return err
+}
+
+func (s *service) RetryTask(id string, workerAddr string, reason string) (string, error) {
+	datastore := s.store()
+	defer datastore.Close()
+
+	status, err := datastore.RetryTask(id, workerAddr, reason, s.maxAttempts, s.backoff)
 	if err != nil {
-		return wttypes.TranscodingTask{}, err
+		return "", err
+	}
+
+	fmt.Println("[manager] retry task:", id, status, reason)
+
+	if status == wttypes.TRANSCODING_ERROR {
+		s.releaseWaitingTasks(datastore, id)
 	}
 
-	return task, err
+	return status, nil
 }
 
-func (s *service) UpdateTaskStatus(id string, status string) error {
-	datastore := NewDataStore(s.session)
+func (s *service) UpdateTaskStatus(id string, workerAddr string, status string) error {
+	datastore := s.store()
 	defer datastore.Close()
 
-	err := datastore.UpdateTaskStatus(id, status)
+	err := datastore.UpdateTaskStatus(id, workerAddr, status)
 	if err != nil {
 		return err
 	}
 
+	s.releaseWaitingTasks(datastore, id)
+
 	//// Update Job Service
 	//body := struct {
 	//	Status string `json:"status"`
@@ -124,7 +293,7 @@ func (s *service) UpdateTaskStatus(id string, status string) error {
 
 func (s *service) CancelTranscoding(id string) error {
 	fmt.Println("received cancel request for:", id)
-	datastore := NewDataStore(s.session)
+	datastore := s.store()
 	defer datastore.Close()
 
 	addr, err := datastore.CancelTask(id)
@@ -132,36 +301,146 @@ func (s *service) CancelTranscoding(id string) error {
 		return err
 	}
 
+	// Cancelled before running, the worker won't report it
+	if addr == "" {
+		s.releaseWaitingTasks(datastore, id)
+	}
+
 	// If addr is not "", let's ask worker to cancel
 	if addr != "" {
 		fmt.Println("asking worker for cancellation:", addr)
-		fmt.Println("url:", addr+":8083/tasks")
-		resp, err := resty.R().
-			Delete(addr + ":8083/tasks")
-
+		err := workerclient.NewFromIP(addr, wtclient.TIMEOUT).CancelTask(context.Background(), id)
 		if err != nil {
 			//TODO: do something when cancel fails
+			fmt.Println("[err] cancel in worker:", err)
 		}
+	}
 
-		str := resp.String()
-		if strings.HasPrefix(str, `{"error"`) {
-			//TODO: do something when cancel fails
+	return nil
+}
+
+// No Endpoints (REST API) api for below functions
+
+func (s *service) RequeueUnacknowledgedTasks() (int, error) {
+	datastore := s.store()
+	defer datastore.Close()
+
+	total, err := datastore.RequeueExpiredRequests(s.ackDeadline)
+	if err != nil {
+		return 0, err
+	}
+
+	if total > 0 {
+		fmt.Println("[manager] re-queued unacknowledged tasks:", total)
+		s.queued.broadcast()
+	}
+
+	return total, nil
+}
+
+func (s *service) ReapExpiredLeases() error {
+	datastore := s.store()
+	defer datastore.Close()
+
+	failed, err := datastore.ReapExpiredLeases(s.maxAttempts, s.backoff)
+	s.queued.broadcast()
+
+	// Let database know about the ones we gave up on (even if reaping failed halfway)
+	for _, id := range failed {
+		fmt.Println("[manager] task failed after max attempts:", id)
+		errN := s.notifyTranscodingStatus(id, wttypes.TRANSCODING_ERROR)
+		if errN != nil {
+			fmt.Println("[err] notifyTranscodingStatus:", errN)
+		}
+
+		s.releaseWaitingTasks(datastore, id)
+	}
+
+	return err
+}
+
+func (s *service) AgeQueuedTasks() (int, error) {
+	datastore := s.store()
+	defer datastore.Close()
+
+	total, err := datastore.AgeQueuedTasks(s.aging)
+
+	return total, err
+}
+
+// releaseWaitingTasks resolves the dependencies of the tasks waiting for the given one
+func (s *service) releaseWaitingTasks(datastore Store, id string) {
+	ids, err := datastore.GetWaitingTasks(id)
+	if err != nil {
+		fmt.Println("[err] GetWaitingTasks:", err)
+		return
+	}
+
+	for _, v := range ids {
+		s.resolveDependencies(datastore, v)
+	}
+}
+
+// resolveDependencies queues a waiting task once its dependencies ended,
+// letting database know when it won't run at all
+func (s *service) resolveDependencies(datastore Store, id string) {
+	status, err := datastore.ResolveDependencies(id)
+	if err != nil {
+		fmt.Println("[err] ResolveDependencies:", err)
+		return
+	}
+
+	switch status {
+	case "":
+		// Still waiting
+	case wttypes.TRANSCODING_QUEUED:
+		fmt.Println("[manager] dependencies ended, task queued:", id)
+		s.queued.broadcast()
+	default:
+		fmt.Println("[manager] dependencies ended, task won't run:", id, status)
+		errN := s.notifyTranscodingStatus(id, status)
+		if errN != nil {
+			fmt.Println("[err] notifyTranscodingStatus:", errN)
 		}
 	}
+}
 
-	return nil
+// notifyTranscodingStatus updates the transcoding status in database service
+func (s *service) notifyTranscodingStatus(id string, status string) error {
+	ctx := context.Background()
+
+	// Ask DB to get transcoding from DB
+	t, err := s.database.GetTranscoding(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Update DB
+	t.Status = status
+
+	return s.database.UpdateTranscoding(ctx, t)
 }
 
 // NewService creates a transcoding manager service with necessary dependencies.
-func NewService() (Service, error) {
-	resty.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-
+func NewService(database string, ackDeadline, lease time.Duration, maxAttempts int, backoff, aging time.Duration, policy TenantPolicy) (Service, error) {
 	s, err := CreateMongoSession()
 	if err != nil {
 		return &service{}, errors.New("[MongoDB] " + err.Error())
 	}
 
-	return &service{
-		session: s,
-	}, nil
+	tms := &service{
+		store:       func() Store { return NewDataStore(s) },
+		ackDeadline: ackDeadline,
+		lease:       lease,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		aging:       aging,
+		policy:      policy,
+		queued:      newQueueSignal(),
+		profiles:    wtcommon.NewProfileCache(database, wtcommon.PROFILE_CACHE_TTL),
+
+		database: dbclient.New(database, wtclient.TIMEOUT),
+	}
+
+	return tms, nil
 }
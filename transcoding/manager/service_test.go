@@ -0,0 +1,187 @@
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// newTestService creates a service on an in-memory store
+func newTestService(policy TenantPolicy) (*service, *MemoryStore) {
+	ms := NewMemoryStore()
+
+	return &service{
+		store:    func() Store { return ms },
+		policy:   policy,
+		queued:   newQueueSignal(),
+		profiles: wtcommon.NewProfileCache("", wtcommon.PROFILE_CACHE_TTL),
+	}, ms
+}
+
+func TestGetNextTaskConcurrent(t *testing.T) {
+	const (
+		tasks   = 500
+		workers = 50
+	)
+
+	s, _ := newTestService(TenantPolicy{})
+
+	for i := 0; i < tasks; i++ {
+		err := s.AddTranscoding(wttypes.TranscodingTask{
+			ID:       fmt.Sprintf("task%d", i),
+			Profile:  "iPhone5s",
+			Tenant:   fmt.Sprintf("tenant%d", i%3),
+			Priority: i%wttypes.PRIORITY_MAX + 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mtx sync.Mutex
+	handed := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			for {
+				task, err := s.GetNextTask(addr, nil, 0)
+				if err == mgo.ErrNotFound {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mtx.Lock()
+				handed[task.ID]++
+				mtx.Unlock()
+			}
+		}(fmt.Sprintf("10.0.0.%d", i))
+	}
+	wg.Wait()
+
+	if len(handed) != tasks {
+		t.Errorf("handed out %d tasks, want %d", len(handed), tasks)
+	}
+	for id, n := range handed {
+		if n != 1 {
+			t.Errorf("task %s handed out %d times", id, n)
+		}
+	}
+}
+
+func TestGetNextTaskTenantCap(t *testing.T) {
+	const (
+		tasks   = 200
+		workers = 20
+		limit   = 3
+	)
+
+	s, _ := newTestService(TenantPolicy{Caps: map[string]int{"capped": limit}})
+
+	for i := 0; i < tasks; i++ {
+		err := s.AddTranscoding(wttypes.TranscodingTask{
+			ID:      fmt.Sprintf("task%d", i),
+			Profile: "iPhone5s",
+			Tenant:  "capped",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mtx sync.Mutex
+	handed := []string{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			for {
+				task, err := s.GetNextTask(addr, nil, 0)
+				if err == mgo.ErrNotFound {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mtx.Lock()
+				handed = append(handed, task.ID)
+				mtx.Unlock()
+			}
+		}(fmt.Sprintf("10.0.0.%d", i))
+	}
+	wg.Wait()
+
+	// Nobody ACKs or ends them, so the cap is never released
+	if len(handed) != limit {
+		t.Fatalf("handed out %d tasks, want %d", len(handed), limit)
+	}
+
+	// Cancelling one gives its slot back
+	err := s.CancelTranscoding(handed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetNextTask("10.0.0.1", nil, 0)
+	if err != nil {
+		t.Errorf("GetNextTask() after cancel = %v", err)
+	}
+}
+
+func TestUpdateTaskStatusLeaseLost(t *testing.T) {
+	s, _ := newTestService(TenantPolicy{})
+
+	err := s.AddTranscoding(wttypes.TranscodingTask{ID: "task", Profile: "iPhone5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetNextTask("10.0.0.1", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not running until ACKed
+	err = s.UpdateTaskStatus("task", "10.0.0.1", wttypes.TRANSCODING_FINISHED)
+	if !wttypes.ErrLeaseLost.Is(err) {
+		t.Errorf("UpdateTaskStatus() before ACK = %v, want %v", err, wttypes.ErrLeaseLost)
+	}
+
+	_, err = s.AckTask("task", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the worker running it
+	err = s.UpdateTaskStatus("task", "10.0.0.2", wttypes.TRANSCODING_FINISHED)
+	if !wttypes.ErrLeaseLost.Is(err) {
+		t.Errorf("UpdateTaskStatus() from another worker = %v, want %v", err, wttypes.ErrLeaseLost)
+	}
+
+	err = s.UpdateTaskStatus("task", "10.0.0.1", wttypes.TRANSCODING_FINISHED)
+	if err != nil {
+		t.Errorf("UpdateTaskStatus() = %v", err)
+	}
+
+	// Ended, nothing else changes it
+	err = s.UpdateTaskStatus("task", "10.0.0.1", wttypes.TRANSCODING_ERROR)
+	if !wttypes.ErrLeaseLost.Is(err) {
+		t.Errorf("UpdateTaskStatus() once ended = %v, want %v", err, wttypes.ErrLeaseLost)
+	}
+}
@@ -0,0 +1,34 @@
+package manager
+
+import (
+	"sync"
+)
+
+// queueSignal lets long-polling workers wait until tasks are queued
+type queueSignal struct {
+	mtx sync.Mutex
+	ch  chan struct{}
+}
+
+func newQueueSignal() *queueSignal {
+	return &queueSignal{
+		ch: make(chan struct{}),
+	}
+}
+
+// wait returns a channel closed next time tasks are queued
+func (q *queueSignal) wait() <-chan struct{} {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return q.ch
+}
+
+// broadcast wakes up everyone waiting
+func (q *queueSignal) broadcast() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	close(q.ch)
+	q.ch = make(chan struct{})
+}
@@ -0,0 +1,53 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Store keeps the tasks of the manager: DataStore in MongoDB, or MemoryStore.
+// Methods that hand out or move tasks must be atomic, several managers and
+// workers use the same tasks at once.
+type Store interface {
+	AddTask(task wttypes.TranscodingTask) (string, error)
+
+	GetTotalTasksQueued() (int, error)
+
+	GetTotalTasksRunning() (int, error)
+
+	// Claims the next task for a worker, mgo.ErrNotFound when there is none
+	GetNextQueuedTask(workerAddr string, policy TenantPolicy, excluded []string) (wttypes.TranscodingTask, error)
+
+	GetTenants(policy TenantPolicy) ([]wttypes.TenantStatus, error)
+
+	AgeQueuedTasks(interval time.Duration) (int, error)
+
+	AckTask(id string, workerAddr string, lease time.Duration) error
+
+	RenewLease(id string, workerAddr string, lease time.Duration) error
+
+	RetryTask(id string, workerAddr string, reason string, maxAttempts int, base time.Duration) (string, error)
+
+	UpdateTaskProgress(id string, workerAddr string, progress wttypes.TranscodingProgress) error
+
+	ReapExpiredLeases(maxAttempts int, base time.Duration) ([]string, error)
+
+	GetWaitingTasks(id string) ([]string, error)
+
+	ResolveDependencies(id string) (string, error)
+
+	RequeueExpiredRequests(deadline time.Duration) (int, error)
+
+	UpdateTaskStatus(id string, workerAddr string, status string) error
+
+	CancelTask(id string) (string, error)
+
+	// Release the store once done with it
+	Close()
+}
+
+var (
+	_ Store = (*DataStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
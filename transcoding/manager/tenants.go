@@ -0,0 +1,118 @@
+package manager
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// TenantPolicy holds the fair-share configuration of the tenants
+type TenantPolicy struct {
+	// Weight of each tenant, tenants not listed have weight 1
+	Weights map[string]int
+
+	// Max tasks running at the same time for each tenant
+	Caps map[string]int
+
+	// Cap for tenants not listed in Caps, 0 means unlimited
+	DefaultCap int
+}
+
+// Weight returns the weight of a tenant
+func (p TenantPolicy) Weight(tenant string) int {
+	if w, ok := p.Weights[tenant]; ok && w > 0 {
+		return w
+	}
+
+	return 1
+}
+
+// Cap returns the max concurrent tasks of a tenant, 0 means unlimited
+func (p TenantPolicy) Cap(tenant string) int {
+	if c, ok := p.Caps[tenant]; ok {
+		return c
+	}
+
+	return p.DefaultCap
+}
+
+// tenantShare is used to sort the tenants by how much of their share they are using
+type tenantShare struct {
+	name  string
+	share float64
+}
+
+type byShare []tenantShare
+
+func (s byShare) Len() int      { return len(s) }
+func (s byShare) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byShare) Less(i, j int) bool {
+	if s[i].share == s[j].share {
+		return s[i].name < s[j].name
+	}
+	return s[i].share < s[j].share
+}
+
+// orderTenants returns the tenants with queued tasks, the ones using less of
+// their weighted share first. Tenants that reached their cap are left out.
+func (p TenantPolicy) orderTenants(queued, active map[string]int) []string {
+	shares := []tenantShare{}
+	for tenant, total := range queued {
+		if total == 0 {
+			continue
+		}
+
+		running := active[tenant]
+		if limit := p.Cap(tenant); limit > 0 && running >= limit {
+			continue
+		}
+
+		shares = append(shares, tenantShare{
+			name:  tenant,
+			share: float64(running) / float64(p.Weight(tenant)),
+		})
+	}
+
+	sort.Sort(byShare(shares))
+
+	tenants := []string{}
+	for _, v := range shares {
+		tenants = append(tenants, v.name)
+	}
+
+	return tenants
+}
+
+type byName []wttypes.TenantStatus
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// ParseTenantValues parses a list like "teamA=2,teamB=4" into a map
+func ParseTenantValues(s string) (map[string]int, error) {
+	values := make(map[string]int)
+
+	if s == "" {
+		return values, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.New("Invalid tenant value: " + v)
+		}
+
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 {
+			return nil, errors.New("Invalid tenant value: " + v)
+		}
+
+		values[kv[0]] = n
+	}
+
+	return values, nil
+}
@@ -4,13 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
+	"gopkg.in/mgo.v2"
 
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
@@ -21,7 +24,8 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 		kithttp.ServerErrorEncoder(encodeError),
 	}
 
-	// test: curl -k -H "Content-Type: application/json" -d '{"id":"1", "object_name":"rabbitobject", "profile":"iPhone5s"}' -X POST https://localhost:8082/transcodings
+	// test: curl -k -H "Content-Type: application/json" -d '{"id":"1", "object_name":"rabbitobject", "profile":"iPhone5s", "priority":5, "tenant":"teamA"}' -X POST https://localhost:8082/transcodings
+	// test (stitch): curl -k -H "Content-Type: application/json" -d '{"id":"3", "object_name":"rabbitobject", "kind":"stitch", "profile":"iPhone5s", "depends_on":["1","2"]}' -X POST https://localhost:8082/transcodings
 	addTranscodingHandler := kithttp.NewServer(
 		ctx,
 		makeAddTranscodingEndpoint(tms),
@@ -30,7 +34,7 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 		opts...,
 	)
 
-	// test: curl -k -H "Content-Type: application/json" -d '{"id":"1", "job_id":"1", "object_name":"myobjectname2", "profile":"iPhone5s"}' -X POST https://localhost:8082/transcodings
+	// test: curl -k "https://localhost:8082/tasks?worker=127.0.0.1&wait=30s&encoders=libx264,aac&max_width=1920&max_height=1080&cpus=2"
 	getNextTaskHandler := kithttp.NewServer(
 		ctx,
 		makeGetNextTaskEndpoint(tms),
@@ -57,7 +61,52 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 		opts...,
 	)
 
-	// test: curl -k -H "Content-Type: application/json" -d '{"status":"running"}' -X PUT https://localhost:8082/tasks/1/status
+	// test: curl -k https://localhost:8082/tenants
+	getTenantsHandler := kithttp.NewServer(
+		ctx,
+		makeGetTenantsEndpoint(tms),
+		decodeGetTenantsRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"worker":"127.0.0.1"}' -X PUT https://localhost:8082/tasks/1/ack
+	ackTaskHandler := kithttp.NewServer(
+		ctx,
+		makeAckTaskEndpoint(tms),
+		decodeAckTaskRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"worker":"127.0.0.1"}' -X PUT https://localhost:8082/tasks/1/lease
+	renewLeaseHandler := kithttp.NewServer(
+		ctx,
+		makeRenewLeaseEndpoint(tms),
+		decodeRenewLeaseRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"worker":"127.0.0.1", "progress":{"percent":42.5, "fps":30, "eta":120}}' -X PUT https://localhost:8082/tasks/1/progress
+	updateTaskProgressHandler := kithttp.NewServer(
+		ctx,
+		makeUpdateTaskProgressEndpoint(tms),
+		decodeUpdateTaskProgressRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"worker":"127.0.0.1", "error":"swift timeout"}' -X PUT https://localhost:8082/tasks/1/retry
+	retryTaskHandler := kithttp.NewServer(
+		ctx,
+		makeRetryTaskEndpoint(tms),
+		decodeRetryTaskRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	// test: curl -k -H "Content-Type: application/json" -d '{"worker":"127.0.0.1", "status":"finished"}' -X PUT https://localhost:8082/tasks/1/status
 	updateTaskStatusHandler := kithttp.NewServer(
 		ctx,
 		makeUpdateTaskStatusEndpoint(tms),
@@ -81,9 +130,15 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 	r.Handle("/tasks", getNextTaskHandler).Methods("GET")
 	r.Handle("/tasks/queued", getTotalTasksQueuedHandler).Methods("GET")
 	r.Handle("/tasks/running", getTotalTasksRunningHandler).Methods("GET")
+	r.Handle("/tasks/{id}/ack", ackTaskHandler).Methods("PUT")
+	r.Handle("/tasks/{id}/lease", renewLeaseHandler).Methods("PUT")
+	r.Handle("/tasks/{id}/progress", updateTaskProgressHandler).Methods("PUT")
+	r.Handle("/tasks/{id}/retry", retryTaskHandler).Methods("PUT")
 	r.Handle("/tasks/{id}/status", updateTaskStatusHandler).Methods("PUT")
 	r.Handle("/tasks/{id}", cancelTaskHandler).Methods("DELETE")
 
+	r.Handle("/tenants", getTenantsHandler).Methods("GET")
+
 	return r
 
 }
@@ -97,14 +152,51 @@ func decodeAddTranscodingRequest(_ context.Context, r *http.Request) (interface{
 
 	fmt.Println("[manager] decodeAddTranscodingRequest:", t)
 
-	if t.ID == "" || t.ObjectName == "" || t.Profile == "" {
+	if t.ID == "" || t.ObjectName == "" {
 		return nil, wttypes.ErrInvalidArgument
 	}
 
+	switch t.Kind {
+	case "", wttypes.TASK_TRANSCODING, wttypes.TASK_CHUNK:
+		// Either a profile or the renditions of a ladder
+		if t.Profile == "" && len(t.Renditions) == 0 {
+			return nil, wttypes.ErrInvalidArgument
+		}
+
+		if len(t.Renditions) > 0 && (t.ABR == nil || t.ABR.Validate() != nil) {
+			return nil, wttypes.ErrInvalidABR
+		}
+	case wttypes.TASK_STITCH:
+		// Joins the chunks of a profile once they are transcoded
+		if t.Profile == "" || len(t.DependsOn) == 0 {
+			return nil, wttypes.ErrInvalidArgument
+		}
+	case wttypes.TASK_THUMBNAILS:
+		// Jobs service already filled the defaults
+		if t.Profile != "" || t.Thumbnails == nil || t.Thumbnails.Validate() != nil {
+			return nil, wttypes.ErrInvalidThumbnails
+		}
+	default:
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	if t.Priority < 0 || t.Priority > wttypes.PRIORITY_MAX {
+		return nil, wttypes.ErrInvalidPriority
+	}
+
 	return addTranscodingRequest{
-		ID:         t.ID,
-		ObjectName: t.ObjectName,
-		Profile:    t.Profile,
+		Task: wttypes.TranscodingTask{
+			ID:         t.ID,
+			ObjectName: t.ObjectName,
+			Profile:    t.Profile,
+			Priority:   t.Priority,
+			Tenant:     t.Tenant,
+			Renditions: t.Renditions,
+			ABR:        t.ABR,
+			Kind:       t.Kind,
+			Thumbnails: t.Thumbnails,
+			DependsOn:  t.DependsOn,
+		},
 	}, nil
 }
 
@@ -114,8 +206,24 @@ func decodeGetNextTaskRequest(_ context.Context, r *http.Request) (interface{},
 		return nil, wttypes.ErrInvalidArgument
 	}
 
+	caps, err := wttypes.CapabilitiesFromValues(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	// Optional long-poll: wait for a task to be queued
+	var wait time.Duration
+	if s := r.FormValue("wait"); s != "" {
+		wait, err = time.ParseDuration(s)
+		if err != nil || wait < 0 {
+			return nil, wttypes.ErrInvalidArgument
+		}
+	}
+
 	return getNextTaskRequest{
-		WorkerAddr: worker,
+		WorkerAddr:   worker,
+		Capabilities: caps,
+		Wait:         wait,
 	}, nil
 }
 
@@ -129,8 +237,107 @@ func decodeGetTotalTasksRunningRequest(_ context.Context, r *http.Request) (inte
 	return getTotalTasksQueuedRequest{}, nil
 }
 
+func decodeGetTenantsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return getTenantsRequest{}, nil
+}
+
+func decodeAckTaskRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Worker string `json:"worker"`
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	if body.Worker == "" {
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	return ackTaskRequest{ID: id, WorkerAddr: body.Worker}, nil
+}
+
+func decodeRenewLeaseRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Worker string `json:"worker"`
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	if body.Worker == "" {
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	return renewLeaseRequest{ID: id, WorkerAddr: body.Worker}, nil
+}
+
+func decodeUpdateTaskProgressRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Worker   string                      `json:"worker"`
+		Progress wttypes.TranscodingProgress `json:"progress"`
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	if body.Worker == "" {
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	return updateTaskProgressRequest{ID: id, WorkerAddr: body.Worker, Progress: body.Progress}, nil
+}
+
+func decodeRetryTaskRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Worker string `json:"worker"`
+		Error  string `json:"error"`
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	if body.Worker == "" {
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	return retryTaskRequest{ID: id, WorkerAddr: body.Worker, Reason: body.Error}, nil
+}
+
 func decodeUpdateTaskStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var body struct {
+		Worker string `json:"worker"`
 		Status string `json:"status"`
 	}
 
@@ -145,7 +352,11 @@ func decodeUpdateTaskStatusRequest(_ context.Context, r *http.Request) (interfac
 		return nil, err
 	}
 
-	return updateTaskStatusRequest{ID: id, Status: body.Status}, nil
+	if body.Worker == "" {
+		return nil, wttypes.ErrInvalidArgument
+	}
+
+	return updateTaskStatusRequest{ID: id, WorkerAddr: body.Worker, Status: body.Status}, nil
 }
 
 func decodeCancelTaskRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -174,18 +385,10 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 
 // encode errors from business-logic
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	switch err {
-	case wttypes.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case wttypes.ErrInvalidArgument:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+	// Not found in MongoDB
+	if err == mgo.ErrNotFound {
+		err = wttypes.ErrNotFound
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	wtcommon.EncodeError(err, w)
 }
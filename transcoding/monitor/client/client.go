@@ -0,0 +1,47 @@
+package client
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Client is a client of the transcoding monitor service
+type Client struct {
+	c *wtclient.Client
+}
+
+// RegisterWorker registers a worker with its capabilities
+func (cl *Client) RegisterWorker(ctx context.Context, addr string, caps *wttypes.WorkerCapabilities) error {
+	ws := wttypes.WorkerStatus{
+		Addr:         addr,
+		Status:       wttypes.WORKER_STATUS_ONLINE,
+		Capabilities: caps,
+	}
+
+	return cl.c.Call(ctx, "POST", "/workers", ws, nil)
+}
+
+// DeregisterWorker deregisters a worker
+func (cl *Client) DeregisterWorker(ctx context.Context, addr string) error {
+	ws := wttypes.WorkerStatus{
+		Addr: addr,
+	}
+
+	return cl.c.Call(ctx, "DELETE", "/workers", ws, nil)
+}
+
+// UpdateWorkerStatus updates the status of a worker
+func (cl *Client) UpdateWorkerStatus(ctx context.Context, ws wttypes.WorkerStatus) error {
+	return cl.c.Call(ctx, "PUT", "/workers/status", ws, nil)
+}
+
+// New creates a client of the transcoding monitor service at addr (https://server:port)
+func New(addr string, timeout time.Duration) *Client {
+	return &Client{
+		c: wtclient.New(addr, timeout),
+	}
+}
@@ -10,7 +10,8 @@ import (
 // Register Worker
 
 type registerWorkerRequest struct {
-	Addr string
+	Addr         string
+	Capabilities *wttypes.WorkerCapabilities
 }
 
 type registerWorkerResponse struct {
@@ -22,7 +23,7 @@ func (r registerWorkerResponse) error() error { return r.Err }
 func makeRegisterWorkerEndpoint(tms Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(registerWorkerRequest)
-		err := tms.RegisterWorker(req.Addr)
+		err := tms.RegisterWorker(req.Addr, req.Capabilities)
 		return registerWorkerResponse{Err: err}, nil
 	}
 }
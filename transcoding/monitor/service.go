@@ -1,20 +1,19 @@
 package monitor
 
 import (
-	"crypto/tls"
 	"fmt"
-	"strings"
 
-	"github.com/go-resty/resty"
+	"golang.org/x/net/context"
 
-	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
+	"github.com/obazavil/openstack-workload-transcoding/database/client"
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
 // Service is the interface that provides transcoding monitor methods.
 type Service interface {
-	// Register a worker
-	RegisterWorker(addr string) error
+	// Register a worker with its capabilities
+	RegisterWorker(addr string, caps *wttypes.WorkerCapabilities) error
 
 	// Deregister a worker
 	DeregisterWorker(addr string) error
@@ -24,15 +23,16 @@ type Service interface {
 }
 
 type service struct {
-	database string
+	database *client.Client
 }
 
-func (s *service) RegisterWorker(addr string) error {
+func (s *service) RegisterWorker(addr string, caps *wttypes.WorkerCapabilities) error {
 	fmt.Println("registering worker:", addr)
 
 	ws := wttypes.WorkerStatus{
-		Addr:   addr,
-		Status: wttypes.WORKER_STATUS_ONLINE,
+		Addr:         addr,
+		Status:       wttypes.WORKER_STATUS_ONLINE,
+		Capabilities: caps,
 	}
 	err := s.UpdateWorkerStatus(ws)
 
@@ -55,33 +55,19 @@ func (s *service) UpdateWorkerStatus(ws wttypes.WorkerStatus) error {
 	fmt.Println("changing status:", ws.Addr, ws.Status)
 
 	// Update Worker in DB
-	resp, err := resty.R().
-		SetBody(ws).
-		Put(s.database + "/workers/status")
-
-	// Error in communication
+	err := s.database.UpdateWorkerStatus(context.Background(), ws)
 	if err != nil {
 		fmt.Println("[err] UpdateWorkerStatus:", err)
 		return err
 	}
 
-	str := resp.String()
-
-	// There was an error in the response?
-	if strings.HasPrefix(str, `{"error"`) {
-		fmt.Println("[err] UpdateWorkerStatus:", err)
-		return wtcommon.JSON2Err(str)
-	}
-
 	fmt.Println("changing status OK")
 	return nil
 }
 
 // NewService creates a transcoding monitor service with necessary dependencies.
 func NewService(database string) (Service, error) {
-	resty.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-
 	return &service{
-		database: database,
+		database: client.New(database, wtclient.TIMEOUT),
 	}, nil
 }
@@ -11,6 +11,7 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
@@ -21,7 +22,7 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 		kithttp.ServerErrorEncoder(encodeError),
 	}
 
-	// test: curl -k -H "Content-Type: application/json" -d '{"addr":"myip"}' -X POST https://localhost:8084/workers
+	// test: curl -k -H "Content-Type: application/json" -d '{"addr":"myip", "capabilities":{"encoders":["libx264","aac"], "cpus":2}}' -X POST https://localhost:8084/workers
 	registerWorkerHandler := kithttp.NewServer(
 		ctx,
 		makeRegisterWorkerEndpoint(tms),
@@ -73,7 +74,8 @@ func decodeRegisterWorkerRequest(_ context.Context, r *http.Request) (interface{
 	}
 
 	return registerWorkerRequest{
-		Addr: ws.Addr,
+		Addr:         ws.Addr,
+		Capabilities: ws.Capabilities,
 	}, nil
 }
 
@@ -128,18 +130,5 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 
 // encode errors from business-logic
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	switch err {
-	case wttypes.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case wttypes.ErrInvalidArgument:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	wtcommon.EncodeError(err, w)
 }
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// detectCapabilities returns what each slot of this worker can do, maxResolution
+// ("<width>x<height>") is optional since it can't be detected
+func detectCapabilities(maxResolution string, slots int) (wttypes.WorkerCapabilities, error) {
+	// CPUs are shared between the slots
+	cpus := runtime.NumCPU() / slots
+	if cpus < 1 {
+		cpus = 1
+	}
+
+	c := wttypes.WorkerCapabilities{
+		CPUs:     cpus,
+		DiskFree: diskFree(os.TempDir()),
+	}
+
+	encoders, err := ffmpegEncoders()
+	if err != nil {
+		return c, err
+	}
+	c.Encoders = encoders
+
+	if maxResolution != "" {
+		wh := strings.Split(maxResolution, "x")
+		if len(wh) != 2 {
+			return c, wttypes.ErrInvalidArgument
+		}
+
+		c.MaxWidth, err = strconv.Atoi(wh[0])
+		if err != nil {
+			return c, wttypes.ErrInvalidArgument
+		}
+
+		c.MaxHeight, err = strconv.Atoi(wh[1])
+		if err != nil {
+			return c, wttypes.ErrInvalidArgument
+		}
+	}
+
+	return c, nil
+}
+
+// ffmpegEncoders returns the encoders available in the installed ffmpeg
+func ffmpegEncoders() ([]string, error) {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// Encoders are listed after " ------" as " V..... libx264   description"
+	encoders := []string{}
+	list := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "---") {
+			list = true
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if list && len(fields) >= 2 {
+			encoders = append(encoders, fields[1])
+		}
+	}
+
+	return encoders, scanner.Err()
+}
+
+// diskFree returns the bytes available in the filesystem of path, 0 if unknown
+func diskFree(path string) int64 {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Client is a client of the transcoding worker service
+type Client struct {
+	c *wtclient.Client
+}
+
+// GetStatus gets the status of the worker and each of its slots
+func (cl *Client) GetStatus(ctx context.Context) (string, []wttypes.SlotStatus, error) {
+	var resp struct {
+		Status string               `json:"status"`
+		Slots  []wttypes.SlotStatus `json:"slots"`
+	}
+
+	err := cl.c.Call(ctx, "GET", "/worker/status", nil, &resp)
+
+	return resp.Status, resp.Slots, err
+}
+
+// CancelTask cancels a running transcoding task
+func (cl *Client) CancelTask(ctx context.Context, id string) error {
+	return cl.c.Call(ctx, "DELETE", "/tasks/"+id, nil, nil)
+}
+
+// New creates a client of the transcoding worker service at addr (https://server:port)
+func New(addr string, timeout time.Duration) *Client {
+	return &Client{
+		c: wtclient.New(addr, timeout),
+	}
+}
+
+// NewFromIP creates a client of the worker with the IP it registered with in monitor service
+func NewFromIP(ip string, timeout time.Duration) *Client {
+	return New("https://"+ip+":"+wtcommon.WORKER_PORT, timeout)
+}
@@ -8,12 +8,12 @@ import (
 	"os/exec"
 	"os/signal"
 	"path"
-	"strings"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log"
-	"github.com/go-resty/resty"
+	"github.com/rackspace/gophercloud"
 	"golang.org/x/net/context"
 
 	"github.com/obazavil/openstack-workload-transcoding/transcoding/worker"
@@ -23,17 +23,461 @@ import (
 
 const (
 	DELAY = 15 * time.Second
+
+	// How often the lease is renewed when manager doesn't tell how long it lasts
+	LEASE_RENEW = 20 * time.Second
+
+	// How often progress of a running task is reported
+	PROGRESS_INTERVAL = 5 * time.Second
 )
 
-// test: go run transcoding/worker/cmd/main.go -jobs=https://localhost:8081 -manager=https://localhost:8082 -monitor=https://localhost:8084
+// reportTaskError notifies a failed task, transient errors are handed back to manager for a retry
+func reportTaskError(tws worker.Service, id string, err error) {
+	fmt.Printf("[err] task %s: %s.\n",
+		id, err)
+
+	if wttypes.IsRetryable(err) {
+		status, errR := tws.RetryTask(id, err.Error())
+		if errR == nil {
+			fmt.Println("[worker] task handed back for retry:", id, status)
+			return
+		}
+
+		fmt.Printf("[err] retry task %s: %s.\n",
+			id, errR)
+	}
+
+	tws.NotifyTaskStatus(id, wttypes.TRANSCODING_ERROR, "")
+}
+
+// ffmpegResult maps how ffmpeg ended into the status of the task
+func ffmpegResult(errWait error) (string, error) {
+	if errWait == nil {
+		return wttypes.TRANSCODING_FINISHED, nil
+	}
+
+	// Maybe ffmpeg is broken on this worker, another one could do it
+	exiterr, ok := errWait.(*exec.ExitError)
+	if !ok {
+		return "", wttypes.Retryable(errWait)
+	}
+
+	// Cancelled
+	if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == 255 {
+		return wttypes.TRANSCODING_CANCELLED, nil
+	}
+
+	// A failing ffmpeg means bad input or profile, retrying won't help
+	return "", wttypes.ErrTranscodingFailed
+}
+
+// transcode runs the transcoding task on the downloaded media, and uploads the result
+func transcode(slot int, tws worker.Service, serviceObjectStorage *gophercloud.ServiceClient, task wttypes.TranscodingTask, fnOriginal string) (string, string, error) {
+	// Get profiles information, a ladder has one per rendition
+	names := task.Renditions
+	if len(names) == 0 {
+		names = []string{task.Profile}
+	}
+
+	profiles := []wttypes.Profile{}
+	for _, name := range names {
+		p, err := tws.GetProfile(name)
+		if err != nil {
+			fmt.Printf("[err] Profile %s: %s.\n",
+				name, err)
+
+			return "", "", err
+		}
+		profiles = append(profiles, p)
+	}
+
+	// Inspect the source, jobs service already validated it
+	media, err := wtcommon.ProbeMedia(fnOriginal)
+	if err != nil {
+		fmt.Printf("[err] ffprobe: %s.\n",
+			err)
+
+		return "", "", err
+	}
+
+	// Don't make the source bigger than it is, nothing to gain
+	kept := []wttypes.Profile{}
+	for _, p := range profiles {
+		// Renditions of a ladder need video
+		if task.ABR != nil && p.AudioOnly() {
+			fmt.Printf("[worker] task %s: profile %s has no video for the ladder.\n",
+				task.ID, p.Name)
+			continue
+		}
+
+		if media.Upscales(p.Requirements()) {
+			fmt.Printf("[worker] task %s: profile %s would upscale %dx%d.\n",
+				task.ID, p.Name, media.Width, media.Height)
+			continue
+		}
+		kept = append(kept, p)
+	}
+
+	if len(kept) == 0 {
+		fmt.Println("[worker] skipping task:", task.ID)
+
+		return wttypes.TRANSCODING_SKIPPED, "", nil
+	}
+
+	// Name and path of the transcoded media, with the extension of its container
+	vnTranscoded := kept[0].OutputName(task.ObjectName)
+	fnTranscoded := path.Join(os.TempDir(), vnTranscoded)
+
+	// A ladder goes into a directory, uploaded under a prefix
+	if task.ABR != nil {
+		vnTranscoded = fmt.Sprintf("%s-abr",
+			task.ObjectName,
+		)
+		fnTranscoded = path.Join(os.TempDir(), task.ID)
+	}
+
+	// Execute ffmpeg, writing progress to stdout
+	args := []string{"-progress", "pipe:1", "-nostats"}
+
+	// Remove target just in case before we start, and when we are done
+	os.RemoveAll(fnTranscoded)
+	defer os.RemoveAll(fnTranscoded)
+
+	if task.ABR != nil {
+		// Every rendition of HLS gets its own directory
+		for i := range kept {
+			os.MkdirAll(path.Join(fnTranscoded, strconv.Itoa(i)), 0755)
+		}
+
+		args = append(args, task.ABR.FFMPEGArgs(kept, fnOriginal, fnTranscoded, len(media.AudioTracks) > 0)...)
+	} else {
+		args = append(args, kept[0].FFMPEGArgs(fnOriginal, fnTranscoded)...)
+	}
+
+	cmd := exec.Command("ffmpeg", args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		fmt.Printf("[err] ffmpeg: %s.\n",
+			err)
+
+		// Maybe ffmpeg is broken on this worker, another one could do it
+		return "", "", wttypes.Retryable(err)
+	}
+
+	// Update process in the service (por cancellation purposes)
+	tws.SlotUpdateProcess(slot, cmd.Process)
+	fmt.Println("ENCODING...")
+
+	// Report progress while ffmpeg runs, it closes stdout when done
+	var lastReport time.Time
+	err = worker.ReadProgress(stdout, media.Duration, func(p wttypes.TranscodingProgress) {
+		if time.Since(lastReport) < PROGRESS_INTERVAL && p.Percent < 100 {
+			return
+		}
+		lastReport = time.Now()
+
+		tws.NotifyTaskProgress(task.ID, p)
+	})
+	if err != nil {
+		fmt.Printf("[err] ffmpeg progress: %s.\n",
+			err)
+	}
+
+	// Wait for ffmpeg to finish
+	status, err := ffmpegResult(cmd.Wait())
+	if status != wttypes.TRANSCODING_FINISHED {
+		return status, "", err
+	}
+
+	// Cancelled after ffmpeg was done, nothing to upload
+	if tws.SlotCancelled(slot) {
+		return wttypes.TRANSCODING_CANCELLED, "", nil
+	}
+
+	var objectname string
+	if task.ABR != nil {
+		objectname = vnTranscoded
+		err = wtcommon.UploadDir2ObjectStorage(serviceObjectStorage, fnTranscoded, vnTranscoded, wtcommon.TRANSCODED_MEDIA_CONTAINER)
+	} else {
+		objectname, err = wtcommon.Upload2ObjectStorage(serviceObjectStorage, fnTranscoded, vnTranscoded, wtcommon.TRANSCODED_MEDIA_CONTAINER)
+	}
+	if err != nil {
+		fmt.Printf("[err] object storage: %s.\n",
+			err)
+
+		return "", "", wttypes.Retryable(err)
+	}
+
+	return status, objectname, nil
+}
+
+// thumbnails extracts poster, thumbnails and sprite sheets of the downloaded media, and uploads them
+func thumbnails(slot int, tws worker.Service, serviceObjectStorage *gophercloud.ServiceClient, task wttypes.TranscodingTask, fnOriginal string) (string, string, error) {
+	opts := wttypes.ThumbnailOptions{}
+	if task.Thumbnails != nil {
+		opts = *task.Thumbnails
+	}
+	opts = opts.WithDefaults()
+
+	// We need the duration to know where the thumbnails are
+	media, err := wtcommon.ProbeMedia(fnOriginal)
+	if err != nil {
+		fmt.Printf("[err] ffprobe: %s.\n",
+			err)
+
+		return "", "", err
+	}
+
+	// Everything goes into a directory, uploaded under a prefix next to the transcoded media
+	vnThumbnails := fmt.Sprintf("%s-thumbnails",
+		task.ObjectName,
+	)
+	dir := path.Join(os.TempDir(), task.ID)
+
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", "", wttypes.Retryable(err)
+	}
+
+	fmt.Println("THUMBNAILS...")
+	err = worker.GenerateThumbnails(fnOriginal, dir, opts, media.Duration, func(p *os.Process) {
+		// Update process in the service (por cancellation purposes)
+		tws.SlotUpdateProcess(slot, p)
+	})
+
+	status, err := ffmpegResult(err)
+	if status != wttypes.TRANSCODING_FINISHED {
+		return status, "", err
+	}
+
+	// Cancelled after the last ffmpeg was done, nothing to upload
+	if tws.SlotCancelled(slot) {
+		return wttypes.TRANSCODING_CANCELLED, "", nil
+	}
+
+	err = wtcommon.UploadDir2ObjectStorage(serviceObjectStorage, dir, vnThumbnails, wtcommon.TRANSCODED_MEDIA_CONTAINER)
+	if err != nil {
+		fmt.Printf("[err] object storage: %s.\n",
+			err)
+
+		return "", "", wttypes.Retryable(err)
+	}
+
+	return status, vnThumbnails, nil
+}
+
+// stitch joins the transcoded chunks of a long source, and uploads the result
+func stitch(slot int, tws worker.Service, serviceObjectStorage *gophercloud.ServiceClient, task wttypes.TranscodingTask) (string, string, error) {
+	p, err := tws.GetProfile(task.Profile)
+	if err != nil {
+		fmt.Printf("[err] Profile %s: %s.\n",
+			task.Profile, err)
+
+		return "", "", err
+	}
+
+	dir := path.Join(os.TempDir(), task.ID)
+
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", "", wttypes.Retryable(err)
+	}
+
+	// Chunks are the transcodings it depends on, database knows where they were uploaded
+	chunks := []string{}
+	for i, id := range task.DependsOn {
+		if tws.SlotCancelled(slot) {
+			return wttypes.TRANSCODING_CANCELLED, "", nil
+		}
+
+		t, err := tws.GetTranscoding(id)
+		if err != nil {
+			return "", "", wttypes.Retryable(err)
+		}
+		if t.Status != wttypes.TRANSCODING_FINISHED {
+			return "", "", wttypes.Retryable(wttypes.ErrChunkNotFinished)
+		}
+
+		fn := path.Join(dir, fmt.Sprintf("chunk_%05d%s", i, p.Extension()))
+		err = wtcommon.DownloadFromObjectStorage(serviceObjectStorage, t.ObjectName, fn, wtcommon.TRANSCODED_MEDIA_CONTAINER)
+		if err != nil {
+			return "", "", wttypes.Retryable(err)
+		}
+		chunks = append(chunks, fn)
+	}
+
+	// Same name it would have if transcoded whole
+	vnStitched := p.OutputName(task.ObjectName)
+	fnStitched := path.Join(dir, "stitched"+p.Extension())
+
+	fmt.Println("STITCHING...")
+	err = worker.StitchMedia(chunks, fnStitched, p, func(proc *os.Process) {
+		// Update process in the service (por cancellation purposes)
+		tws.SlotUpdateProcess(slot, proc)
+	})
+
+	status, err := ffmpegResult(err)
+	if status != wttypes.TRANSCODING_FINISHED {
+		return status, "", err
+	}
+
+	// Cancelled after ffmpeg was done, nothing to upload
+	if tws.SlotCancelled(slot) {
+		return wttypes.TRANSCODING_CANCELLED, "", nil
+	}
+
+	objectname, err := wtcommon.Upload2ObjectStorage(serviceObjectStorage, fnStitched, vnStitched, wtcommon.TRANSCODED_MEDIA_CONTAINER)
+	if err != nil {
+		fmt.Printf("[err] object storage: %s.\n",
+			err)
+
+		return "", "", wttypes.Retryable(err)
+	}
+
+	return status, objectname, nil
+}
+
+// work runs transcoding tasks on a slot of the worker, forever
+func work(slot int, tws worker.Service, serviceObjectStorage *gophercloud.ServiceClient, wait time.Duration) {
+	for {
+		// Ask manager for work we are capable of
+		asked := time.Now()
+		task, err := tws.GetNextTask(wait)
+
+		// There was an error? retry, sleeping unless manager already held us waiting
+		if err != nil {
+			if wait == 0 || time.Since(asked) < wait {
+				time.Sleep(DELAY)
+			}
+			continue
+		}
+
+		fmt.Println("[worker] received task:", task)
+
+		// ACK the task, if we are late manager already gave it to someone else
+		lease, err := tws.AckTask(task.ID)
+		if err != nil {
+			fmt.Printf("[err] ack task %s: %s.\n",
+				task.ID, err)
+
+			time.Sleep(DELAY)
+			continue
+		}
+
+		// Renewing a few times per lease, a late renewal doesn't lose it
+		renew := lease / 3
+		if renew <= 0 {
+			renew = LEASE_RENEW
+		}
+
+		// Everything fine so far, let's update our status
+		tws.SlotStart(slot, task.ID)
+		tws.NotifyTaskStatus(task.ID, wttypes.TRANSCODING_RUNNING, "")
+
+		// Keep renewing the lease while we work on the task
+		stopLease := make(chan struct{})
+		leaseDone := make(chan struct{})
+		leaseLost := false
+		go func(id string) {
+			defer close(leaseDone)
+
+			for {
+				select {
+				case <-stopLease:
+					return
+				case <-time.After(renew):
+				}
+
+				err := tws.RenewLease(id)
+				if err != nil {
+					fmt.Printf("[err] renew lease %s: %s.\n",
+						id, err)
+
+					// Manager gave the task to someone else, stop working on it
+					if wttypes.ErrLeaseLost.Is(err) {
+						leaseLost = true
+						tws.CancelTask(id)
+						return
+					}
+				}
+			}
+		}(task.ID)
+
+		// Name and path of our source media, keeping its extension
+		fnOriginal := path.Join(os.TempDir(),
+			fmt.Sprintf("%s-%s%s",
+				task.ObjectName,
+				task.ID,
+				path.Ext(task.ObjectName),
+			))
+
+		// Download media from object storage (stitches only need the transcoded chunks), then do the task
+		var status, objectname string
+		var errTask error
+
+		err = nil
+		if task.Kind != wttypes.TASK_STITCH {
+			err = wtcommon.DownloadFromObjectStorage(serviceObjectStorage, task.ObjectName, fnOriginal, wtcommon.SOURCE_MEDIA_CONTAINER)
+		}
+		switch {
+		case err != nil:
+			errTask = wttypes.Retryable(err)
+		case tws.SlotCancelled(slot):
+			status = wttypes.TRANSCODING_CANCELLED
+		case task.Kind == wttypes.TASK_STITCH:
+			status, objectname, errTask = stitch(slot, tws, serviceObjectStorage, task)
+		case task.Kind == wttypes.TASK_THUMBNAILS:
+			status, objectname, errTask = thumbnails(slot, tws, serviceObjectStorage, task, fnOriginal)
+		default:
+			status, objectname, errTask = transcode(slot, tws, serviceObjectStorage, task, fnOriginal)
+		}
+
+		// Wait for the renewals to stop, only then we know if the lease was lost
+		close(stopLease)
+		<-leaseDone
+
+		os.Remove(fnOriginal)
+		tws.SlotFree(slot)
+
+		// Task belongs to another worker now, don't report on it
+		if leaseLost {
+			fmt.Println("[worker] lease lost, not reporting task:", task.ID)
+		} else if errTask != nil {
+			reportTaskError(tws, task.ID, errTask)
+		} else {
+			tws.NotifyTaskStatus(task.ID, status, objectname)
+		}
+
+		// When long-polling there's no need to wait before asking for more work
+		if wait == 0 || errTask != nil {
+			time.Sleep(DELAY)
+		}
+	}
+}
+
+// test: go run transcoding/worker/cmd/main.go -database=https://localhost:8080 -jobs=https://localhost:8081 -manager=https://localhost:8082 -monitor=https://localhost:8084
 func main() {
 	var err error
 
 	var (
 		httpAddr = ":" + wtcommon.WORKER_PORT
+		database = flag.String("database", "", "Database service address (http://server:port)")
 		jobs     = flag.String("jobs", "", "Jobs service address (http://server:port)")
 		manager  = flag.String("manager", "", "Manager service address (http://server:port)")
 		monitor  = flag.String("monitor", "", "Monitor service address (http://server:port)")
+		maxRes   = flag.String("max-resolution", "", "Max resolution this worker can transcode (1920x1080), empty for unlimited")
+		wait     = flag.Duration("wait", 30*time.Second, "Time manager holds our request for work until a task is queued, 0 to poll every 15s")
+		slots    = flag.Int("slots", 1, "Number of transcoding tasks to run at the same time")
 	)
 	flag.Parse()
 
@@ -50,6 +494,16 @@ func main() {
 		ctx = context.Background()
 	}
 
+	if *database == "" {
+		logger.Log("error", "Database service not specified")
+		os.Exit(1)
+	}
+
+	if !wtcommon.IsValidURL(*database) {
+		logger.Log("error", "Invalid address for database service")
+		os.Exit(1)
+	}
+
 	if *jobs == "" {
 		logger.Log("error", "Jobs service not specified")
 		os.Exit(1)
@@ -82,14 +536,17 @@ func main() {
 
 	var tws worker.Service
 	{
-		tws, err = worker.NewService(*jobs, *manager, *monitor)
+		tws, err = worker.NewService(*database, *jobs, *manager, *monitor, *maxRes, *slots)
 		if err != nil {
 			logger.Log("error", "Cannot create service: "+err.Error())
 			os.Exit(1)
 		}
 	}
 
-	tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_ONLINE)
+	err = tws.RegisterWorker()
+	if err != nil {
+		logger.Log("error", "Cannot register worker: "+err.Error())
+	}
 
 	mux := http.NewServeMux()
 
@@ -110,158 +567,25 @@ func main() {
 	}()
 
 	// Transcoding go func
-	//forever := make(chan bool)
 	go func() {
 		// OpenStack
 		provider, err := wtcommon.GetProvider()
 		if err != nil {
 			errs <- err
+			return
 		}
 
 		serviceObjectStorage, err := wtcommon.GetServiceObjectStorage(provider)
 		if err != nil {
 			errs <- err
+			return
 		}
 
 		tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_IDLE)
 
-		for {
-			// Ask manager for work
-			resp, err := resty.R().
-				Get(*manager + "/tasks?worker=" + tws.GetIP())
-
-			// Error in communication? sleep and retry
-			if err != nil {
-				time.Sleep(DELAY)
-				continue
-			}
-
-			// Get response
-			str := resp.String()
-
-			// There was an error? sleep and retry
-			if strings.HasPrefix(str, `{"error"`) {
-				time.Sleep(DELAY)
-				continue
-			}
-
-			// Decode into task type
-			task, err := wtcommon.JSON2Task(str)
-			if err != nil {
-				time.Sleep(DELAY)
-				continue
-			}
-
-			fmt.Println("[worker] received task:", task)
-
-			// Everything fine so far, let's update our status
-			tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_IDLE)
-			tws.NotifyTaskStatus(task.ID, wttypes.TRANSCODING_RUNNING, "")
-
-			// Names and paths of our media
-			fnOriginal := path.Join(os.TempDir(),
-				fmt.Sprintf("%s.mp4",
-					task.ObjectName,
-				))
-
-			vnTranscoded := fmt.Sprintf("%s-%s.mp4",
-				task.ObjectName,
-				task.Profile,
-			)
-			fnTranscoded := path.Join(os.TempDir(), vnTranscoded)
-
-			// Download media from object storage
-			err = wtcommon.DownloadFromObjectStorage(serviceObjectStorage, task.ObjectName, fnOriginal)
-			if err != nil {
-				tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_IDLE)
-				tws.NotifyTaskStatus(task.ID, wttypes.TRANSCODING_ERROR, "")
-				time.Sleep(DELAY)
-				continue
-			}
-
-			// Get profile information
-			p, ok := wttypes.NewProfile()[task.Profile]
-			if !ok {
-				fmt.Printf("[err] Profile %s doesn't exist.\n",
-					task.Profile)
-
-				tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_IDLE)
-				tws.NotifyTaskStatus(task.ID, wttypes.TRANSCODING_ERROR, "")
-				time.Sleep(DELAY)
-				continue
-			}
-
-			// Execute ffmpeg
-			args := []string{"-i", fnOriginal}
-
-			args = append(args, strings.Split(p.FFMPEG.Args, " ")...)
-
-			if p.Resolution != "" {
-				args = append(args, "-s")
-				args = append(args, p.Resolution)
-			}
-
-			args = append(args, fnTranscoded)
-
-			cmd := exec.Command("ffmpeg", args...)
-
-			// Remove target file just in case before we start
-			os.Remove(fnTranscoded)
-
-			err = cmd.Start()
-			if err != nil {
-				fmt.Printf("[err] ffmpeg: %s.\n",
-					err)
-
-				tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_IDLE)
-				tws.NotifyTaskStatus(task.ID, wttypes.TRANSCODING_ERROR, "")
-				time.Sleep(DELAY)
-				continue
-			}
-
-			// Update process in the service (por cancellation purposes)
-			tws.WorkerUpdateProcess(cmd.Process)
-			fmt.Println("ENCODING...")
-
-			// Wait for ffmpeg to finish
-			exitCode := 0
-			errWait := cmd.Wait()
-			if errWait != nil {
-				if exiterr, ok := errWait.(*exec.ExitError); ok {
-					if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-						exitCode = status.ExitStatus()
-					}
-				}
-			}
-
-			var status string
-			switch {
-			case errWait == nil:
-				status = wttypes.TRANSCODING_FINISHED
-			case exitCode == 255:
-				status = wttypes.TRANSCODING_CANCELLED
-			default:
-				status = wttypes.TRANSCODING_ERROR
-			}
-
-			var objectname string
-			if status == wttypes.TRANSCODING_FINISHED {
-				objectname, err = wtcommon.Upload2ObjectStorage(serviceObjectStorage, fnTranscoded, vnTranscoded, wtcommon.TRANSCODED_MEDIA_CONTAINER)
-				if err != nil {
-					fmt.Printf("[err] object storage: %s.\n",
-						err)
-
-					status = wttypes.TRANSCODING_ERROR
-				}
-			}
-
-			os.Remove(fnTranscoded)
-			tws.WorkerUpdateStatus(wttypes.WORKER_STATUS_IDLE)
-			tws.WorkerUpdateProcess(nil)
-
-			tws.NotifyTaskStatus(task.ID, status, objectname)
-
-			time.Sleep(DELAY)
+		// Every slot asks for work and transcodes on its own
+		for i := 0; i < tws.GetSlots(); i++ {
+			go work(i, tws, serviceObjectStorage, *wait)
 		}
 	}()
 
@@ -3,6 +3,8 @@ package worker
 import (
 	"github.com/go-kit/kit/endpoint"
 	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
 // GetStatus
@@ -11,22 +13,24 @@ type getStatusRequest struct {
 }
 
 type getStatusResponse struct {
-	Status string `json:"status,omitempty"`
-	Err    error  `json:"error,omitempty"`
+	Status string               `json:"status,omitempty"`
+	Slots  []wttypes.SlotStatus `json:"slots,omitempty"`
+	Err    error                `json:"error,omitempty"`
 }
 
 func (r getStatusResponse) error() error { return r.Err }
 
 func makeGetStatusEndpoint(tws Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		status, err := tws.GetStatus()
-		return getStatusResponse{Status: status, Err: err}, nil
+		status, slots, err := tws.GetStatus()
+		return getStatusResponse{Status: status, Slots: slots, Err: err}, nil
 	}
 }
 
 // CancelTask
 
 type cancelTaskRequest struct {
+	ID string
 }
 
 type cancelTaskResponse struct {
@@ -37,7 +41,8 @@ func (r cancelTaskResponse) error() error { return r.Err }
 
 func makeCancelTaskEndpoint(tws Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		err := tws.CancelTask()
-		return getStatusResponse{Err: err}, nil
+		req := request.(cancelTaskRequest)
+		err := tws.CancelTask(req.ID)
+		return cancelTaskResponse{Err: err}, nil
 	}
 }
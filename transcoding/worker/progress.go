@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// ReadProgress parses the output of ffmpeg "-progress" until EOF, calling
+// report every time ffmpeg writes a block. Duration of the source is in seconds.
+func ReadProgress(r io.Reader, duration float64, report func(wttypes.TranscodingProgress)) error {
+	var p wttypes.TranscodingProgress
+	var outTime, speed float64
+
+	// ffmpeg writes blocks of "key=value" lines ended by "progress=continue|end"
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(kv[1], 64)
+		case "out_time_ms":
+			// Despite the name, it's in microseconds
+			us, err := strconv.ParseFloat(kv[1], 64)
+			if err == nil {
+				outTime = us / 1000000
+			}
+		case "speed":
+			speed, _ = strconv.ParseFloat(strings.TrimSuffix(kv[1], "x"), 64)
+		case "progress":
+			if duration > 0 {
+				p.Percent = outTime * 100 / duration
+				if p.Percent > 100 {
+					p.Percent = 100
+				}
+			}
+
+			p.ETA = 0
+			if speed > 0 && duration > outTime {
+				p.ETA = int((duration - outTime) / speed)
+			}
+
+			if kv[1] == "end" {
+				p.Percent = 100
+				p.ETA = 0
+			}
+
+			report(p)
+		}
+	}
+
+	return scanner.Err()
+}
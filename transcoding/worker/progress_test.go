@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+func TestReadProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		duration float64
+		want     []wttypes.TranscodingProgress
+	}{
+		{
+			name:     "out_time_ms in microseconds",
+			output:   "out_time_ms=2500000\nprogress=continue\nout_time_ms=5000000\nprogress=continue\n",
+			duration: 10,
+			want:     []wttypes.TranscodingProgress{{Percent: 25}, {Percent: 50}},
+		},
+		{
+			name:     "fps and speed",
+			output:   "fps=48.5\nout_time_ms=4000000\nspeed=2.00x\nprogress=continue\n",
+			duration: 10,
+			want:     []wttypes.TranscodingProgress{{Percent: 40, FPS: 48.5, ETA: 3}},
+		},
+		{
+			name:     "past the duration",
+			output:   "out_time_ms=12000000\nspeed=1x\nprogress=continue\n",
+			duration: 10,
+			want:     []wttypes.TranscodingProgress{{Percent: 100}},
+		},
+		{
+			name:     "progress end",
+			output:   "fps=30\nout_time_ms=9000000\nspeed=3x\nprogress=end\n",
+			duration: 10,
+			want:     []wttypes.TranscodingProgress{{Percent: 100, FPS: 30}},
+		},
+		{
+			name:     "unknown duration",
+			output:   "fps=25\nout_time_ms=3000000\nspeed=1.5x\nprogress=continue\nprogress=end\n",
+			duration: 0,
+			want:     []wttypes.TranscodingProgress{{FPS: 25}, {Percent: 100, FPS: 25}},
+		},
+		{
+			name:     "unknown keys and values",
+			output:   "frame=120\nbitrate=N/A\nspeed=N/A\nout_time_ms=N/A\n\nprogress=continue\n",
+			duration: 10,
+			want:     []wttypes.TranscodingProgress{{}},
+		},
+		{
+			name:     "no block",
+			output:   "fps=25\nout_time_ms=3000000\n",
+			duration: 10,
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var got []wttypes.TranscodingProgress
+
+		err := ReadProgress(strings.NewReader(tt.output), tt.duration, func(p wttypes.TranscodingProgress) {
+			got = append(got, p)
+		})
+		if err != nil {
+			t.Errorf("%s: ReadProgress() = %v", tt.name, err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: reported %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
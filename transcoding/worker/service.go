@@ -1,74 +1,136 @@
 package worker
 
 import (
-	"crypto/tls"
 	"fmt"
-	"github.com/go-resty/resty"
 	"net"
 	"os"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"golang.org/x/net/context"
+
+	dbclient "github.com/obazavil/openstack-workload-transcoding/database/client"
+	jobsclient "github.com/obazavil/openstack-workload-transcoding/jobs/client"
+	managerclient "github.com/obazavil/openstack-workload-transcoding/transcoding/manager/client"
+	monitorclient "github.com/obazavil/openstack-workload-transcoding/transcoding/monitor/client"
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
 // Service is the interface that provides transcoding worker methods.
 type Service interface {
-	// Get the status
-	GetStatus() (string, error)
+	// Get the status of the worker and each of its slots
+	GetStatus() (string, []wttypes.SlotStatus, error)
 
-	// Cancel a transcoding task
-	CancelTask() error
+	// Cancel a running transcoding task
+	CancelTask(id string) error
 
 	// No Endpoints (REST API) api for below functions
 
 	WorkerUpdateStatus(status string)
 
-	WorkerUpdateProcess(p *os.Process)
+	SlotStart(slot int, id string)
+
+	SlotUpdateProcess(slot int, p *os.Process)
+
+	SlotCancelled(slot int) bool
+
+	SlotFree(slot int)
+
+	GetSlots() int
 
 	NotifyWorkerStatus(status string)
 
 	NotifyTaskStatus(id string, status string, objectname string)
 
+	NotifyTaskProgress(id string, progress wttypes.TranscodingProgress)
+
+	GetNextTask(wait time.Duration) (wttypes.TranscodingTask, error)
+
+	RetryTask(id string, reason string) (string, error)
+
+	AckTask(id string) (time.Duration, error)
+
+	RenewLease(id string) error
+
+	RegisterWorker() error
+
+	GetCapabilities() wttypes.WorkerCapabilities
+
+	GetProfile(name string) (wttypes.Profile, error)
+
+	GetTranscoding(id string) (wttypes.TranscodingTask, error)
+
 	GetIP() string
 }
 
-type service struct {
-	mtx     sync.RWMutex
-	status  string
-	process *os.Process
-	ip      string
+// slot is a place where a transcoding task runs
+type slot struct {
+	taskID    string
+	process   *os.Process
+	cancelled bool
+}
 
-	jobs    string
-	manager string
-	monitor string
+type service struct {
+	mtx    sync.RWMutex
+	status string
+	slots  []slot
+	ip     string
+	caps   wttypes.WorkerCapabilities
+
+	profiles *wtcommon.ProfileCache
+
+	database *dbclient.Client
+	jobs     *jobsclient.Client
+	manager  *managerclient.Client
+	monitor  *monitorclient.Client
 }
 
-func (s *service) GetStatus() (string, error) {
+func (s *service) GetStatus() (string, []wttypes.SlotStatus, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 
-	return s.status, nil
+	slots := []wttypes.SlotStatus{}
+	for i, v := range s.slots {
+		st := wttypes.SlotStatus{
+			Slot:   i,
+			TaskID: v.taskID,
+			Status: wttypes.WORKER_STATUS_IDLE,
+		}
+		if v.taskID != "" {
+			st.Status = wttypes.WORKER_STATUS_BUSY
+		}
+		slots = append(slots, st)
+	}
+
+	return s.status, slots, nil
 }
 
-func (s *service) CancelTask() error {
+func (s *service) CancelTask(id string) error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	fmt.Println("cancelling task...")
+	fmt.Println("cancelling task...", id)
 
-	if s.status == wttypes.WORKER_STATUS_IDLE {
-		return wttypes.ErrNoTaskRunning
-	}
+	for i, v := range s.slots {
+		if v.taskID != id {
+			continue
+		}
 
-	if s.process == nil {
-		return wttypes.ErrNoProcessRunning
-	}
+		// Between ffmpeg runs (downloading, uploading) the slot stops at its next step
+		s.slots[i].cancelled = true
+
+		if v.process != nil {
+			v.process.Signal(syscall.SIGTERM)
+		}
 
-	s.process.Signal(syscall.SIGTERM)
+		return nil
+	}
 
-	return nil
+	return wttypes.ErrNoTaskRunning
 }
 
 // No Endpoints (REST API) api for below functions
@@ -81,10 +143,65 @@ func (s *service) WorkerUpdateStatus(status string) {
 	s.NotifyWorkerStatus(status)
 }
 
-func (s *service) WorkerUpdateProcess(p *os.Process) {
+func (s *service) SlotStart(slot int, id string) {
+	s.mtx.Lock()
+	s.slots[slot].taskID = id
+	s.slots[slot].process = nil
+	s.slots[slot].cancelled = false
+	s.mtx.Unlock()
+
+	s.updateStatusFromSlots()
+}
+
+func (s *service) SlotUpdateProcess(slot int, p *os.Process) {
+	s.mtx.Lock()
+	s.slots[slot].process = p
+
+	// Task was cancelled before this process started, it doesn't get to run
+	if s.slots[slot].cancelled && p != nil {
+		p.Signal(syscall.SIGTERM)
+	}
+	s.mtx.Unlock()
+}
+
+func (s *service) SlotCancelled(slot int) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.slots[slot].cancelled
+}
+
+func (s *service) SlotFree(slot int) {
 	s.mtx.Lock()
-	s.process = p
+	s.slots[slot].taskID = ""
+	s.slots[slot].process = nil
+	s.slots[slot].cancelled = false
 	s.mtx.Unlock()
+
+	s.updateStatusFromSlots()
+}
+
+func (s *service) GetSlots() int {
+	return len(s.slots)
+}
+
+// updateStatusFromSlots sets worker as busy while any slot is running a task
+func (s *service) updateStatusFromSlots() {
+	status := wttypes.WORKER_STATUS_IDLE
+
+	s.mtx.RLock()
+	for _, v := range s.slots {
+		if v.taskID != "" {
+			status = wttypes.WORKER_STATUS_BUSY
+			break
+		}
+	}
+	changed := status != s.status
+	s.mtx.RUnlock()
+
+	if changed {
+		s.WorkerUpdateStatus(status)
+	}
 }
 
 func (s *service) NotifyWorkerStatus(status string) {
@@ -97,78 +214,136 @@ func (s *service) NotifyWorkerStatus(status string) {
 	}
 	fmt.Println("[main] worker status", st)
 
-	resp, err := resty.R().
-		SetBody(st).
-		Put(fmt.Sprintf("%s/workers/status",
-			s.monitor))
-
+	err := s.monitor.UpdateWorkerStatus(context.Background(), st)
 	if err != nil {
 		fmt.Println("[worker] notify worker err:", err)
 		//TODO: do something when status update fails
 	}
-
-	str := resp.String()
-	if strings.HasPrefix(str, `{"error"`) {
-		fmt.Println("[worker] notify worker err:", err)
-		//TODO: do something when status update fails
-	}
 }
 
 func (s *service) NotifyTaskStatus(id string, status string, objectname string) {
 	fmt.Println("[worker] notifyTaskStatus:", id, status, objectname)
-	// Update Manager Service
-	bodyM := struct {
-		Status string `json:"status"`
-	}{
-		Status: status,
-	}
 
-	fmt.Println("[main] statusM", bodyM.Status)
-	resp, err := resty.R().
-		SetBody(bodyM).
-		Put(fmt.Sprintf("%s/tasks/%s/status",
-			s.manager,
-			id))
+	// Jobs first, so the object name is stored before manager queues the tasks depending on this one
+	s.notifyJobsTaskStatus(id, status, objectname)
+
+	s.notifyManagerTaskStatus(id, status)
 
+	fmt.Println("notified manager:", id, status)
+}
+
+// notifyManagerTaskStatus updates Manager Service
+func (s *service) notifyManagerTaskStatus(id string, status string) {
+	fmt.Println("[main] statusM", status)
+	err := s.manager.UpdateTaskStatus(context.Background(), id, s.ip, status)
 	if err != nil {
 		fmt.Println("[worker] notify task err:", err)
 		//TODO: do something when status update fails
 	}
+}
 
-	str := resp.String()
-	if strings.HasPrefix(str, `{"error"`) {
-		fmt.Println("[worker] notify task err:", err)
+// notifyJobsTaskStatus updates Jobs Service
+func (s *service) notifyJobsTaskStatus(id string, status string, objectname string) {
+	fmt.Println("[main] statusJ", status)
+	err := s.jobs.UpdateTranscodingStatus(context.Background(), id, status, objectname)
+	if err != nil {
+		fmt.Println("[worker] notify err:", err)
 		//TODO: do something when status update fails
 	}
+}
+
+func (s *service) NotifyTaskProgress(id string, progress wttypes.TranscodingProgress) {
+	fmt.Println("[worker] notifyTaskProgress:", id, progress)
 
-	fmt.Println("notified manager and jobs:", id, status, objectname)
+	ctx := context.Background()
+
+	// Update Manager Service
+	err := s.manager.UpdateTaskProgress(ctx, id, s.ip, progress)
+	if err != nil {
+		fmt.Println("[worker] notify progress err:", err)
+	}
 
 	// Update Jobs Service
-	bodyJ := struct {
-		Status     string `json:"status"`
-		ObjectName string `json:"object_name,omitempty"`
-	}{
-		Status:     status,
-		ObjectName: objectname,
+	err = s.jobs.UpdateTranscodingProgress(ctx, id, progress)
+	if err != nil {
+		fmt.Println("[worker] notify progress err:", err)
 	}
+}
 
-	fmt.Println("[main] statusJ", bodyJ.Status)
-	resp, err = resty.R().
-		SetBody(bodyJ).
-		Put(fmt.Sprintf("%s/transcodings/%s/status",
-			s.jobs,
-			id))
+func (s *service) GetNextTask(wait time.Duration) (wttypes.TranscodingTask, error) {
+	// Ask Manager Service for work we are capable of
+	caps := s.GetCapabilities()
 
+	return s.manager.GetNextTask(context.Background(), s.ip, &caps, wait)
+}
+
+func (s *service) RetryTask(id string, reason string) (string, error) {
+	fmt.Println("[worker] retryTask:", id, reason)
+
+	// Ask Manager Service to re-queue the task (it decides if it's worth it)
+	status, err := s.manager.RetryTask(context.Background(), id, s.ip, reason)
 	if err != nil {
-		fmt.Println("[worker] notify err:", err)
-		//TODO: do something when status update fails
+		return "", err
 	}
 
-	str = resp.String()
-	if strings.HasPrefix(str, `{"error"`) {
-		fmt.Println("[worker] notify err:", err)
-		//TODO: do something when status update fails
+	// Manager already knows, only Jobs Service needs the new status
+	s.notifyJobsTaskStatus(id, status, "")
+
+	return status, nil
+}
+
+func (s *service) AckTask(id string) (time.Duration, error) {
+	fmt.Println("[worker] ackTask:", id)
+
+	// Tell Manager Service we got the task, otherwise it will be re-queued
+	return s.manager.AckTask(context.Background(), id, s.ip)
+}
+
+func (s *service) RenewLease(id string) error {
+	// Tell Manager Service we are still working on the task
+	return s.manager.RenewLease(context.Background(), id, s.ip)
+}
+
+func (s *service) RegisterWorker() error {
+	s.mtx.Lock()
+	s.status = wttypes.WORKER_STATUS_ONLINE
+	s.mtx.Unlock()
+
+	// Register in Monitor Service with our capabilities
+	caps := s.GetCapabilities()
+	fmt.Println("[worker] registerWorker:", s.ip, caps)
+
+	return s.monitor.RegisterWorker(context.Background(), s.ip, &caps)
+}
+
+func (s *service) GetCapabilities() wttypes.WorkerCapabilities {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// Free disk changes with every task
+	s.caps.DiskFree = diskFree(os.TempDir())
+
+	return s.caps
+}
+
+func (s *service) GetProfile(name string) (wttypes.Profile, error) {
+	p, err := s.profiles.Get(name)
+	if err != nil {
+		return wttypes.Profile{}, err
 	}
+
+	// Profiles stored before the current rules (e.g. filters reading files) are not run
+	err = p.Validate()
+	if err != nil {
+		return wttypes.Profile{}, err
+	}
+
+	return p, nil
+}
+
+// GetTranscoding asks database service for a transcoding, e.g. to know where its output is
+func (s *service) GetTranscoding(id string) (wttypes.TranscodingTask, error) {
+	return s.database.GetTranscoding(context.Background(), id)
 }
 
 func (s *service) GetIP() string {
@@ -190,21 +365,33 @@ func getOutboundIP() (string, error) {
 }
 
 // NewService creates a transcoding worker service with necessary dependencies.
-func NewService(jobs, manager, monitor string) (Service, error) {
-	resty.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-
+func NewService(database, jobs, manager, monitor string, maxResolution string, slots int) (Service, error) {
 	ip, err := getOutboundIP()
 	if err != nil {
 		return &service{}, err
 	}
 
+	if slots < 1 {
+		return &service{}, wttypes.ErrInvalidArgument
+	}
+
+	caps, err := detectCapabilities(maxResolution, slots)
+	if err != nil {
+		return &service{}, err
+	}
+
 	return &service{
 		mtx:    sync.RWMutex{},
 		status: wttypes.WORKER_STATUS_IDLE,
+		slots:  make([]slot, slots),
 		ip:     ip,
+		caps:   caps,
+
+		profiles: wtcommon.NewProfileCache(database, wtcommon.PROFILE_CACHE_TTL),
 
-		jobs:    jobs,
-		manager: manager,
-		monitor: monitor,
+		database: dbclient.New(database, wtclient.TIMEOUT),
+		jobs:     jobsclient.New(jobs, wtclient.TIMEOUT),
+		manager:  managerclient.New(manager, wtclient.TIMEOUT),
+		monitor:  monitorclient.New(monitor, wtclient.TIMEOUT),
 	}, nil
 }
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// StitchMedia joins the chunks transcoded with profile p, in order, into output.
+// Chunks aren't transcoded again, started gets the ffmpeg process for cancellation.
+func StitchMedia(chunks []string, output string, p wttypes.Profile, started func(*os.Process)) error {
+	// List of chunks for the concat demuxer, quotes escaped
+	var list bytes.Buffer
+	for _, v := range chunks {
+		fmt.Fprintf(&list, "file '%s'\n", strings.Replace(v, "'", `'\''`, -1))
+	}
+
+	fnList := output + ".txt"
+	err := ioutil.WriteFile(fnList, list.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fnList)
+
+	return runFFMPEG(started, p.ConcatArgs(fnList, output)...)
+}
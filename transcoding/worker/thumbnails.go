@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// GenerateThumbnails extracts the thumbnails of a media file (duration in seconds) into dir:
+// a poster, every thumbnail, the sprite sheets with them and a WebVTT track pointing into the sheets.
+// Started is called with every ffmpeg process, so it can be cancelled.
+func GenerateThumbnails(input, dir string, opts wttypes.ThumbnailOptions, duration float64, started func(*os.Process)) error {
+	size := strconv.Itoa(opts.Width) + ":" + strconv.Itoa(opts.Height)
+
+	// Every thumbnail with the same size, so they fit in the sprite sheets
+	scale := "scale=" + size + ":force_original_aspect_ratio=decrease,pad=" + size + ":(ow-iw)/2:(oh-ih)/2"
+	thumbs := path.Join(dir, "thumb_%05d.jpg")
+
+	// Poster frame, full size
+	poster := opts.Poster
+	if poster == 0 || poster >= duration {
+		poster = duration / 10
+	}
+	err := runFFMPEG(started, "-ss", formatSeconds(poster), "-i", input, "-frames:v", "1", path.Join(dir, wttypes.THUMBNAILS_POSTER))
+	if err != nil {
+		return err
+	}
+
+	// Thumbnails, in a single pass when at intervals
+	times := opts.Timestamps
+	if opts.Interval > 0 {
+		interval := opts.Interval
+		if duration/interval > wttypes.THUMBNAILS_MAX {
+			interval = duration / wttypes.THUMBNAILS_MAX
+		}
+
+		err = runFFMPEG(started, "-i", input, "-vf", "fps=1/"+formatSeconds(interval)+","+scale, "-q:v", "3", thumbs)
+		if err != nil {
+			return err
+		}
+
+		times = []float64{}
+		for t := 0.0; t < duration; t += interval {
+			times = append(times, t)
+		}
+	} else {
+		for i, t := range times {
+			err = runFFMPEG(started, "-ss", formatSeconds(t), "-i", input, "-frames:v", "1", "-vf", scale, "-q:v", "3", fmt.Sprintf(thumbs, i+1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// ffmpeg may write one frame more or less than expected at the end
+	files, err := filepath.Glob(path.Join(dir, "thumb_*.jpg"))
+	if err != nil {
+		return err
+	}
+	if len(files) < len(times) {
+		times = times[:len(files)]
+	}
+	if len(times) == 0 {
+		return wttypes.ErrMediaUnreadable
+	}
+
+	// Sprite sheets, each one with columns x rows thumbnails
+	tile := "tile=" + strconv.Itoa(opts.Columns) + "x" + strconv.Itoa(opts.Rows)
+	err = runFFMPEG(started, "-i", thumbs, "-vf", tile, "-q:v", "3", path.Join(dir, "sprite_%03d.jpg"))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dir, wttypes.THUMBNAILS_VTT), thumbnailsVTT(opts, times, duration), 0644)
+}
+
+// thumbnailsVTT returns a WebVTT track with the region of the sprite sheets showing every thumbnail
+func thumbnailsVTT(opts wttypes.ThumbnailOptions, times []float64, duration float64) []byte {
+	perSheet := opts.Columns * opts.Rows
+
+	vtt := "WEBVTT\n"
+	for i, start := range times {
+		end := duration
+		if i+1 < len(times) {
+			end = times[i+1]
+		}
+
+		pos := i % perSheet
+		x := (pos % opts.Columns) * opts.Width
+		y := (pos / opts.Columns) * opts.Height
+
+		// Sprite sheets are numbered by ffmpeg starting at 1
+		vtt += fmt.Sprintf("\n%s --> %s\nsprite_%03d.jpg#xywh=%d,%d,%d,%d\n",
+			formatVTTTime(start), formatVTTTime(end),
+			i/perSheet+1,
+			x, y, opts.Width, opts.Height)
+	}
+
+	return []byte(vtt)
+}
+
+// formatVTTTime returns seconds as "hh:mm:ss.mmm"
+func formatVTTTime(seconds float64) string {
+	ms := int64(math.Floor(seconds*1000 + 0.5))
+
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// runFFMPEG runs ffmpeg until it finishes, overwriting outputs
+func runFFMPEG(started func(*os.Process), args ...string) error {
+	cmd := exec.Command("ffmpeg", append([]string{"-v", "error", "-y"}, args...)...)
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	started(cmd.Process)
+
+	return cmd.Wait()
+}
@@ -10,6 +10,7 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 
+	"github.com/obazavil/openstack-workload-transcoding/wtcommon"
 	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
@@ -29,7 +30,7 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 		opts...,
 	)
 
-	// test: curl -k -X DELETE https://localhost:8083/tasks
+	// test: curl -k -X DELETE https://localhost:8083/tasks/1
 	cancelTaskHandler := kithttp.NewServer(
 		ctx,
 		makeCancelTaskEndpoint(tms),
@@ -41,7 +42,7 @@ func MakeHandler(ctx context.Context, tms Service, logger kitlog.Logger) http.Ha
 	r := mux.NewRouter()
 
 	r.Handle("/worker/status", getStatusHandler).Methods("GET")
-	r.Handle("/tasks", cancelTaskHandler).Methods("DELETE")
+	r.Handle("/tasks/{id}", cancelTaskHandler).Methods("DELETE")
 
 	return r
 }
@@ -51,7 +52,14 @@ func decodeGetStatusRequest(_ context.Context, r *http.Request) (interface{}, er
 }
 
 func decodeCancelTaskRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return cancelTaskRequest{}, nil
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		return nil, wttypes.ErrBadRoute
+	}
+
+	return cancelTaskRequest{ID: id}, nil
 }
 
 type errorer interface {
@@ -69,18 +77,5 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 
 // encode errors from business-logic
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	switch err {
-	case wttypes.ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case wttypes.ErrInvalidArgument:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	wtcommon.EncodeError(err, w)
 }
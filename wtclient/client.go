@@ -0,0 +1,136 @@
+package wtclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// How long a call to a service can take by default
+const TIMEOUT = 30 * time.Second
+
+// Services use self-signed certificates
+var transport = &http.Transport{
+	Proxy:           http.ProxyFromEnvironment,
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+}
+
+// Client sends requests to a service (https://server:port), decoding its
+// errors back into wttypes errors (*wttypes.Error)
+type Client struct {
+	URL     string
+	Timeout time.Duration
+
+	http *http.Client
+}
+
+// Call sends a request to the service, body and result are encoded as JSON (when not nil)
+func (c *Client) Call(ctx context.Context, method, path string, body, result interface{}) error {
+	return c.CallTimeout(ctx, c.Timeout, method, path, body, result)
+}
+
+// CallTimeout is like Call, but the request can take up to timeout, e.g. when the service holds it
+func (c *Client) CallTimeout(ctx context.Context, timeout time.Duration, method, path string, body, result interface{}) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// There was an error in the response?
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp.StatusCode, data)
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, result); err != nil {
+		return wttypes.ErrNoJSON
+	}
+
+	return nil
+}
+
+// decodeError gets back the error the service answered with
+func decodeError(status int, data []byte) error {
+	var v struct {
+		Error   string            `json:"error"`
+		Code    string            `json:"code"`
+		Details map[string]string `json:"details"`
+	}
+
+	// Not answered by a service, e.g. a proxy in between
+	if err := json.Unmarshal(data, &v); err != nil || v.Error == "" {
+		return &wttypes.Error{
+			Code:    wttypes.ErrInternal.Code,
+			Status:  status,
+			Message: http.StatusText(status),
+		}
+	}
+
+	// Known errors are the same ones, so they can be compared (internal ones keep their message)
+	if e := wttypes.ErrorFromCode(v.Code); e != nil && e != wttypes.ErrInternal {
+		if len(v.Details) == 0 {
+			return e
+		}
+		return e.WithDetails(v.Details)
+	}
+
+	return &wttypes.Error{
+		Code:    v.Code,
+		Status:  status,
+		Message: v.Error,
+		Details: v.Details,
+	}
+}
+
+// New creates a client of the service at url, calls take up to timeout (0 for no limit)
+func New(url string, timeout time.Duration) *Client {
+	return &Client{
+		URL:     url,
+		Timeout: timeout,
+
+		http: &http.Client{Transport: transport},
+	}
+}
@@ -0,0 +1,164 @@
+package wtcommon
+
+import (
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/rackspace/gophercloud"
+	"github.com/rackspace/gophercloud/openstack/objectstorage/v1/objects"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// Containers and video codecs we know ffmpeg in the workers can read
+var (
+	supportedContainers  = []string{"mov", "mp4", "matroska", "webm", "avi", "mpegts", "mpeg", "flv", "asf", "ogg"}
+	supportedVideoCodecs = []string{"h264", "hevc", "mpeg4", "mpeg2video", "mpeg1video", "vp8", "vp9", "av1", "theora", "wmv3", "vc1", "prores", "mjpeg", "flv1"}
+)
+
+// ffprobeOutput is the part of "ffprobe -of json" output we care about
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType  string            `json:"codec_type"`
+		CodecName  string            `json:"codec_name"`
+		Width      int               `json:"width"`
+		Height     int               `json:"height"`
+		Channels   int               `json:"channels"`
+		SampleRate string            `json:"sample_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// ProbeMedia inspects a media file using ffprobe
+func ProbeMedia(filename string) (wttypes.MediaInfo, error) {
+	return probe(nil, filename)
+}
+
+// ProbeObject inspects a media in object storage using ffprobe. The object is
+// streamed into its stdin, so the token isn't in its command line and the
+// download stops once ffprobe read what it needs.
+func ProbeObject(service *gophercloud.ServiceClient, objectName string, containerName string) (wttypes.MediaInfo, error) {
+	res := objects.Download(service, containerName, objectName, nil)
+	if res.Err != nil {
+		return wttypes.MediaInfo{}, res.Err
+	}
+	defer res.Body.Close()
+
+	body := &errorReader{r: res.Body}
+
+	info, err := probe(body, "pipe:0")
+
+	// Object storage failing isn't the media being unreadable
+	if body.err != nil {
+		return wttypes.MediaInfo{}, body.err
+	}
+
+	return info, err
+}
+
+// errorReader keeps the first error of r other than io.EOF
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+
+	return n, err
+}
+
+func probe(stdin io.Reader, input string) (wttypes.MediaInfo, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-show_format", "-show_streams", "-of", "json", input)
+	cmd.Stdin = stdin
+
+	out, err := cmd.Output()
+	if err != nil {
+		// ffprobe fails when it can't make sense of the file
+		if _, ok := err.(*exec.ExitError); ok {
+			return wttypes.MediaInfo{}, wttypes.ErrMediaUnreadable
+		}
+		return wttypes.MediaInfo{}, err
+	}
+
+	var v ffprobeOutput
+	if err := json.Unmarshal(out, &v); err != nil {
+		return wttypes.MediaInfo{}, wttypes.ErrMediaUnreadable
+	}
+
+	info := wttypes.MediaInfo{
+		Container: v.Format.FormatName,
+	}
+	info.Duration, _ = strconv.ParseFloat(v.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(v.Format.BitRate, 10, 64)
+
+	for _, s := range v.Streams {
+		switch s.CodecType {
+		case "video":
+			// First video stream is the one ffmpeg will use
+			if info.VideoCodec != "" {
+				continue
+			}
+			info.VideoCodec = s.CodecName
+			info.Width = s.Width
+			info.Height = s.Height
+		case "audio":
+			sampleRate, _ := strconv.Atoi(s.SampleRate)
+			info.AudioTracks = append(info.AudioTracks, wttypes.AudioTrack{
+				Codec:      s.CodecName,
+				Channels:   s.Channels,
+				SampleRate: sampleRate,
+				Language:   s.Tags["language"],
+			})
+		}
+	}
+
+	return info, nil
+}
+
+// ValidateMedia verifies the media can be transcoded by the workers
+func ValidateMedia(info wttypes.MediaInfo) error {
+	if info.VideoCodec == "" {
+		return wttypes.ErrMediaNoVideo
+	}
+
+	if info.Duration <= 0 || info.Width == 0 || info.Height == 0 {
+		return wttypes.ErrMediaUnreadable
+	}
+
+	// ffprobe reports every name of a format, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	supported := false
+	for _, name := range strings.Split(info.Container, ",") {
+		if inSlice(name, supportedContainers) {
+			supported = true
+			break
+		}
+	}
+
+	if !supported || !inSlice(info.VideoCodec, supportedVideoCodecs) {
+		return wttypes.ErrMediaUnsupported
+	}
+
+	return nil
+}
+
+func inSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,6 +1,8 @@
 package wtcommon
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,20 +10,30 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/rackspace/gophercloud"
 	"github.com/rackspace/gophercloud/openstack"
 	"github.com/rackspace/gophercloud/openstack/objectstorage/v1/objects"
+	"github.com/rackspace/gophercloud/pagination"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
 // constants with the name of the containers for jobs
 const (
 	SOURCE_MEDIA_CONTAINER     = "media-source"
 	TRANSCODED_MEDIA_CONTAINER = "media-transcoding"
+
+	// Chunks of resumable uploads, until they are a large object in SOURCE_MEDIA_CONTAINER
+	SEGMENTS_CONTAINER = "media-source-segments"
 )
 
+// Most segments Swift takes in a large object manifest (max_manifest_segments)
+const MAX_MANIFEST_SEGMENTS = 1000
+
 // getProvider returns the provider
 func GetProvider() (*gophercloud.ProviderClient, error) {
 	// Get authentication info
@@ -89,29 +101,43 @@ func IsValidURL(url2check string) bool {
 	return false
 }
 
-// Upload2ObjectStorage uploads the media (url or file) into object storage
-func Upload2ObjectStorage(service *gophercloud.ServiceClient, mediaPath string, filename string, containerName string) (string, error) {
-	var fn string
-
+// GetLocalMedia returns a local file with the media (url or file), downloading it if needed.
+// When temporary is true the caller must remove the file once done with it.
+func GetLocalMedia(mediaPath string) (fn string, temporary bool, err error) {
 	// If is a URL let's download it
 	if IsValidURL(mediaPath) {
-		// Download file from URL
-		fn, err := downloadFile(mediaPath)
-		if fn != "" {
-			defer os.Remove(fn)
-		}
-
+		fn, err = downloadFile(mediaPath)
 		if err != nil {
-			return "", err
+			os.Remove(fn)
+			return "", false, err
 		}
-	} else { // File, let's verify it exists
-		fn = mediaPath
 
-		// Validate file exists
-		_, err := os.Stat(fn)
-		if err != nil {
-			return "", err
-		}
+		return fn, true, nil
+	}
+
+	// File, let's verify it exists
+	_, err = os.Stat(mediaPath)
+	if err != nil {
+		return "", false, err
+	}
+
+	return mediaPath, false, nil
+}
+
+// objectName returns a unique object name for filename, keeping its extension
+func objectName(filename string) string {
+	ext := path.Ext(filename)
+	return fmt.Sprintf("%s-%d%s", filename[:len(filename)-len(ext)], time.Now().UnixNano(), ext)
+}
+
+// Upload2ObjectStorage uploads the media (url or file) into object storage
+func Upload2ObjectStorage(service *gophercloud.ServiceClient, mediaPath string, filename string, containerName string) (string, error) {
+	fn, temporary, err := GetLocalMedia(mediaPath)
+	if err != nil {
+		return "", err
+	}
+	if temporary {
+		defer os.Remove(fn)
 	}
 
 	// Open file for reading
@@ -122,9 +148,12 @@ func Upload2ObjectStorage(service *gophercloud.ServiceClient, mediaPath string,
 	defer f.Close()
 
 	// Upload to Object Storage
-	ext := path.Ext(filename)
-	name := fmt.Sprintf("%s-%d%s", filename[:len(filename)-len(ext)], time.Now().UnixNano(), ext)
-	res := objects.Create(service, containerName, name, f, nil)
+	name := objectName(filename)
+	// Set the content type so it can be served directly
+	opts := objects.CreateOpts{
+		ContentType: wttypes.ContentType(name),
+	}
+	res := objects.Create(service, containerName, name, f, opts)
 	_, err = res.ExtractHeader()
 	if err != nil {
 		return "", err
@@ -133,12 +162,241 @@ func Upload2ObjectStorage(service *gophercloud.ServiceClient, mediaPath string,
 	return name, nil
 }
 
-func DownloadFromObjectStorage(service *gophercloud.ServiceClient, objectName, filename string) error {
-	// Save object
-	res := objects.Download(service, SOURCE_MEDIA_CONTAINER, objectName, nil)
-	content, err := res.ExtractContent()
+// Stream2ObjectStorage uploads the media read from r into object storage as it's read,
+// without keeping it anywhere (objects.Create needs to seek it)
+func Stream2ObjectStorage(service *gophercloud.ServiceClient, r io.Reader, filename string, containerName string) (string, error) {
+	name := objectName(filename)
+
+	_, err := putObject(service, ObjectURL(service, name, containerName), r, wttypes.ContentType(name))
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Segment2ObjectStorage uploads a chunk read from r as the segment objectName of a large object
+func Segment2ObjectStorage(service *gophercloud.ServiceClient, r io.Reader, objectName string, containerName string) (wttypes.UploadSegment, error) {
+	cr := &countingReader{r: r}
+
+	etag, err := putObject(service, ObjectURL(service, objectName, containerName), cr, "application/octet-stream")
+	if err != nil {
+		return wttypes.UploadSegment{}, err
+	}
+
+	return wttypes.UploadSegment{
+		ObjectName: objectName,
+		Size:       cr.n,
+		ETag:       etag,
+	}, nil
+}
+
+// LargeObject2ObjectStorage creates a Static Large Object with the segments (in SEGMENTS_CONTAINER),
+// so media over the biggest object Swift takes can be stored. Unlike a Dynamic one, its manifest
+// lists every segment with its ETag, so leftovers of failed chunks can't slip in.
+func LargeObject2ObjectStorage(service *gophercloud.ServiceClient, segments []wttypes.UploadSegment, filename string, containerName string) (string, error) {
+	name := objectName(filename)
+
+	// Too many segments for one manifest, they go into manifests of their own (nested SLO)
+	entries := manifestEntries(segments)
+	for i := 0; len(entries) > MAX_MANIFEST_SEGMENTS; i++ {
+		nested := []manifestEntry{}
+		for j := 0; j < len(entries); j += MAX_MANIFEST_SEGMENTS {
+			end := j + MAX_MANIFEST_SEGMENTS
+			if end > len(entries) {
+				end = len(entries)
+			}
+
+			part := fmt.Sprintf("%s/manifest%d-%05d", name, i, j/MAX_MANIFEST_SEGMENTS)
+			err := putManifest(service, entries[j:end], part, SEGMENTS_CONTAINER, "application/octet-stream")
+			if err != nil {
+				return "", err
+			}
+
+			nested = append(nested, manifestEntry{Path: "/" + SEGMENTS_CONTAINER + "/" + part})
+		}
+		entries = nested
+	}
+
+	err := putManifest(service, entries, name, containerName, wttypes.ContentType(name))
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// DeleteLargeObject removes a Static Large Object from object storage, along with its segments
+func DeleteLargeObject(service *gophercloud.ServiceClient, objectName string, containerName string) error {
+	req, err := http.NewRequest("DELETE", ObjectURL(service, objectName, containerName)+"?multipart-manifest=delete", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", service.TokenID)
+
+	resp, err := service.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("object storage answered %s", resp.Status)
+	}
+
+	return nil
+}
+
+// manifestEntry is a segment in the manifest of a Static Large Object, nested
+// manifests have no ETag nor size (Swift works them out)
+type manifestEntry struct {
+	Path      string `json:"path"`
+	ETag      string `json:"etag,omitempty"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+}
+
+func manifestEntries(segments []wttypes.UploadSegment) []manifestEntry {
+	entries := []manifestEntry{}
+	for _, v := range segments {
+		entries = append(entries, manifestEntry{
+			Path:      "/" + SEGMENTS_CONTAINER + "/" + v.ObjectName,
+			ETag:      v.ETag,
+			SizeBytes: v.Size,
+		})
+	}
+
+	return entries
+}
+
+func putManifest(service *gophercloud.ServiceClient, entries []manifestEntry, objectName string, containerName string, contentType string) error {
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	_, err = putObject(service, ObjectURL(service, objectName, containerName)+"?multipart-manifest=put", bytes.NewReader(b), contentType)
+
+	return err
+}
+
+// putObject uploads the content read from r into an object, returning its ETag.
+// Size is not needed, the content goes chunked when unknown.
+func putObject(service *gophercloud.ServiceClient, url string, r io.Reader, contentType string) (string, error) {
+	req, err := http.NewRequest("PUT", url, r)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("X-Auth-Token", service.TokenID)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := service.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("object storage answered %s", resp.Status)
+	}
+
+	return strings.Trim(resp.Header.Get("Etag"), "\""), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// DeleteFromObjectStorage removes an object from object storage
+func DeleteFromObjectStorage(service *gophercloud.ServiceClient, objectName string, containerName string) error {
+	return objects.Delete(service, containerName, objectName, nil).Err
+}
+
+// DeletePrefixFromObjectStorage removes every object whose name starts with prefix
+// from object storage, returns how many were removed
+func DeletePrefixFromObjectStorage(service *gophercloud.ServiceClient, prefix string, containerName string) (int, error) {
+	names := []string{}
+	err := objects.List(service, containerName, objects.ListOpts{Prefix: prefix}).EachPage(func(page pagination.Page) (bool, error) {
+		v, err := objects.ExtractNames(page)
+		if err != nil {
+			return false, err
+		}
+		names = append(names, v...)
+
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, name := range names {
+		err = DeleteFromObjectStorage(service, name, containerName)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(names), nil
+}
+
+// UploadDir2ObjectStorage uploads every file inside dir into object storage, named under prefix
+func UploadDir2ObjectStorage(service *gophercloud.ServiceClient, dir string, prefix string, containerName string) error {
+	return filepath.Walk(dir, func(fn string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+
+		// Open file for reading
+		f, err := os.Open(fn)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Upload to Object Storage
+		opts := objects.CreateOpts{
+			ContentType: wttypes.ContentType(fn),
+		}
+		res := objects.Create(service, containerName, prefix+"/"+filepath.ToSlash(rel), f, opts)
+		_, err = res.ExtractHeader()
+
+		return err
+	})
+}
+
+// ObjectURL returns the URL of an object in object storage
+func ObjectURL(service *gophercloud.ServiceClient, objectName string, containerName string) string {
+	return service.ServiceURL(containerName, objectName)
+}
+
+func DownloadFromObjectStorage(service *gophercloud.ServiceClient, objectName, filename string, containerName string) error {
+	// Save object, streamed so large objects don't end up in memory
+	res := objects.Download(service, containerName, objectName, nil)
+	if res.Err != nil {
+		return res.Err
+	}
+	defer res.Body.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	err = ioutil.WriteFile(filename, []byte(content), 0644)
+	_, err = io.Copy(f, res.Body)
 
 	return err
 }
@@ -0,0 +1,129 @@
+package wtcommon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/obazavil/openstack-workload-transcoding/database/client"
+	"github.com/obazavil/openstack-workload-transcoding/wtclient"
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
+)
+
+// How long profiles are kept before asking database service again
+const PROFILE_CACHE_TTL = 5 * time.Minute
+
+// ProfileCache resolves transcoding profiles from database service,
+// keeping them for a while so we don't ask for them on every task
+type ProfileCache struct {
+	mtx      sync.Mutex
+	database *client.Client
+	ttl      time.Duration
+	profiles map[string]wttypes.Profile
+	fetched  time.Time
+}
+
+// List returns all the profiles by name
+func (c *ProfileCache) List() (map[string]wttypes.Profile, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// No database service, only the built-in profiles
+	if c.database == nil {
+		return wttypes.NewProfile(), nil
+	}
+
+	if c.profiles != nil && time.Since(c.fetched) < c.ttl {
+		return c.profiles, nil
+	}
+
+	profiles, err := c.fetch()
+	if err != nil {
+		// Better an old copy than nothing
+		if c.profiles != nil {
+			fmt.Println("[err] profiles: using cached copy:", err)
+			return c.profiles, nil
+		}
+
+		return nil, err
+	}
+
+	c.profiles = profiles
+	c.fetched = time.Now()
+
+	return c.profiles, nil
+}
+
+// Get returns a profile by name
+func (c *ProfileCache) Get(name string) (wttypes.Profile, error) {
+	profiles, err := c.List()
+	if err != nil {
+		return wttypes.Profile{}, wttypes.Retryable(err)
+	}
+
+	p, ok := profiles[name]
+	if !ok {
+		// It could have been added since they were fetched
+		profiles, err = c.refresh()
+		if err != nil {
+			return wttypes.Profile{}, wttypes.Retryable(err)
+		}
+
+		p, ok = profiles[name]
+		if !ok {
+			return wttypes.Profile{}, wttypes.ErrProfileNotFound
+		}
+	}
+
+	return p, nil
+}
+
+// refresh fetches the profiles again, however long they were kept
+func (c *ProfileCache) refresh() (map[string]wttypes.Profile, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.database == nil {
+		return wttypes.NewProfile(), nil
+	}
+
+	profiles, err := c.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	c.profiles = profiles
+	c.fetched = time.Now()
+
+	return c.profiles, nil
+}
+
+func (c *ProfileCache) fetch() (map[string]wttypes.Profile, error) {
+	list, err := c.database.ListProfiles(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]wttypes.Profile)
+	for _, v := range list {
+		profiles[v.Name] = v
+	}
+
+	return profiles, nil
+}
+
+// NewProfileCache creates a cache of the profiles in database service,
+// without database service only the built-in profiles are known
+func NewProfileCache(database string, ttl time.Duration) *ProfileCache {
+	c := &ProfileCache{
+		ttl: ttl,
+	}
+
+	if database != "" {
+		c.database = client.New(database, wtclient.TIMEOUT)
+	}
+
+	return c
+}
@@ -1,7 +1,10 @@
 package wtcommon
 
 import (
+	"encoding/json"
 	"net/http"
+
+	"github.com/obazavil/openstack-workload-transcoding/wttypes"
 )
 
 const (
@@ -27,3 +30,17 @@ func AccessControl(h http.Handler) http.Handler {
 		h.ServeHTTP(w, r)
 	})
 }
+
+// EncodeError answers with the error: its HTTP status, code, message and details
+func EncodeError(err error, w http.ResponseWriter) {
+	e := wttypes.ToError(err)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(e.Status)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   e.Message,
+		"code":    e.Code,
+		"details": e.Details,
+	})
+}
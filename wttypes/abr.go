@@ -0,0 +1,202 @@
+package wttypes
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Packaging formats of an adaptive bitrate ladder
+const (
+	ABR_HLS  = "hls"
+	ABR_DASH = "dash"
+)
+
+// Segment types of an adaptive bitrate ladder
+const (
+	SEGMENT_TS   = "ts"
+	SEGMENT_FMP4 = "fmp4"
+)
+
+// Names of the playlists referencing every rendition of the ladder
+const (
+	ABR_HLS_MASTER    = "master.m3u8"
+	ABR_DASH_MANIFEST = "manifest.mpd"
+)
+
+// Seconds of every segment when not specified
+const ABR_SEGMENT_DURATION = 6
+
+// ABROptions is a struct with how an adaptive bitrate ladder is packaged,
+// the transcodings of the job are the renditions of the ladder
+type ABROptions struct {
+	Formats         []string `json:"formats"`
+	SegmentType     string   `json:"segment_type,omitempty"`
+	SegmentDuration int      `json:"segment_duration,omitempty"`
+}
+
+// WithDefaults returns the options filling the ones not specified
+func (a ABROptions) WithDefaults() ABROptions {
+	if len(a.Formats) == 0 {
+		a.Formats = []string{ABR_HLS, ABR_DASH}
+	}
+
+	// DASH needs fMP4, and HLS can share the same segments
+	if a.SegmentType == "" {
+		a.SegmentType = SEGMENT_TS
+		if a.has(ABR_DASH) {
+			a.SegmentType = SEGMENT_FMP4
+		}
+	}
+
+	if a.SegmentDuration == 0 {
+		a.SegmentDuration = ABR_SEGMENT_DURATION
+	}
+
+	return a
+}
+
+// Validate verifies the options can be packaged by ffmpeg
+func (a ABROptions) Validate() error {
+	if len(a.Formats) == 0 || len(a.Formats) > 2 {
+		return ErrInvalidABR
+	}
+
+	for _, v := range a.Formats {
+		if v != ABR_HLS && v != ABR_DASH {
+			return ErrInvalidABR
+		}
+	}
+
+	switch a.SegmentType {
+	case SEGMENT_TS:
+		if a.has(ABR_DASH) {
+			return ErrInvalidABR
+		}
+	case SEGMENT_FMP4:
+	default:
+		return ErrInvalidABR
+	}
+
+	if a.SegmentDuration < 1 || a.SegmentDuration > 60 {
+		return ErrInvalidABR
+	}
+
+	return nil
+}
+
+// Playlist returns the name of the playlist players start with, HLS when there is one
+func (a ABROptions) Playlist() string {
+	if a.has(ABR_HLS) {
+		return ABR_HLS_MASTER
+	}
+
+	return ABR_DASH_MANIFEST
+}
+
+func (a ABROptions) has(format string) bool {
+	return strInSlice(format, a.Formats)
+}
+
+// FFMPEGArgs renders the arguments for ffmpeg to transcode input into a ladder
+// with a rendition per profile, all written inside dir.
+// Audio tells if the input has audio to be packaged along the video.
+func (a ABROptions) FFMPEGArgs(profiles []Profile, input, dir string, audio bool) []string {
+	args := []string{"-i", input}
+
+	// Split the video, one chain of filters per rendition
+	n := len(profiles)
+	split := "[0:v]split=" + strconv.Itoa(n)
+	chains := []string{}
+	for i, p := range profiles {
+		split += "[v" + strconv.Itoa(i) + "]"
+
+		filters := append(p.scaleFilters(), p.FFMPEG.Filters...)
+		if len(filters) == 0 {
+			filters = []string{"null"}
+		}
+		chains = append(chains, "[v"+strconv.Itoa(i)+"]"+strings.Join(filters, ",")+"[out"+strconv.Itoa(i)+"]")
+	}
+	args = append(args, "-filter_complex", strings.Join(append([]string{split}, chains...), ";"))
+
+	// Video of every rendition
+	for i, p := range profiles {
+		f := p.FFMPEG
+		s := ":v:" + strconv.Itoa(i)
+
+		args = append(args, "-map", "[out"+strconv.Itoa(i)+"]")
+		args = appendOption(args, "-c"+s, f.VideoCodec)
+		args = appendOption(args, "-preset"+s, f.Preset)
+		if f.CRF > 0 {
+			args = append(args, "-crf"+s, strconv.Itoa(f.CRF))
+		}
+		args = appendOption(args, "-b"+s, f.VideoBitrate)
+		args = appendOption(args, "-profile"+s, f.Profile)
+		args = appendOption(args, "-level"+s, f.Level)
+	}
+
+	// Keyframes at the start of every segment, so players can switch renditions
+	seg := strconv.Itoa(a.SegmentDuration)
+	args = append(args, "-force_key_frames", "expr:gte(t,n_forced*"+seg+")")
+
+	if a.has(ABR_DASH) {
+		// A single audio for every rendition
+		adaptationSets := "id=0,streams=v"
+		if audio {
+			args = append(args, "-map", "0:a:0")
+			args = appendOption(args, "-c:a", profiles[0].FFMPEG.AudioCodec)
+			args = appendOption(args, "-b:a", profiles[0].FFMPEG.AudioBitrate)
+			adaptationSets += " id=1,streams=a"
+		}
+
+		args = append(args,
+			"-f", "dash",
+			"-seg_duration", seg,
+			"-use_template", "1",
+			"-use_timeline", "1",
+			"-adaptation_sets", adaptationSets,
+			"-init_seg_name", "init-$RepresentationID$.m4s",
+			"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		)
+
+		// HLS playlists over the same fMP4 segments
+		if a.has(ABR_HLS) {
+			args = append(args, "-hls_playlist", "1")
+		}
+
+		return append(args, path.Join(dir, ABR_DASH_MANIFEST))
+	}
+
+	// HLS only, every rendition with its own audio
+	streams := []string{}
+	for i, p := range profiles {
+		stream := "v:" + strconv.Itoa(i)
+		if audio {
+			s := ":a:" + strconv.Itoa(i)
+			args = append(args, "-map", "0:a:0")
+			args = appendOption(args, "-c"+s, p.FFMPEG.AudioCodec)
+			args = appendOption(args, "-b"+s, p.FFMPEG.AudioBitrate)
+			stream += ",a:" + strconv.Itoa(i)
+		}
+		streams = append(streams, stream)
+	}
+
+	segment := "segment_%05d.ts"
+	segmentType := "mpegts"
+	if a.SegmentType == SEGMENT_FMP4 {
+		segment = "segment_%05d.m4s"
+		segmentType = "fmp4"
+	}
+
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", seg,
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", segmentType,
+		"-hls_segment_filename", path.Join(dir, "%v", segment),
+		"-master_pl_name", ABR_HLS_MASTER,
+		"-var_stream_map", strings.Join(streams, " "),
+	)
+
+	return append(args, path.Join(dir, "%v", "index.m3u8"))
+}
@@ -0,0 +1,48 @@
+package wttypes
+
+// Defaults of chunked transcoding, in seconds
+const (
+	CHUNK_DURATION     = 300
+	CHUNK_MIN_DURATION = 1200
+)
+
+// ChunkOptions is a struct with how a long source is split at keyframes,
+// every chunk is transcoded by its own task and then stitched back together
+type ChunkOptions struct {
+	// Seconds of every chunk, cut at the next keyframe
+	Duration int `json:"duration,omitempty"`
+
+	// Sources shorter than this (in seconds) are transcoded whole
+	MinDuration int `json:"min_duration,omitempty"`
+}
+
+// WithDefaults returns the options filling the ones not specified
+func (c ChunkOptions) WithDefaults() ChunkOptions {
+	if c.Duration == 0 {
+		c.Duration = CHUNK_DURATION
+	}
+
+	if c.MinDuration == 0 {
+		c.MinDuration = CHUNK_MIN_DURATION
+	}
+
+	return c
+}
+
+// Validate verifies the options give chunks worth a task on their own
+func (c ChunkOptions) Validate() error {
+	if c.Duration < 10 || c.Duration > 3600 {
+		return ErrInvalidChunking
+	}
+
+	if c.MinDuration < 0 {
+		return ErrInvalidChunking
+	}
+
+	return nil
+}
+
+// Splits tells if a source of duration seconds is split into chunks
+func (c ChunkOptions) Splits(duration float64) bool {
+	return duration > float64(c.MinDuration) && duration > float64(c.Duration)
+}
@@ -1,32 +1,178 @@
 package wttypes
 
 import (
-	"errors"
+	"encoding/json"
+	"io"
+	"net/http"
 )
 
+// Error is an error answered by a service, with a machine-readable code,
+// the HTTP status it's answered with and, optionally, details about it
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Details map[string]string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Is tells if err is this error, even with other details
+func (e *Error) Is(err error) bool {
+	t, ok := err.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetails returns a copy of the error with details about it
+func (e *Error) WithDetails(details map[string]string) *Error {
+	return &Error{
+		Code:    e.Code,
+		Status:  e.Status,
+		Message: e.Message,
+		Details: details,
+	}
+}
+
+// errorsByCode are the errors below, to get them back from their code
+var errorsByCode = map[string]*Error{}
+
+func newError(code string, status int, message string) *Error {
+	e := &Error{
+		Code:    code,
+		Status:  status,
+		Message: message,
+	}
+	errorsByCode[code] = e
+
+	return e
+}
+
 var (
 	// ErrInvalidArgument is used for "invalid argument"
-	ErrInvalidArgument = errors.New("invalid argument")
+	ErrInvalidArgument = newError("invalid_argument", http.StatusBadRequest, "invalid argument")
 
 	// ErrNotFound is used for "not found"
-	ErrNotFound = errors.New("not found")
+	ErrNotFound = newError("not_found", http.StatusNotFound, "not found")
 
 	// ErrBadRoute is used for "bad route"
-	ErrBadRoute = errors.New("bad route")
+	ErrBadRoute = newError("bad_route", http.StatusNotFound, "bad route")
+
+	// ErrInternal is used for errors not known to the API
+	ErrInternal = newError("internal", http.StatusInternalServerError, "internal error")
+
+	ErrInvalidJSON = newError("invalid_json", http.StatusBadRequest, "Request body is not valid JSON")
+
+	ErrInvalidJob = newError("invalid_job", http.StatusBadRequest, "Invalid job, see details for what's wrong with each field")
+
+	ErrInvalidID = newError("invalid_id", http.StatusBadRequest, "Invalid ID")
+
+	ErrMismatchID = newError("mismatch_id", http.StatusBadRequest, "Mistmach in URL ID and JSON ID")
+
+	ErrNoJSON = newError("no_json", http.StatusBadGateway, "Response not in JSON format")
+
+	ErrTranscodingNotFound = newError("transcoding_not_found", http.StatusNotFound, "Transcoding ID not found")
+
+	ErrCantUploadObject = newError("cant_upload_object", http.StatusBadGateway, "Couldn't upload object into Object Storage")
+
+	ErrNoTaskRunning = newError("no_task_running", http.StatusNotFound, "No task is currently running")
+
+	ErrNoProcessRunning = newError("no_process_running", http.StatusConflict, "No FFMPEG process is currently running")
+
+	ErrNoTranscodings = newError("no_transcodings", http.StatusBadRequest, "No Transcodings were specified")
+
+	ErrCantCancel = newError("cant_cancel", http.StatusConflict, "Can't cancel job: finished already or was already cancelled")
+
+	ErrInvalidPriority = newError("invalid_priority", http.StatusBadRequest, "Priority must be between 1 and 10")
+
+	ErrTaskNotRequested = newError("task_not_requested", http.StatusConflict, "Task was not requested by this worker or its ACK deadline expired")
+
+	ErrLeaseLost = newError("lease_lost", http.StatusConflict, "Task is no longer running on this worker")
 
-	ErrMismatchID = errors.New("Mistmach in URL ID and JSON ID")
+	ErrProfileNotFound = newError("profile_not_found", http.StatusNotFound, "Profile not found")
 
-	ErrNoJSON = errors.New("Response not in JSON format")
+	ErrProfileExists = newError("profile_exists", http.StatusConflict, "Profile already exists")
 
-	ErrTranscodingNotFound = errors.New("Transcoding ID not found")
+	ErrInvalidFFMPEGArgs = newError("invalid_ffmpeg_args", http.StatusBadRequest, "Invalid FFMPEG settings: unknown codec, bitrate, CRF, preset, level, scaling or filter")
 
-	ErrCantUploadObject = errors.New("Couldn't upload object into Object Storage")
+	ErrUnsupportedContainer = newError("unsupported_container", http.StatusBadRequest, "Container not supported, or its codecs don't fit in it")
 
-	ErrNoTaskRunning = errors.New("No task is currently running")
+	ErrInvalidThumbnails = newError("invalid_thumbnails", http.StatusBadRequest, "Invalid thumbnails options: interval (1s or more) or ordered timestamps, sizes up to 1920x1080 and sprite sheets up to 16384x16384")
 
-	ErrNoProcessRunning = errors.New("No FFMPEG process is currently running")
+	ErrInvalidABR = newError("invalid_abr", http.StatusBadRequest, "Invalid ABR options: formats must be hls and/or dash, dash needs fmp4 segments, segments of 1 to 60 seconds")
 
-	ErrNoTranscodings = errors.New("No Transcodings were specified")
+	ErrInvalidWebhook = newError("invalid_webhook", http.StatusBadRequest, "Invalid webhook: http(s) url, a secret to sign deliveries and known events")
 
-	ErrCantCancel = errors.New("Can't cancel job: finished already or was already cancelled")
+	ErrInvalidChunking = newError("invalid_chunking", http.StatusBadRequest, "Invalid chunking options: chunks of 10 to 3600 seconds, not along with ABR")
+
+	ErrChunkNotFinished = newError("chunk_not_finished", http.StatusConflict, "Chunk to stitch is not finished")
+
+	ErrInvalidCursor = newError("invalid_cursor", http.StatusBadRequest, "Invalid cursor: not got from a listing with the same sort")
+
+	ErrInvalidResolution = newError("invalid_resolution", http.StatusBadRequest, "Resolution must be <width>x<height>")
+
+	ErrTranscodingFailed = newError("transcoding_failed", http.StatusInternalServerError, "FFMPEG failed to transcode the media")
+
+	ErrMediaUnreadable = newError("media_unreadable", http.StatusBadRequest, "Media is corrupt or can't be read")
+
+	ErrMediaNoVideo = newError("media_no_video", http.StatusBadRequest, "Media has no video stream")
+
+	ErrMediaUnsupported = newError("media_unsupported", http.StatusBadRequest, "Media container or video codec not supported")
+
+	ErrInvalidUpload = newError("invalid_upload", http.StatusBadRequest, "Invalid upload: multipart/form-data with a job part (JSON) followed by a media part, resumable ones need their length")
+
+	ErrUploadNotFound = newError("upload_not_found", http.StatusNotFound, "Upload not found")
+
+	ErrUploadOffsetMismatch = newError("upload_offset_mismatch", http.StatusConflict, "Chunk is not at the offset of the upload, resume from its offset")
+
+	ErrInvalidUploadChunk = newError("invalid_upload_chunk", http.StatusBadRequest, "Invalid chunk: at least 1MB (but the last one), up to 5GB and not past the length of the upload")
+
+	ErrUploadIncomplete = newError("upload_incomplete", http.StatusConflict, "Upload is not complete yet")
+
+	ErrUploadNotUploading = newError("upload_not_uploading", http.StatusConflict, "Upload is being finished or already finished")
 )
+
+// ErrorFromCode returns the error with the code, nil if it isn't one of the above
+func ErrorFromCode(code string) *Error {
+	return errorsByCode[code]
+}
+
+// ToError returns err as an Error, errors unknown to the API are internal ones
+func ToError(err error) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case retryableError:
+		return ToError(e.err)
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return ErrInvalidJSON.WithDetails(map[string]string{"reason": e.Error()})
+	}
+
+	// Nothing in the body
+	if err == io.EOF {
+		return ErrInvalidJSON
+	}
+
+	return &Error{
+		Code:    ErrInternal.Code,
+		Status:  ErrInternal.Status,
+		Message: err.Error(),
+	}
+}
+
+// retryableError wraps errors that are transient (storage, network...)
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+
+// Retryable marks an error as transient, so the task failing with it can be retried
+func Retryable(err error) error {
+	return retryableError{err: err}
+}
+
+// IsRetryable tells if the error was marked as transient with Retryable
+func IsRetryable(err error) bool {
+	_, ok := err.(retryableError)
+	return ok
+}
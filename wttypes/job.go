@@ -1,5 +1,16 @@
 package wttypes
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
 	JOB_QUEUED    = "queued"
 	JOB_RUNNING   = "running"
@@ -8,6 +19,13 @@ const (
 	JOB_ERROR     = "error"
 )
 
+// Priorities of a job, higher ones are transcoded first
+const (
+	PRIORITY_MIN     = 1
+	PRIORITY_DEFAULT = 5
+	PRIORITY_MAX     = 10
+)
+
 // Job is a struct that stores all needed information for the job
 type Job struct {
 	ID           string            `json:"id"`
@@ -16,9 +34,331 @@ type Job struct {
 	ObjectName   string            `json:"object_name"`
 	Transcodings []TranscodingTask `json:"transcodings"`
 	Status       string            `json:"status"`
+	Priority     int               `json:"priority,omitempty"`
+	Tenant       string            `json:"tenant,omitempty"`
+	Media        *MediaInfo        `json:"media,omitempty"`
+
+	Added   *time.Time `json:"added,omitempty"`
+	Started *time.Time `json:"started,omitempty"`
+	Ended   *time.Time `json:"ended,omitempty"`
+
+	// Transcodings are packaged as the renditions of a ladder when set
+	ABR *ABROptions `json:"abr,omitempty"`
+
+	// Long sources are split and transcoded by several workers when set
+	Chunking *ChunkOptions `json:"chunking,omitempty"`
+
+	// Events of the job are delivered here when set
+	Webhook *Webhook `json:"webhook,omitempty"`
 }
 
 type JobIDs struct {
 	ID           string            `json:"id"`
 	Transcodings []TranscodingTask `json:"transcodings"`
 }
+
+// Limits of a job submitted to be transcoded
+const (
+	JOB_MAX_TRANSCODINGS = 32
+	JOB_MAX_URL          = 2048
+	JOB_MAX_VIDEO_NAME   = 128
+	JOB_MAX_TENANT       = 64
+)
+
+var (
+	// Characters not fit for object names, replaced by "_"
+	invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+	validTenant = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// SanitizeVideoName returns the name fit to be part of object names: letters, digits, ".", "-" and "_"
+func SanitizeVideoName(name string) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.TrimSpace(name), "_"), "._")
+}
+
+// Validate verifies a job submitted to be transcoded, its profiles must be in the catalogue.
+// The error is ErrInvalidJob with what's wrong with each field as details
+func (j Job) Validate(profiles map[string]Profile) error {
+	return j.validate(profiles, false)
+}
+
+// ValidateUpload is like Validate, for a job whose media is uploaded along with it
+func (j Job) ValidateUpload(profiles map[string]Profile) error {
+	return j.validate(profiles, true)
+}
+
+func (j Job) validate(profiles map[string]Profile, upload bool) error {
+	fields := map[string]string{}
+
+	// Set by the service
+	if j.ID != "" {
+		fields["id"] = "must not be set"
+	}
+	if j.ObjectName != "" {
+		fields["object_name"] = "must not be set"
+	}
+	if j.Status != "" {
+		fields["status"] = "must not be set"
+	}
+	if j.Media != nil {
+		fields["media"] = "must not be set"
+	}
+	if j.Added != nil || j.Started != nil || j.Ended != nil {
+		fields["added"] = "times must not be set"
+	}
+
+	switch {
+	case upload:
+		if j.URLMedia != "" {
+			fields["url_media"] = "must not be set, media is uploaded"
+		}
+	case j.URLMedia == "":
+		fields["url_media"] = "required"
+	case len(j.URLMedia) > JOB_MAX_URL:
+		fields["url_media"] = fmt.Sprintf("up to %d characters", JOB_MAX_URL)
+	default:
+		u, err := url.Parse(j.URLMedia)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fields["url_media"] = "must be an http(s) URL"
+		}
+	}
+
+	switch {
+	case strings.TrimSpace(j.VideoName) == "":
+		fields["video_name"] = "required"
+	case len(j.VideoName) > JOB_MAX_VIDEO_NAME:
+		fields["video_name"] = fmt.Sprintf("up to %d characters", JOB_MAX_VIDEO_NAME)
+	case SanitizeVideoName(j.VideoName) == "":
+		fields["video_name"] = "must have letters or digits"
+	}
+
+	if j.Tenant != "" && (len(j.Tenant) > JOB_MAX_TENANT || !validTenant.MatchString(j.Tenant)) {
+		fields["tenant"] = fmt.Sprintf("letters, digits, \"-\" and \"_\", up to %d characters", JOB_MAX_TENANT)
+	}
+
+	if len(j.Transcodings) > JOB_MAX_TRANSCODINGS {
+		fields["transcodings"] = fmt.Sprintf("up to %d", JOB_MAX_TRANSCODINGS)
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range j.Transcodings {
+		field := fmt.Sprintf("transcodings[%d]", i)
+
+		// Only what to transcode, the rest is set by the services
+		if t.ID != "" || t.ObjectName != "" || t.Status != "" || t.DownloadURL != "" || t.Progress != nil ||
+			len(t.Renditions) > 0 || t.ABR != nil || len(t.DependsOn) > 0 {
+			fields[field] = "only profile, kind and thumbnails can be set"
+		}
+
+		switch t.Kind {
+		case "", TASK_TRANSCODING:
+			if _, ok := profiles[t.Profile]; t.Profile == "" {
+				fields[field+".profile"] = "required"
+			} else if !ok {
+				fields[field+".profile"] = "unknown profile"
+			} else if seen[t.Profile] {
+				fields[field+".profile"] = "duplicated"
+			}
+			seen[t.Profile] = true
+
+			if t.Thumbnails != nil {
+				fields[field+".thumbnails"] = "only for thumbnails"
+			}
+		case TASK_THUMBNAILS:
+			if t.Profile != "" {
+				fields[field+".profile"] = "must not be set for thumbnails"
+			}
+		default:
+			fields[field+".kind"] = "must be transcoding or thumbnails"
+		}
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidJob.WithDetails(fields)
+	}
+
+	return nil
+}
+
+// Page sizes of job listings
+const (
+	JOBS_LIMIT_DEFAULT = 50
+	JOBS_LIMIT_MAX     = 500
+)
+
+// Fields jobs can be sorted by, with a "-" in front for descending order
+var jobSortFields = []string{"added", "started", "ended", "video_name", "status", "priority"}
+
+// JobFilter is a struct with which jobs are listed, and in which order
+type JobFilter struct {
+	Status string
+
+	// Part of the video name, regardless of case
+	VideoName string
+
+	// Added from (inclusive) and to (exclusive) these times
+	From time.Time
+	To   time.Time
+
+	// Newest first when empty ("-added")
+	Sort string
+
+	Limit int
+
+	// Where the previous page ended, first page when empty
+	Cursor string
+}
+
+// ParseJobFilter gets the filter from the query parameters of a listing
+func ParseJobFilter(q url.Values) (JobFilter, error) {
+	f := JobFilter{
+		Status:    q.Get("status"),
+		VideoName: q.Get("video_name"),
+		Sort:      q.Get("sort"),
+		Cursor:    q.Get("cursor"),
+	}
+
+	// Times are RFC 3339, e.g. 2017-01-01T00:00:00Z
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return JobFilter{}, ErrInvalidArgument
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return JobFilter{}, ErrInvalidArgument
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return JobFilter{}, ErrInvalidArgument
+		}
+	}
+
+	f = f.WithDefaults()
+	if err := f.Validate(); err != nil {
+		return JobFilter{}, err
+	}
+
+	return f, nil
+}
+
+// Query returns the filter as the query parameters of a listing
+func (f JobFilter) Query() url.Values {
+	q := url.Values{}
+
+	if f.Status != "" {
+		q.Set("status", f.Status)
+	}
+	if f.VideoName != "" {
+		q.Set("video_name", f.VideoName)
+	}
+	if !f.From.IsZero() {
+		q.Set("from", f.From.Format(time.RFC3339))
+	}
+	if !f.To.IsZero() {
+		q.Set("to", f.To.Format(time.RFC3339))
+	}
+	if f.Sort != "" {
+		q.Set("sort", f.Sort)
+	}
+	if f.Limit != 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Cursor != "" {
+		q.Set("cursor", f.Cursor)
+	}
+
+	return q
+}
+
+// WithDefaults returns the filter filling the options not specified
+func (f JobFilter) WithDefaults() JobFilter {
+	if f.Sort == "" {
+		f.Sort = "-added"
+	}
+
+	if f.Limit == 0 {
+		f.Limit = JOBS_LIMIT_DEFAULT
+	}
+
+	return f
+}
+
+// Validate verifies the filter can be applied
+func (f JobFilter) Validate() error {
+	if !strInSlice(f.SortField(), jobSortFields) {
+		return ErrInvalidArgument
+	}
+
+	if f.Limit < 1 || f.Limit > JOBS_LIMIT_MAX {
+		return ErrInvalidArgument
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return ErrInvalidArgument
+	}
+
+	// A cursor only goes on with the sort it was got with
+	if f.Cursor != "" {
+		c, err := ParseJobCursor(f.Cursor)
+		if err != nil {
+			return err
+		}
+		if c.Sort != f.Sort {
+			return ErrInvalidCursor
+		}
+	}
+
+	return nil
+}
+
+// SortField returns the field jobs are sorted by
+func (f JobFilter) SortField() string {
+	return strings.TrimPrefix(f.Sort, "-")
+}
+
+// SortDescending tells if jobs are sorted in descending order
+func (f JobFilter) SortDescending() bool {
+	return strings.HasPrefix(f.Sort, "-")
+}
+
+// JobCursor is a struct with the last job of a page: its sort value and its ID
+type JobCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// Encode returns the cursor as an opaque string to be used in URLs
+func (c JobCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseJobCursor decodes a cursor got with Encode
+func ParseJobCursor(s string) (JobCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return JobCursor{}, ErrInvalidCursor
+	}
+
+	var c JobCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return JobCursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// JobList is a struct with a page of jobs
+type JobList struct {
+	Jobs  []Job `json:"jobs"`
+	Total int   `json:"total"`
+
+	// Cursor of the next page, empty on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
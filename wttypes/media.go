@@ -0,0 +1,30 @@
+package wttypes
+
+// MediaInfo is a struct with what ffprobe found in the source media
+type MediaInfo struct {
+	Container  string  `json:"container"`
+	VideoCodec string  `json:"video_codec"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Duration   float64 `json:"duration"`
+	Bitrate    int64   `json:"bitrate"`
+
+	AudioTracks []AudioTrack `json:"audio_tracks,omitempty"`
+}
+
+// AudioTrack is a struct with information of an audio stream of the media
+type AudioTrack struct {
+	Codec      string `json:"codec"`
+	Channels   int    `json:"channels"`
+	SampleRate int    `json:"sample_rate"`
+	Language   string `json:"language,omitempty"`
+}
+
+// Upscales tells if transcoding the media with the requirements would make it bigger
+func (m MediaInfo) Upscales(r ProfileRequirements) bool {
+	if m.Width == 0 || m.Height == 0 {
+		return false
+	}
+
+	return r.Width > m.Width || r.Height > m.Height
+}
@@ -1,25 +1,478 @@
 package wttypes
 
-// ProfileFFMPEG is a struct that maps a name with ffmpeg arguments
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// How the source is scaled to the resolution of a profile
+const (
+	SCALING_STRETCH = "stretch"
+	SCALING_FIT     = "fit"
+	SCALING_PAD     = "pad"
+)
+
+// Container is a struct with how ffmpeg writes an output format, and the codecs it can hold
+type Container struct {
+	Muxer       string
+	Extension   string
+	ContentType string
+	AudioOnly   bool
+	FastStart   bool
+
+	// Empty means any codec
+	VideoCodecs []string
+	AudioCodecs []string
+}
+
+// Containers supported for the output
+var containers = map[string]Container{
+	"mp4":  {Muxer: "mp4", Extension: ".mp4", ContentType: "video/mp4", FastStart: true},
+	"mov":  {Muxer: "mov", Extension: ".mov", ContentType: "video/quicktime", FastStart: true},
+	"mkv":  {Muxer: "matroska", Extension: ".mkv", ContentType: "video/x-matroska"},
+	"webm": {Muxer: "webm", Extension: ".webm", ContentType: "video/webm", VideoCodecs: []string{"libvpx", "libvpx-vp9", "libaom-av1", "libsvtav1"}, AudioCodecs: []string{"libopus", "libvorbis"}},
+	"m4a":  {Muxer: "ipod", Extension: ".m4a", ContentType: "audio/mp4", AudioOnly: true, FastStart: true, AudioCodecs: []string{"aac", "libfdk_aac", "alac"}},
+	"mp3":  {Muxer: "mp3", Extension: ".mp3", ContentType: "audio/mpeg", AudioOnly: true, AudioCodecs: []string{"libmp3lame"}},
+	"opus": {Muxer: "opus", Extension: ".opus", ContentType: "audio/ogg", AudioOnly: true, AudioCodecs: []string{"libopus"}},
+}
+
+// Content types of the files of an ABR ladder
+var segmentContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+}
+
+// ContentType returns the content type of a file written by the workers, by its extension
+func ContentType(filename string) string {
+	ext := path.Ext(filename)
+
+	for _, v := range containers {
+		if v.Extension == ext {
+			return v.ContentType
+		}
+	}
+
+	return segmentContentTypes[ext]
+}
+
+// x264/x265 presets
+var presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+var (
+	reCodec   = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	reBitrate = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kM]?$`)
+	reLevel   = regexp.MustCompile(`^[a-z0-9.]+$`)
+)
+
+// ProfileFFMPEG is a struct with the encoding settings of a profile
 type ProfileFFMPEG struct {
-	Name string
-	Args string
+	Name string `json:"name"`
+
+	VideoCodec   string `json:"video_codec"`
+	VideoBitrate string `json:"video_bitrate,omitempty"`
+	CRF          int    `json:"crf,omitempty"`
+	Preset       string `json:"preset,omitempty"`
+	Profile      string `json:"profile,omitempty"`
+	Level        string `json:"level,omitempty"`
+
+	AudioCodec   string `json:"audio_codec"`
+	AudioBitrate string `json:"audio_bitrate,omitempty"`
+
+	// Scaling mode when the profile has a resolution, stretch by default
+	Scaling string `json:"scaling,omitempty"`
+
+	// Video filters applied after scaling, e.g. "yadif" or "drawtext=text='hi'"
+	Filters []string `json:"filters,omitempty"`
+
+	Container string `json:"container"`
+	FastStart bool   `json:"faststart,omitempty"`
+}
+
+// Validate verifies the encoding settings are ones ffmpeg understands
+func (f ProfileFFMPEG) Validate() error {
+	c, ok := containers[f.Container]
+	if !ok {
+		return ErrUnsupportedContainer
+	}
+
+	// Audio only containers have no video at all
+	if c.AudioOnly != (f.VideoCodec == "") {
+		return ErrUnsupportedContainer
+	}
+
+	if (f.VideoCodec != "" && !reCodec.MatchString(f.VideoCodec)) || !reCodec.MatchString(f.AudioCodec) {
+		return ErrInvalidFFMPEGArgs
+	}
+
+	// Codecs must fit in the container ("copy" is up to the source)
+	if f.VideoCodec != "" && f.VideoCodec != "copy" && len(c.VideoCodecs) > 0 && !strInSlice(f.VideoCodec, c.VideoCodecs) {
+		return ErrUnsupportedContainer
+	}
+
+	if f.AudioCodec != "copy" && len(c.AudioCodecs) > 0 && !strInSlice(f.AudioCodec, c.AudioCodecs) {
+		return ErrUnsupportedContainer
+	}
+
+	if f.FastStart && !c.FastStart {
+		return ErrUnsupportedContainer
+	}
+
+	if f.VideoBitrate != "" && !reBitrate.MatchString(f.VideoBitrate) {
+		return ErrInvalidFFMPEGArgs
+	}
+
+	if f.AudioBitrate != "" && !reBitrate.MatchString(f.AudioBitrate) {
+		return ErrInvalidFFMPEGArgs
+	}
+
+	if f.CRF < 0 || f.CRF > 51 {
+		return ErrInvalidFFMPEGArgs
+	}
+
+	if f.Preset != "" && !strInSlice(f.Preset, presets) {
+		return ErrInvalidFFMPEGArgs
+	}
+
+	if (f.Profile != "" && !reLevel.MatchString(f.Profile)) || (f.Level != "" && !reLevel.MatchString(f.Level)) {
+		return ErrInvalidFFMPEGArgs
+	}
+
+	switch f.Scaling {
+	case "", SCALING_STRETCH, SCALING_FIT, SCALING_PAD:
+	default:
+		return ErrInvalidFFMPEGArgs
+	}
+
+	for _, v := range f.Filters {
+		if !validFilter(v) {
+			return ErrInvalidFFMPEGArgs
+		}
+	}
+
+	return nil
+}
+
+// Video filters a profile can use, none of them reads files from the worker.
+// Options are any, unless they are listed (and then must be given by name).
+var allowedFilters = map[string][]string{
+	"scale":     nil,
+	"crop":      nil,
+	"pad":       nil,
+	"setsar":    nil,
+	"setdar":    nil,
+	"yadif":     nil,
+	"bwdif":     nil,
+	"fps":       nil,
+	"format":    nil,
+	"hflip":     nil,
+	"vflip":     nil,
+	"transpose": nil,
+	"eq":        nil,
+	"hqdn3d":    nil,
+	"unsharp":   nil,
+	"fade":      nil,
+	"drawbox":   nil,
+	"drawtext": {"text", "x", "y", "fontsize", "fontcolor", "alpha", "box", "boxcolor", "boxborderw",
+		"borderw", "bordercolor", "shadowx", "shadowy", "shadowcolor", "line_spacing", "fix_bounds", "enable"},
+}
+
+var (
+	reFilterName = regexp.MustCompile(`^[a-z0-9_]+$`)
+	reOptionKey  = regexp.MustCompile(`^([A-Za-z0-9_./-]+)=`)
+)
+
+// validFilter tells if a filter is one in allowedFilters with its allowed options. Its
+// arguments are read like ffmpeg does, unescaped once by the filtergraph and once more
+// into options, so quoting can't hide other filters or options.
+func validFilter(filter string) bool {
+	kv := strings.SplitN(filter, "=", 2)
+	options, ok := allowedFilters[kv[0]]
+	if !ok || !reFilterName.MatchString(kv[0]) {
+		return false
+	}
+
+	if len(kv) == 1 {
+		return true
+	}
+
+	// Must stay a single filter in the video chain
+	args := filterTokens(kv[1], ",;[]")
+	if len(args) != 1 {
+		return false
+	}
+
+	if options == nil {
+		return true
+	}
+
+	for _, v := range filterTokens(args[0], ":") {
+		// Unnamed options could be any of them
+		m := reOptionKey.FindStringSubmatch(v)
+		if m == nil || !strInSlice(m[1], options) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterTokens splits s at the separators like ffmpeg (av_get_token) does: backslash
+// escapes the next character, and quotes keep everything until the closing one
+func filterTokens(s string, separators string) []string {
+	tokens := []string{}
+	token := []rune{}
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case strings.ContainsRune(separators, c):
+			tokens = append(tokens, string(token))
+			token = []rune{}
+		case c == '\\' && i+1 < len(rs):
+			i++
+			token = append(token, rs[i])
+		case c == '\'':
+			for i++; i < len(rs) && rs[i] != '\''; i++ {
+				token = append(token, rs[i])
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+
+	return append(tokens, string(token))
+}
+
+// ParseFFMPEGArgs converts the raw ffmpeg arguments profiles had before the
+// structured settings. Output was mp4 with ffmpeg's default codecs for it.
+func ParseFFMPEGArgs(name, args string) (ProfileFFMPEG, error) {
+	f := ProfileFFMPEG{Name: name, VideoCodec: "libx264", AudioCodec: "aac", Container: "mp4"}
+
+	fields := strings.Fields(args)
+	if len(fields)%2 != 0 {
+		return ProfileFFMPEG{}, ErrInvalidFFMPEGArgs
+	}
+
+	var err error
+	for i := 0; i < len(fields); i += 2 {
+		option, value := fields[i], fields[i+1]
+
+		switch option {
+		case "-c:v", "-vcodec", "-codec:v":
+			f.VideoCodec = value
+		case "-c:a", "-acodec", "-codec:a":
+			f.AudioCodec = value
+		case "-b:v":
+			f.VideoBitrate = value
+		case "-b:a", "-ab":
+			f.AudioBitrate = value
+		case "-crf":
+			if f.CRF, err = strconv.Atoi(value); err != nil {
+				return ProfileFFMPEG{}, ErrInvalidFFMPEGArgs
+			}
+		case "-preset":
+			f.Preset = value
+		case "-profile:v", "-vprofile":
+			f.Profile = value
+		case "-level", "-level:v":
+			f.Level = value
+		case "-vf", "-filter:v":
+			f.Filters = append(f.Filters, strings.Split(value, ",")...)
+		case "-movflags":
+			if strings.TrimPrefix(value, "+") != "faststart" {
+				return ProfileFFMPEG{}, ErrInvalidFFMPEGArgs
+			}
+			f.FastStart = true
+		default:
+			// Nothing in the settings for it
+			return ProfileFFMPEG{}, ErrInvalidFFMPEGArgs
+		}
+	}
+
+	err = f.Validate()
+	if err != nil {
+		return ProfileFFMPEG{}, err
+	}
+
+	return f, nil
 }
 
 // Profile is a struct that maps a name with
 // a FFMPEG profile and a resolution for use in a specific device or system
 type Profile struct {
-	Name       string
-	FFMPEG     ProfileFFMPEG
-	Resolution string
+	Name       string        `json:"name"`
+	FFMPEG     ProfileFFMPEG `json:"ffmpeg"`
+	Resolution string        `json:"resolution,omitempty"`
+	MinCPUs    int           `json:"min_cpus,omitempty"`
+}
+
+// Validate verifies the profile can be used by the workers
+func (p Profile) Validate() error {
+	if p.Name == "" || strings.ContainsAny(p.Name, "/ ") {
+		return ErrInvalidArgument
+	}
+
+	// Resolution is optional, keeps the one of the source
+	if p.Resolution != "" {
+		if p.AudioOnly() {
+			return ErrInvalidResolution
+		}
+
+		r := p.Requirements()
+		if r.Width <= 0 || r.Height <= 0 {
+			return ErrInvalidResolution
+		}
+	}
+
+	if p.MinCPUs < 0 {
+		return ErrInvalidArgument
+	}
+
+	return p.FFMPEG.Validate()
+}
+
+// AudioOnly tells if the profile produces audio without video
+func (p Profile) AudioOnly() bool {
+	return containers[p.FFMPEG.Container].AudioOnly
+}
+
+// Extension returns the file extension of the output of the profile
+func (p Profile) Extension() string {
+	return containers[p.FFMPEG.Container].Extension
+}
+
+// FFMPEGArgs renders the arguments for ffmpeg to transcode input into output
+func (p Profile) FFMPEGArgs(input, output string) []string {
+	f := p.FFMPEG
+
+	args := []string{"-i", input}
+
+	// Video
+	if p.AudioOnly() {
+		args = append(args, "-vn")
+	}
+	args = appendOption(args, "-c:v", f.VideoCodec)
+	args = appendOption(args, "-preset", f.Preset)
+	if f.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(f.CRF))
+	}
+	args = appendOption(args, "-b:v", f.VideoBitrate)
+	args = appendOption(args, "-profile:v", f.Profile)
+	args = appendOption(args, "-level", f.Level)
+
+	filters := append(p.scaleFilters(), f.Filters...)
+	if len(filters) > 0 && !p.AudioOnly() {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+
+	// Audio
+	args = appendOption(args, "-c:a", f.AudioCodec)
+	args = appendOption(args, "-b:a", f.AudioBitrate)
+
+	// Container
+	if f.FastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = appendOption(args, "-f", containers[f.Container].Muxer)
+
+	return append(args, output)
+}
+
+// OutputName returns the name of the media transcoded with the profile from objectname
+func (p Profile) OutputName(objectname string) string {
+	return objectname + "-" + p.Name + p.Extension()
+}
+
+// ConcatArgs renders the arguments for ffmpeg to join, without transcoding again,
+// the outputs of the profile listed in list (a concat demuxer file) into output
+func (p Profile) ConcatArgs(list, output string) []string {
+	args := []string{"-f", "concat", "-safe", "0", "-i", list, "-c", "copy"}
+
+	if p.FFMPEG.FastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = appendOption(args, "-f", containers[p.FFMPEG.Container].Muxer)
+
+	return append(args, output)
+}
+
+// scaleFilters returns the video filters to get to the resolution of the profile
+func (p Profile) scaleFilters() []string {
+	r := p.Requirements()
+	if r.Width == 0 || r.Height == 0 {
+		return nil
+	}
+
+	size := strconv.Itoa(r.Width) + ":" + strconv.Itoa(r.Height)
+
+	// Keep aspect ratio inside the resolution, with even dimensions for the encoders
+	fit := []string{
+		"scale=" + size + ":force_original_aspect_ratio=decrease",
+		"scale=trunc(iw/2)*2:trunc(ih/2)*2",
+	}
+
+	switch p.FFMPEG.Scaling {
+	case SCALING_FIT:
+		return fit
+	case SCALING_PAD:
+		return append(fit, "pad="+size+":(ow-iw)/2:(oh-ih)/2")
+	default:
+		return []string{"scale=" + size}
+	}
+}
+
+func appendOption(args []string, option, value string) []string {
+	if value == "" {
+		return args
+	}
+
+	return append(args, option, value)
+}
+
+// ProfileRequirements is a struct with the worker capabilities needed by a profile
+type ProfileRequirements struct {
+	Encoders    []string
+	Width       int
+	Height      int
+	MinCPUs     int
+	MinDiskFree int64
+}
+
+// Requirements returns what a worker needs to transcode this profile
+func (p Profile) Requirements() ProfileRequirements {
+	r := ProfileRequirements{
+		MinCPUs: p.MinCPUs,
+	}
+
+	// Copying a stream doesn't need an encoder
+	for _, v := range []string{p.FFMPEG.VideoCodec, p.FFMPEG.AudioCodec} {
+		if v != "" && v != "copy" {
+			r.Encoders = append(r.Encoders, v)
+		}
+	}
+
+	// Resolution is "<width>x<height>"
+	wh := strings.Split(p.Resolution, "x")
+	if len(wh) == 2 {
+		r.Width, _ = strconv.Atoi(wh[0])
+		r.Height, _ = strconv.Atoi(wh[1])
+	}
+
+	return r
 }
 
-// NewProfile returns a map with the supported profiles for transcoding
+// NewProfile returns a map with the built-in profiles for transcoding,
+// used to seed the profiles in database service
 func NewProfile() map[string]Profile {
 	// Profiles FFMPEG
-	proBaseline := ProfileFFMPEG{Name: "baseline", Args: "-movflags faststart -profile:v baseline -level 3.0"}
-	proApple42 := ProfileFFMPEG{Name: "apple-42", Args: "-profile:v high -level 4.2"}
-	proApple41 := ProfileFFMPEG{Name: "apple-41", Args: "-profile:v high -level 4.1"}
+	proBaseline := ProfileFFMPEG{Name: "baseline", VideoCodec: "libx264", Profile: "baseline", Level: "3.0", AudioCodec: "aac", Container: "mp4", FastStart: true}
+	proApple42 := ProfileFFMPEG{Name: "apple-42", VideoCodec: "libx264", Profile: "high", Level: "4.2", AudioCodec: "aac", Container: "mp4"}
+	proApple41 := ProfileFFMPEG{Name: "apple-41", VideoCodec: "libx264", Profile: "high", Level: "4.1", AudioCodec: "aac", Container: "mp4"}
 
 	// Profiles map
 	p := make(map[string]Profile)
@@ -28,8 +481,8 @@ func NewProfile() map[string]Profile {
 	p["baseline"] = Profile{Name: "baseline", FFMPEG: proBaseline}
 	p["iPhone4s"] = Profile{Name: "iPhone4s", FFMPEG: proApple41, Resolution: "960x640"}
 	p["iPhone5s"] = Profile{Name: "iPhone5s", FFMPEG: proApple42, Resolution: "1136x640"}
-	p["iPhonePlus6s"] = Profile{Name: "iPhonePlus6s", FFMPEG: proApple42, Resolution: "1920x1080"}
-	p["iPadMini4"] = Profile{Name: "iPadMini4", FFMPEG: proApple42, Resolution: "2048x1536"}
+	p["iPhonePlus6s"] = Profile{Name: "iPhonePlus6s", FFMPEG: proApple42, Resolution: "1920x1080", MinCPUs: 2}
+	p["iPadMini4"] = Profile{Name: "iPadMini4", FFMPEG: proApple42, Resolution: "2048x1536", MinCPUs: 2}
 
 	return p
 }
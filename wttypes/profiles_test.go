@@ -0,0 +1,210 @@
+package wttypes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFFMPEGArgsBuiltIn(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    []string
+	}{
+		{"baseline", []string{"-i", "in.mp4", "-c:v", "libx264", "-profile:v", "baseline", "-level", "3.0", "-c:a", "aac", "-movflags", "+faststart", "-f", "mp4", "out.mp4"}},
+		{"iPhone4s", []string{"-i", "in.mp4", "-c:v", "libx264", "-profile:v", "high", "-level", "4.1", "-vf", "scale=960:640", "-c:a", "aac", "-f", "mp4", "out.mp4"}},
+		{"iPhone5s", []string{"-i", "in.mp4", "-c:v", "libx264", "-profile:v", "high", "-level", "4.2", "-vf", "scale=1136:640", "-c:a", "aac", "-f", "mp4", "out.mp4"}},
+		{"iPhonePlus6s", []string{"-i", "in.mp4", "-c:v", "libx264", "-profile:v", "high", "-level", "4.2", "-vf", "scale=1920:1080", "-c:a", "aac", "-f", "mp4", "out.mp4"}},
+		{"iPadMini4", []string{"-i", "in.mp4", "-c:v", "libx264", "-profile:v", "high", "-level", "4.2", "-vf", "scale=2048:1536", "-c:a", "aac", "-f", "mp4", "out.mp4"}},
+	}
+
+	profiles := NewProfile()
+	if len(profiles) != len(tests) {
+		t.Fatalf("%d built-in profiles, %d tested", len(profiles), len(tests))
+	}
+
+	for _, tt := range tests {
+		p, ok := profiles[tt.profile]
+		if !ok {
+			t.Errorf("%s: not a built-in profile", tt.profile)
+			continue
+		}
+
+		if err := p.Validate(); err != nil {
+			t.Errorf("%s: Validate() = %v", tt.profile, err)
+		}
+
+		got := p.FFMPEGArgs("in.mp4", "out.mp4")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: FFMPEGArgs() =\n%q\nwant\n%q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestFFMPEGArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		want    []string
+	}{
+		{
+			name: "filter with quotes and spaces",
+			profile: Profile{Name: "test", FFMPEG: ProfileFFMPEG{VideoCodec: "libx264", CRF: 23, Preset: "fast", AudioCodec: "aac",
+				Filters: []string{"yadif", "drawtext=text='a b':x=10:y=10"}, Container: "mkv"}},
+			want: []string{"-i", "in", "-c:v", "libx264", "-preset", "fast", "-crf", "23",
+				"-vf", "yadif,drawtext=text='a b':x=10:y=10", "-c:a", "aac", "-f", "matroska", "out"},
+		},
+		{
+			name: "audio only",
+			profile: Profile{Name: "test", FFMPEG: ProfileFFMPEG{AudioCodec: "libmp3lame", AudioBitrate: "192k",
+				Filters: []string{"yadif"}, Container: "mp3"}},
+			want: []string{"-i", "in", "-vn", "-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3", "out"},
+		},
+		{
+			name: "audio only with faststart",
+			profile: Profile{Name: "test", FFMPEG: ProfileFFMPEG{AudioCodec: "aac", AudioBitrate: "128k",
+				Container: "m4a", FastStart: true}},
+			want: []string{"-i", "in", "-vn", "-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", "-f", "ipod", "out"},
+		},
+		{
+			name: "bitrates",
+			profile: Profile{Name: "test", FFMPEG: ProfileFFMPEG{VideoCodec: "libvpx-vp9", VideoBitrate: "1.5M", AudioCodec: "libopus",
+				AudioBitrate: "96k", Container: "webm"}},
+			want: []string{"-i", "in", "-c:v", "libvpx-vp9", "-b:v", "1.5M", "-c:a", "libopus", "-b:a", "96k", "-f", "webm", "out"},
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.profile.Validate(); err != nil {
+			t.Errorf("%s: Validate() = %v", tt.name, err)
+		}
+
+		got := tt.profile.FFMPEGArgs("in", "out")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: FFMPEGArgs() =\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScaleFilters(t *testing.T) {
+	fit := []string{
+		"scale=1280:720:force_original_aspect_ratio=decrease",
+		"scale=trunc(iw/2)*2:trunc(ih/2)*2",
+	}
+
+	tests := []struct {
+		scaling    string
+		resolution string
+		want       []string
+	}{
+		{"", "1280x720", []string{"scale=1280:720"}},
+		{SCALING_STRETCH, "1280x720", []string{"scale=1280:720"}},
+		{SCALING_FIT, "1280x720", fit},
+		{SCALING_PAD, "1280x720", append(fit, "pad=1280:720:(ow-iw)/2:(oh-ih)/2")},
+		{SCALING_PAD, "", nil},
+	}
+
+	for _, tt := range tests {
+		p := Profile{Resolution: tt.resolution, FFMPEG: ProfileFFMPEG{Scaling: tt.scaling}}
+
+		got := p.scaleFilters()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q %q: scaleFilters() = %q, want %q", tt.scaling, tt.resolution, got, tt.want)
+		}
+	}
+}
+
+func TestConcatArgs(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    []string
+	}{
+		{"baseline", []string{"-f", "concat", "-safe", "0", "-i", "list.txt", "-c", "copy", "-movflags", "+faststart", "-f", "mp4", "out.mp4"}},
+		{"iPhone5s", []string{"-f", "concat", "-safe", "0", "-i", "list.txt", "-c", "copy", "-f", "mp4", "out.mp4"}},
+	}
+
+	for _, tt := range tests {
+		got := NewProfile()[tt.profile].ConcatArgs("list.txt", "out.mp4")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ConcatArgs() =\n%q\nwant\n%q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"yadif", true},
+		{"scale=640:-2", true},
+		{"crop=in_w-100:in_h:50:0", true},
+		{"pad=1280:720:(ow-iw)/2:(oh-ih)/2", true},
+		{"fps=30", true},
+		{"drawtext=text='a b':x=10:y=h-th-10:fontsize=24", true},
+		{"drawtext=text='a\\:b'", true},
+
+		{"", false},
+		{"movie=/etc/passwd", false},
+		{"subtitles=/etc/passwd", false},
+		{"ass=/etc/passwd", false},
+		{"lut3d=/etc/passwd", false},
+		{"drawtext=textfile=/etc/passwd", false},
+		{"drawtext=text='x':fontfile=/etc/passwd", false},
+		{"drawtext=/etc/passwd", false},
+		{"drawtext=font='Sans'", false},
+
+		// Hidden by the filtergraph escaping, seen by the options one
+		{"drawtext=text='a:textfile=/etc/passwd'", false},
+		{"drawtext=text=a\\:textfile=/etc/passwd", false},
+
+		// More filters in the chain
+		{"scale=2:2,drawtext=textfile=/etc/passwd", false},
+		{"scale=2:2;movie=/etc/passwd", false},
+		{"scale=2:2[out]", false},
+		{"yadif@x", false},
+	}
+
+	for _, tt := range tests {
+		f := ProfileFFMPEG{VideoCodec: "libx264", AudioCodec: "aac", Container: "mp4", Filters: []string{tt.filter}}
+
+		err := f.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: Validate() = %v, want valid %v", tt.filter, err, tt.valid)
+		}
+	}
+}
+
+func TestParseFFMPEGArgs(t *testing.T) {
+	tests := []struct {
+		args  string
+		want  ProfileFFMPEG
+		valid bool
+	}{
+		{"-movflags faststart -profile:v baseline -level 3.0",
+			ProfileFFMPEG{Name: "old", VideoCodec: "libx264", Profile: "baseline", Level: "3.0", AudioCodec: "aac", Container: "mp4", FastStart: true}, true},
+		{"-profile:v high -level 4.2",
+			ProfileFFMPEG{Name: "old", VideoCodec: "libx264", Profile: "high", Level: "4.2", AudioCodec: "aac", Container: "mp4"}, true},
+		{"-c:v libx265 -preset slow -crf 28 -c:a aac -b:a 128k -vf yadif,hflip",
+			ProfileFFMPEG{Name: "old", VideoCodec: "libx265", Preset: "slow", CRF: 28, AudioCodec: "aac", AudioBitrate: "128k", Filters: []string{"yadif", "hflip"}, Container: "mp4"}, true},
+
+		{"", ProfileFFMPEG{Name: "old", VideoCodec: "libx264", AudioCodec: "aac", Container: "mp4"}, true},
+		{"-profile:v", ProfileFFMPEG{}, false},
+		{"-s 640x480", ProfileFFMPEG{}, false},
+		{"-movflags frag_keyframe", ProfileFFMPEG{}, false},
+		{"-crf high", ProfileFFMPEG{}, false},
+		{"-vf movie=/etc/passwd", ProfileFFMPEG{}, false},
+		{"-preset fastest", ProfileFFMPEG{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFFMPEGArgs("old", tt.args)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: ParseFFMPEGArgs() = %v, want valid %v", tt.args, err, tt.valid)
+			continue
+		}
+
+		if tt.valid && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: ParseFFMPEGArgs() =\n%+v\nwant\n%+v", tt.args, got, tt.want)
+		}
+	}
+}
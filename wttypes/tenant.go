@@ -0,0 +1,13 @@
+package wttypes
+
+// TENANT_DEFAULT is used for jobs that don't specify a tenant
+const TENANT_DEFAULT = "default"
+
+// TenantStatus is a struct with the fair-share information of a tenant
+type TenantStatus struct {
+	Name    string `json:"name"`
+	Weight  int    `json:"weight"`
+	Cap     int    `json:"cap,omitempty"`
+	Queued  int    `json:"queued"`
+	Running int    `json:"running"`
+}
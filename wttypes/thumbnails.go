@@ -0,0 +1,93 @@
+package wttypes
+
+// Names of the files of a thumbnails task
+const (
+	THUMBNAILS_POSTER = "poster.jpg"
+	THUMBNAILS_VTT    = "thumbnails.vtt"
+)
+
+// Defaults of a thumbnails task
+const (
+	THUMBNAILS_INTERVAL = 10
+	THUMBNAILS_WIDTH    = 160
+	THUMBNAILS_HEIGHT   = 90
+	THUMBNAILS_COLUMNS  = 10
+	THUMBNAILS_MAX      = 1000
+)
+
+// ThumbnailOptions is a struct with which frames of the media are extracted,
+// either every interval or at specific timestamps (in seconds)
+type ThumbnailOptions struct {
+	Interval   float64   `json:"interval,omitempty"`
+	Timestamps []float64 `json:"timestamps,omitempty"`
+
+	// Size of every thumbnail in the sprite sheet
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// Thumbnails per row and per column of every sprite sheet
+	Columns int `json:"columns,omitempty"`
+	Rows    int `json:"rows,omitempty"`
+
+	// Timestamp of the poster frame, 10% of the media if not specified
+	Poster float64 `json:"poster,omitempty"`
+}
+
+// WithDefaults returns the options filling the ones not specified
+func (t ThumbnailOptions) WithDefaults() ThumbnailOptions {
+	if t.Interval == 0 && len(t.Timestamps) == 0 {
+		t.Interval = THUMBNAILS_INTERVAL
+	}
+
+	if t.Width == 0 && t.Height == 0 {
+		t.Width = THUMBNAILS_WIDTH
+		t.Height = THUMBNAILS_HEIGHT
+	}
+
+	if t.Columns == 0 {
+		t.Columns = THUMBNAILS_COLUMNS
+	}
+
+	if t.Rows == 0 {
+		t.Rows = t.Columns
+	}
+
+	return t
+}
+
+// Validate verifies the thumbnails can be extracted
+func (t ThumbnailOptions) Validate() error {
+	// Either interval or timestamps
+	if (t.Interval > 0) == (len(t.Timestamps) > 0) {
+		return ErrInvalidThumbnails
+	}
+
+	if t.Interval < 0 || (t.Interval > 0 && t.Interval < 1) {
+		return ErrInvalidThumbnails
+	}
+
+	if len(t.Timestamps) > THUMBNAILS_MAX {
+		return ErrInvalidThumbnails
+	}
+
+	// Timestamps in order, so the thumbnails track is too
+	for i, v := range t.Timestamps {
+		if v < 0 || (i > 0 && v <= t.Timestamps[i-1]) {
+			return ErrInvalidThumbnails
+		}
+	}
+
+	if t.Width < 16 || t.Height < 16 || t.Width > 1920 || t.Height > 1080 {
+		return ErrInvalidThumbnails
+	}
+
+	if t.Columns < 1 || t.Rows < 1 || t.Columns*t.Width > 16384 || t.Rows*t.Height > 16384 {
+		return ErrInvalidThumbnails
+	}
+
+	if t.Poster < 0 {
+		return ErrInvalidThumbnails
+	}
+
+	return nil
+}
@@ -1,5 +1,7 @@
 package wttypes
 
+import "time"
+
 const (
 	TRANSCODING_QUEUED    = "queued"
 	TRANSCODING_REQUESTED = "requested"
@@ -8,12 +10,55 @@ const (
 	TRANSCODING_FINISHED  = "finished"
 	TRANSCODING_ERROR     = "error"
 	TRANSCODING_SKIPPED   = "skipped"
+
+	// Waiting in manager for the tasks it depends on
+	TRANSCODING_WAITING = "waiting"
+)
+
+// Kinds of task of a job
+const (
+	TASK_TRANSCODING = "transcoding"
+	TASK_THUMBNAILS  = "thumbnails"
+	TASK_CHUNK       = "chunk"
+	TASK_STITCH      = "stitch"
 )
 
 // TranscodingTask is a struct with information regarding the transcoding
 type TranscodingTask struct {
 	ID         string `json:"id"`
+	JobID      string `json:"job_id,omitempty"`
 	Profile    string `json:"profile,omitempty"`
 	ObjectName string `json:"object_name,omitempty"`
 	Status     string `json:"status,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+
+	Added   *time.Time `json:"added,omitempty"`
+	Started *time.Time `json:"started,omitempty"`
+	Ended   *time.Time `json:"ended,omitempty"`
+
+	// Where the output can be fetched from, once finished
+	DownloadURL string `json:"download_url,omitempty"`
+
+	// Profiles of an ABR ladder, transcoded together instead of Profile
+	Renditions []string    `json:"renditions,omitempty"`
+	ABR        *ABROptions `json:"abr,omitempty"`
+
+	// Kind of task, a transcoding if empty
+	Kind       string            `json:"kind,omitempty"`
+	Thumbnails *ThumbnailOptions `json:"thumbnails,omitempty"`
+
+	// Transcodings to end before this one is queued, a stitch joins them in order
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	Progress *TranscodingProgress `json:"progress,omitempty"`
+}
+
+// TranscodingProgress is a struct with how far a running transcoding is
+type TranscodingProgress struct {
+	Percent float64 `json:"percent"`
+	FPS     float64 `json:"fps"`
+
+	// Estimated seconds to finish
+	ETA int `json:"eta"`
 }
@@ -0,0 +1,93 @@
+package wttypes
+
+import (
+	"fmt"
+	"time"
+)
+
+// Statuses of a resumable upload
+const (
+	UPLOAD_UPLOADING = "uploading"
+	UPLOAD_FINISHING = "finishing"
+	UPLOAD_FINISHED  = "finished"
+)
+
+// Sizes of the chunks of a resumable upload, each one is a segment in object storage
+const (
+	// Only the last chunk can be smaller
+	UPLOAD_MIN_CHUNK = 1 << 20
+
+	// Biggest object Swift takes
+	UPLOAD_MAX_CHUNK = 5 << 30
+)
+
+// Headers of the resumable upload protocol (tus 1.0.0)
+const (
+	UPLOAD_HEADER_RESUMABLE = "Tus-Resumable"
+	UPLOAD_HEADER_OFFSET    = "Upload-Offset"
+	UPLOAD_HEADER_LENGTH    = "Upload-Length"
+
+	UPLOAD_CONTENT_TYPE = "application/offset+octet-stream"
+	UPLOAD_TUS_VERSION  = "1.0.0"
+)
+
+// UploadSegment is a chunk of an upload, stored as an object of its own
+type UploadSegment struct {
+	ObjectName string `json:"object_name"`
+	Offset     int64  `json:"offset"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
+}
+
+// Upload is a resumable upload of the media of a job, sent in chunks one after
+// another. Once all of them are there, the job is created with the media.
+type Upload struct {
+	ID       string `json:"id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+
+	// Size of the media and how much of it was uploaded, in bytes
+	Length int64 `json:"length"`
+	Offset int64 `json:"offset"`
+
+	// The job created when finished, as submitted
+	Job Job `json:"job"`
+
+	Status   string          `json:"status,omitempty"`
+	JobID    string          `json:"job_id,omitempty"`
+	Segments []UploadSegment `json:"segments,omitempty"`
+
+	// Large object of the media, once it's being finished
+	ObjectName string `json:"object_name,omitempty"`
+
+	Added   *time.Time `json:"added,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// Validate verifies an upload to be created, the job is validated apart
+func (u Upload) Validate() error {
+	fields := map[string]string{}
+
+	// Set by the service
+	if u.ID != "" || u.Offset != 0 || u.Status != "" || u.JobID != "" || len(u.Segments) > 0 || u.ObjectName != "" {
+		fields["upload"] = "only length, filename and job can be set"
+	}
+
+	if u.Length <= 0 {
+		fields["length"] = "required, in bytes"
+	}
+
+	if len(u.Filename) > JOB_MAX_VIDEO_NAME {
+		fields["filename"] = fmt.Sprintf("up to %d characters", JOB_MAX_VIDEO_NAME)
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidUpload.WithDetails(fields)
+	}
+
+	return nil
+}
+
+// Complete tells if all the media was uploaded
+func (u Upload) Complete() bool {
+	return u.Offset == u.Length
+}
@@ -0,0 +1,101 @@
+package wttypes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"time"
+)
+
+// Events a webhook can be notified of
+const (
+	EVENT_JOB_FINISHED         = "job.finished"
+	EVENT_JOB_ERROR            = "job.error"
+	EVENT_JOB_CANCELLED        = "job.cancelled"
+	EVENT_TRANSCODING_FINISHED = "transcoding.finished"
+)
+
+// Statuses of a webhook delivery
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+// Headers of a webhook request
+const (
+	WEBHOOK_HEADER_EVENT     = "X-Webhook-Event"
+	WEBHOOK_HEADER_DELIVERY  = "X-Webhook-Delivery"
+	WEBHOOK_HEADER_SIGNATURE = "X-Webhook-Signature"
+)
+
+var webhookEvents = []string{EVENT_JOB_FINISHED, EVENT_JOB_ERROR, EVENT_JOB_CANCELLED, EVENT_TRANSCODING_FINISHED}
+
+// Webhook is a struct with where the events of a job are delivered
+type Webhook struct {
+	URL string `json:"url"`
+
+	// Events to deliver, all of them if empty
+	Events []string `json:"events,omitempty"`
+
+	// Key of the HMAC-SHA256 signature of every delivery, never returned back
+	Secret string `json:"secret,omitempty"`
+}
+
+// Validate verifies the webhook can be delivered and signed
+func (w Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhook
+	}
+
+	if w.Secret == "" {
+		return ErrInvalidWebhook
+	}
+
+	for _, v := range w.Events {
+		if !strInSlice(v, webhookEvents) {
+			return ErrInvalidWebhook
+		}
+	}
+
+	return nil
+}
+
+// Wants tells if the event is delivered to the webhook
+func (w Webhook) Wants(event string) bool {
+	return len(w.Events) == 0 || strInSlice(event, w.Events)
+}
+
+// WebhookEvent is the body of a webhook delivery
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+
+	Job         Job              `json:"job"`
+	Transcoding *TranscodingTask `json:"transcoding,omitempty"`
+}
+
+// WebhookDelivery is a struct with the attempts to deliver an event of a job
+type WebhookDelivery struct {
+	ID           string    `json:"id"`
+	JobID        string    `json:"job_id"`
+	Event        string    `json:"event"`
+	URL          string    `json:"url"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	Added        time.Time `json:"added"`
+	Delivered    time.Time `json:"delivered,omitempty"`
+}
+
+// SignWebhook returns the signature of a webhook body, "sha256=" and the hex HMAC
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,5 +1,11 @@
 package wttypes
 
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
 const (
 	WORKER_STATUS_ONLINE  = "online"
 	WORKER_STATUS_IDLE    = "idle"
@@ -8,6 +14,127 @@ const (
 )
 
 type WorkerStatus struct {
-	Addr   string `json:"addr,omitempty"`
-	Status string `json:"status,omitempty"`
+	Addr         string              `json:"addr,omitempty"`
+	Status       string              `json:"status,omitempty"`
+	Capabilities *WorkerCapabilities `json:"capabilities,omitempty"`
+}
+
+// SlotStatus is a struct with the status of one of the task slots of a worker
+type SlotStatus struct {
+	Slot   int    `json:"slot"`
+	TaskID string `json:"task_id,omitempty"`
+	Status string `json:"status"`
+}
+
+// WorkerCapabilities is a struct with what a worker is able to transcode
+type WorkerCapabilities struct {
+	Encoders  []string `json:"encoders,omitempty"`
+	MaxWidth  int      `json:"max_width,omitempty"`
+	MaxHeight int      `json:"max_height,omitempty"`
+	CPUs      int      `json:"cpus,omitempty"`
+	DiskFree  int64    `json:"disk_free,omitempty"`
+}
+
+// Satisfies tells if the worker capabilities are enough for the requirements,
+// zero values in the capabilities mean unknown/unlimited
+func (c WorkerCapabilities) Satisfies(r ProfileRequirements) bool {
+	if len(c.Encoders) > 0 {
+		for _, e := range r.Encoders {
+			if !strInSlice(e, c.Encoders) {
+				return false
+			}
+		}
+	}
+
+	if c.MaxWidth > 0 && r.Width > c.MaxWidth {
+		return false
+	}
+
+	if c.MaxHeight > 0 && r.Height > c.MaxHeight {
+		return false
+	}
+
+	if c.CPUs > 0 && r.MinCPUs > c.CPUs {
+		return false
+	}
+
+	if c.DiskFree > 0 && r.MinDiskFree > c.DiskFree {
+		return false
+	}
+
+	return true
+}
+
+// Values encodes the capabilities as URL query parameters
+func (c WorkerCapabilities) Values() url.Values {
+	v := url.Values{}
+
+	if len(c.Encoders) > 0 {
+		v.Set("encoders", strings.Join(c.Encoders, ","))
+	}
+	if c.MaxWidth > 0 {
+		v.Set("max_width", strconv.Itoa(c.MaxWidth))
+	}
+	if c.MaxHeight > 0 {
+		v.Set("max_height", strconv.Itoa(c.MaxHeight))
+	}
+	if c.CPUs > 0 {
+		v.Set("cpus", strconv.Itoa(c.CPUs))
+	}
+	if c.DiskFree > 0 {
+		v.Set("disk_free", strconv.FormatInt(c.DiskFree, 10))
+	}
+
+	return v
+}
+
+// CapabilitiesFromValues decodes the capabilities from URL query parameters,
+// returns nil when there are none
+func CapabilitiesFromValues(v url.Values) (*WorkerCapabilities, error) {
+	var err error
+	c := WorkerCapabilities{}
+	found := false
+
+	if s := v.Get("encoders"); s != "" {
+		c.Encoders = strings.Split(s, ",")
+		found = true
+	}
+
+	ints := map[string]*int{
+		"max_width":  &c.MaxWidth,
+		"max_height": &c.MaxHeight,
+		"cpus":       &c.CPUs,
+	}
+	for name, dst := range ints {
+		if s := v.Get(name); s != "" {
+			*dst, err = strconv.Atoi(s)
+			if err != nil {
+				return nil, ErrInvalidArgument
+			}
+			found = true
+		}
+	}
+
+	if s := v.Get("disk_free"); s != "" {
+		c.DiskFree, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidArgument
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return &c, nil
+}
+
+func strInSlice(str string, list []string) bool {
+	for _, v := range list {
+		if str == v {
+			return true
+		}
+	}
+	return false
 }